@@ -0,0 +1,53 @@
+// Команда accrual-sim запускает симулятор системы расчёта начислений
+// для локальной разработки gophermart.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/accrualsim"
+	"github.com/beliaevke/go-musthave-diploma/internal/logger"
+)
+
+func main() {
+	var (
+		runAddr    string
+		scriptPath string
+		cfg        accrualsim.Config
+	)
+	flag.StringVar(&runAddr, "a", "localhost:8081", "Address and port to run server")
+	flag.StringVar(&scriptPath, "script", "", "Path to YAML script with responses per order")
+	flag.DurationVar(&cfg.RegisteredDelay, "registered-delay", time.Second, "How long an order stays REGISTERED")
+	flag.DurationVar(&cfg.ProcessingDelay, "processing-delay", 2*time.Second, "How long an order stays PROCESSING")
+	flag.Float64Var(&cfg.InvalidRate, "invalid-rate", 0, "Share of orders (0..1) that become INVALID")
+	flag.BoolVar(&cfg.AutoRegister, "auto-register", true, "Register unknown orders on first request instead of 204")
+	flag.Float64Var(&cfg.DefaultAccrual, "default-accrual", 100, "Accrual for auto-registered orders")
+	flag.IntVar(&cfg.RateLimit, "rate-limit", 0, "Requests per minute before 429, 0 for unlimited")
+	flag.DurationVar(&cfg.RetryAfter, "retry-after", 0, "Retry-After for 429, defaults to the rest of the minute")
+	flag.Float64Var(&cfg.ErrorRate, "error-rate", 0, "Share of requests (0..1) answered with 500")
+	flag.Float64Var(&cfg.SlowRate, "slow-rate", 0, "Share of requests (0..1) answered after -slow-delay")
+	flag.DurationVar(&cfg.SlowDelay, "slow-delay", 5*time.Second, "Delay of slow responses")
+	flag.Parse()
+
+	if envRunAddr := os.Getenv("RUN_ADDRESS"); envRunAddr != "" {
+		runAddr = envRunAddr
+	}
+
+	sim := accrualsim.New(cfg)
+	if scriptPath != "" {
+		script, err := accrualsim.LoadScript(scriptPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sim.ApplyScript(script)
+	}
+
+	logger.ServerRunningInfo(runAddr)
+	if err := http.ListenAndServe(runAddr, logger.WithLogging(sim.Handler())); err != nil && err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/config"
+)
+
+// runConfig выполняет подкоманду gophermart config
+func runConfig(args []string) error {
+	if len(args) == 0 || args[0] != "print" {
+		return errors.New("usage: gophermart config print [flags]")
+	}
+
+	// выводим итоговые настройки с учётом файла, переменных окружения и флагов
+	cfg, err := config.Load(args[1:])
+	if err != nil {
+		return err
+	}
+	if err := cfg.Print(os.Stdout); err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("config is not valid: %w", err)
+	}
+	return nil
+}
@@ -3,18 +3,41 @@ package main
 import (
 	"context"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/beliaevke/go-musthave-diploma/internal/app"
 	"github.com/beliaevke/go-musthave-diploma/internal/config"
-	"github.com/beliaevke/go-musthave-diploma/internal/db/migrations"
 )
 
 func main() {
 
-	cfg := config.NewConfig()
-	ctx := context.Background()
+	args := os.Args[1:]
+	if len(args) > 0 {
+		switch args[0] {
+		case "config":
+			if err := runConfig(args[1:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "migrate":
+			if err := runMigrate(args[1:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
+	cfg, err := config.NewConfig(args)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// ctx отменяется при остановке сервиса: по нему завершаются сервер и фоновые обработчики
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	if err := migrations.Run(cfg, ctx); err != nil {
+	if err := migrateOnStart(ctx, cfg); err != nil {
 		log.Fatal(err)
 	}
 
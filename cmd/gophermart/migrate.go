@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/config"
+	"github.com/beliaevke/go-musthave-diploma/internal/db/migrations"
+)
+
+// runMigrate выполняет подкоманду gophermart migrate
+func runMigrate(args []string) error {
+	if len(args) == 0 || !slices.Contains(migrations.Commands, args[0]) {
+		return errors.New("usage: gophermart migrate " + strings.Join(migrations.Commands, "|") + " [flags]")
+	}
+
+	cfg, err := config.NewConfig(args[1:])
+	if err != nil {
+		return err
+	}
+	return migrations.Exec(context.Background(), cfg, args[0], os.Stdout)
+}
+
+// migrateOnStart применяет новые миграции при запуске сервера, если они не отключены
+// (-skip-migrations) и данные хранятся в Postgres
+func migrateOnStart(ctx context.Context, cfg config.ServerFlags) error {
+	if cfg.SkipMigrations || cfg.Storage != config.StoragePostgres {
+		return nil
+	}
+	return migrations.Run(cfg, ctx)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/config"
+)
+
+func TestRunMigrateUsage(t *testing.T) {
+	for _, args := range [][]string{nil, {"sideways"}, {"-d", "postgres://localhost/db", "up"}} {
+		if err := runMigrate(args); err == nil || !strings.HasPrefix(err.Error(), "usage: gophermart migrate up|down|status|version|redo") {
+			t.Errorf("runMigrate(%q): expected usage error; got %v", args, err)
+		}
+	}
+	// настройки проверяются до подключения к базе
+	t.Setenv("DATABASE_URI", "")
+	if err := runMigrate([]string{"up", "-migrations-timeout", "0s"}); err == nil || !strings.Contains(err.Error(), "migrations_timeout") {
+		t.Errorf("Expected invalid config error; got %v", err)
+	}
+}
+
+func TestMigrateOnStart(t *testing.T) {
+	// по этому адресу никто не слушает: попытка применить миграции завершится ошибкой
+	unreachable := config.Default()
+	unreachable.FlagDatabaseURI = "postgres://postgres@127.0.0.1:1/postgres?sslmode=disable&connect_timeout=1"
+	unreachable.MigrationsTimeout = 2 * time.Second
+
+	testCases := []struct {
+		name      string
+		configure func(cfg *config.ServerFlags)
+		expectErr bool
+	}{
+		{name: "postgres", configure: func(cfg *config.ServerFlags) {}, expectErr: true},
+		{name: "skip migrations", configure: func(cfg *config.ServerFlags) { cfg.SkipMigrations = true }},
+		{name: "memory storage", configure: func(cfg *config.ServerFlags) { cfg.Storage = config.StorageMemory }},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := unreachable
+			tc.configure(&cfg)
+			err := migrateOnStart(context.Background(), cfg)
+			if (err != nil) != tc.expectErr {
+				t.Errorf("Expected error: %v; got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
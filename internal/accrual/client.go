@@ -0,0 +1,288 @@
+// Package accrual — клиент системы расчёта начислений
+package accrual
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/middleware/requestid"
+)
+
+// Status — результат расчёта начислений по заказу
+type Status int
+
+const (
+	// NotRegistered — заказ не зарегистрирован в системе расчёта (ответ 204)
+	NotRegistered Status = iota
+	// Registered — заказ зарегистрирован, но начисление не рассчитано
+	Registered
+	// Processing — расчёт начисления в процессе
+	Processing
+	// Invalid — заказ не принят к расчёту, вознаграждение не будет начислено
+	Invalid
+	// Processed — расчёт начисления окончен
+	Processed
+)
+
+func (s Status) String() string {
+	switch s {
+	case NotRegistered:
+		return "NOT_REGISTERED"
+	case Registered:
+		return "REGISTERED"
+	case Processing:
+		return "PROCESSING"
+	case Invalid:
+		return "INVALID"
+	case Processed:
+		return "PROCESSED"
+	}
+	return "UNKNOWN"
+}
+
+// ParseStatus разбирает статус из ответа системы расчёта
+func ParseStatus(s string) (Status, error) {
+	switch s {
+	case "REGISTERED":
+		return Registered, nil
+	case "PROCESSING":
+		return Processing, nil
+	case "INVALID":
+		return Invalid, nil
+	case "PROCESSED":
+		return Processed, nil
+	}
+	return NotRegistered, fmt.Errorf("unknown accrual status %q", s)
+}
+
+// Result — ответ системы расчёта по заказу
+type Result struct {
+	Order   string
+	Status  Status
+	Accrual float32
+	// Raw — тело ответа системы расчёта без изменений, пустое для ответа 204
+	Raw []byte
+}
+
+// Client — клиент системы расчёта начислений
+type Client interface {
+	GetOrder(ctx context.Context, number string) (Result, error)
+}
+
+var (
+	// ErrCircuitOpen — система расчёта недоступна, запросы временно не отправляются
+	ErrCircuitOpen = errors.New("accrual system is unavailable, circuit breaker is open")
+	// ErrNotConfigured — адрес системы расчёта не задан
+	ErrNotConfigured = errors.New("accrual system address is not configured")
+)
+
+// RateLimitError — система расчёта ограничила число запросов (ответ 429)
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return "accrual system rate limit exceeded, retry after " + e.RetryAfter.String()
+}
+
+// StatusError — неожиданный код ответа системы расчёта
+type StatusError struct {
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return "accrual system responded with status " + strconv.Itoa(e.Code)
+}
+
+// Config — настройки клиента
+type Config struct {
+	// Address — адрес системы расчёта, например http://localhost:8081
+	Address string
+	// Timeout — таймаут одного HTTP-запроса
+	Timeout time.Duration
+	// Transport — транспорт HTTP-клиента, по умолчанию http.DefaultTransport
+	Transport http.RoundTripper
+	// Retries — число повторов при ошибках сети и ответах 5xx
+	Retries int
+	// BackoffBase и BackoffMax — начальная и максимальная пауза между повторами
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+	// BreakerThreshold — после скольких неудачных запросов подряд клиент перестаёт обращаться к системе
+	BreakerThreshold int
+	// BreakerCooldown — через сколько после размыкания пробовать снова
+	BreakerCooldown time.Duration
+}
+
+// DefaultConfig возвращает настройки по умолчанию для адреса address
+func DefaultConfig(address string) Config {
+	return Config{
+		Address:          address,
+		Timeout:          5 * time.Second,
+		Retries:          3,
+		BackoffBase:      100 * time.Millisecond,
+		BackoffMax:       2 * time.Second,
+		BreakerThreshold: 5,
+		BreakerCooldown:  30 * time.Second,
+	}
+}
+
+// HTTPClient — клиент системы расчёта по HTTP
+type HTTPClient struct {
+	cfg     Config
+	client  *http.Client
+	breaker *breaker
+}
+
+// NewClient создаёт клиента системы расчёта
+func NewClient(cfg Config) *HTTPClient {
+	transport := cfg.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &HTTPClient{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: cfg.Timeout, Transport: transport},
+		breaker: newBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown),
+	}
+}
+
+// GetOrder запрашивает расчёт начислений по заказу.
+// Ошибки сети и ответы 5xx повторяются с экспоненциальной паузой;
+// при ответе 429 возвращается *RateLimitError, при разомкнутом предохранителе — ErrCircuitOpen.
+func (c *HTTPClient) GetOrder(ctx context.Context, number string) (Result, error) {
+	if c.cfg.Address == "" {
+		return Result{}, ErrNotConfigured
+	}
+	if !c.breaker.allow() {
+		return Result{}, ErrCircuitOpen
+	}
+
+	var (
+		result Result
+		err    error
+	)
+	for attempt := 0; ; attempt++ {
+		result, err = c.getOrder(ctx, number)
+		if !retryable(err) || attempt >= c.cfg.Retries {
+			break
+		}
+		if !c.wait(ctx, attempt) {
+			break
+		}
+	}
+
+	switch {
+	case ctx.Err() != nil:
+		// запрос отменён вызывающей стороной, доступность системы неизвестна
+		c.breaker.release()
+	case retryable(err):
+		c.breaker.failure()
+	default:
+		c.breaker.success()
+	}
+	return result, err
+}
+
+func (c *HTTPClient) getOrder(ctx context.Context, number string) (Result, error) {
+	result := Result{Order: number}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.cfg.Address+"/api/orders/"+url.PathEscape(number), nil)
+	if err != nil {
+		return result, err
+	}
+	if id := requestid.FromContext(ctx); id != "" {
+		request.Header.Set(requestid.Header, id)
+	}
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return result, err
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return result, err
+	}
+
+	switch {
+	case response.StatusCode == http.StatusNoContent:
+		result.Status = NotRegistered
+		return result, nil
+	case response.StatusCode == http.StatusTooManyRequests:
+		return result, &RateLimitError{RetryAfter: parseRetryAfter(response.Header.Get("Retry-After"))}
+	case response.StatusCode != http.StatusOK:
+		return result, &StatusError{Code: response.StatusCode}
+	}
+
+	var respBody struct {
+		Order   string  `json:"order"`
+		Status  string  `json:"status"`
+		Accrual float32 `json:"accrual"`
+	}
+	if err := json.Unmarshal(body, &respBody); err != nil {
+		return result, fmt.Errorf("unmarshal accrual response: %w", err)
+	}
+	result.Raw = body
+	result.Status, err = ParseStatus(respBody.Status)
+	if err != nil {
+		return result, err
+	}
+	if result.Status == Processed {
+		result.Accrual = respBody.Accrual
+	}
+	return result, nil
+}
+
+// wait выдерживает паузу перед повтором: экспоненциальный рост с полным джиттером
+func (c *HTTPClient) wait(ctx context.Context, attempt int) bool {
+	backoff := c.cfg.BackoffBase << attempt
+	if backoff <= 0 || (c.cfg.BackoffMax > 0 && backoff > c.cfg.BackoffMax) {
+		backoff = c.cfg.BackoffMax
+	}
+	if backoff > 0 {
+		backoff = rand.N(backoff) + 1
+	}
+	t := time.NewTimer(backoff)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// retryable сообщает, имеет ли смысл повторить запрос:
+// повторяются ошибки сети и ответы 5xx
+func retryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code >= http.StatusInternalServerError
+	}
+	var netErr net.Error
+	var urlErr *url.Error
+	return errors.As(err, &netErr) || errors.As(err, &urlErr)
+}
+
+func parseRetryAfter(v string) time.Duration {
+	if seconds, err := strconv.Atoi(v); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(v); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+	}
+	return time.Minute
+}
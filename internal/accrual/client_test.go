@@ -0,0 +1,121 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testConfig(address string) Config {
+	cfg := DefaultConfig(address)
+	cfg.BackoffBase = time.Millisecond
+	cfg.BackoffMax = 5 * time.Millisecond
+	return cfg
+}
+
+func TestGetOrder(t *testing.T) {
+	testCases := []struct {
+		name            string
+		status          int
+		body            string
+		retryAfter      string
+		expectedStatus  Status
+		expectedAccrual float32
+		expectedErr     error
+	}{
+		{name: "registered", status: http.StatusOK, body: `{"order":"1","status":"REGISTERED"}`, expectedStatus: Registered},
+		{name: "processing", status: http.StatusOK, body: `{"order":"1","status":"PROCESSING"}`, expectedStatus: Processing},
+		{name: "invalid", status: http.StatusOK, body: `{"order":"1","status":"INVALID"}`, expectedStatus: Invalid},
+		{name: "processed", status: http.StatusOK, body: `{"order":"1","status":"PROCESSED","accrual":729.98}`, expectedStatus: Processed, expectedAccrual: 729.98},
+		{name: "not registered", status: http.StatusNoContent, expectedStatus: NotRegistered},
+		{name: "rate limited", status: http.StatusTooManyRequests, retryAfter: "60", expectedErr: &RateLimitError{}},
+		{name: "server error", status: http.StatusInternalServerError, expectedErr: &StatusError{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls atomic.Int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				if tc.retryAfter != "" {
+					w.Header().Set("Retry-After", tc.retryAfter)
+				}
+				w.WriteHeader(tc.status)
+				io.WriteString(w, tc.body) //nolint
+			}))
+			defer ts.Close()
+
+			client := NewClient(testConfig(ts.URL))
+			result, err := client.GetOrder(context.Background(), "1")
+			switch expected := tc.expectedErr.(type) {
+			case nil:
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if result.Status != tc.expectedStatus || result.Accrual != tc.expectedAccrual {
+					t.Errorf("Expected %s %v; got %s %v", tc.expectedStatus, tc.expectedAccrual, result.Status, result.Accrual)
+				}
+			case *RateLimitError:
+				if !errors.As(err, &expected) || expected.RetryAfter != time.Minute {
+					t.Errorf("Expected rate limit error with 1m; got %v", err)
+				}
+			case *StatusError:
+				if !errors.As(err, &expected) {
+					t.Errorf("Expected status error; got %v", err)
+				}
+				if calls.Load() != 4 {
+					t.Errorf("Expected 4 attempts for 5xx; got %d", calls.Load())
+				}
+			}
+		})
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var calls atomic.Int32
+	var healthy atomic.Bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	cfg := testConfig(ts.URL)
+	cfg.Retries = 0
+	cfg.BreakerThreshold = 2
+	cfg.BreakerCooldown = time.Minute
+	client := NewClient(cfg)
+	now := time.Now()
+	client.breaker.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.GetOrder(context.Background(), "1"); err == nil {
+			t.Fatalf("Expected error from unavailable system")
+		}
+	}
+	if _, err := client.GetOrder(context.Background(), "1"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected ErrCircuitOpen; got %v", err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("Expected no requests while circuit is open; got %d", calls.Load())
+	}
+
+	// после паузы пробный запрос замыкает предохранитель
+	healthy.Store(true)
+	now = now.Add(time.Minute)
+	if _, err := client.GetOrder(context.Background(), "1"); err != nil {
+		t.Fatalf("Expected probe request to succeed; got %v", err)
+	}
+	if _, err := client.GetOrder(context.Background(), "1"); err != nil {
+		t.Errorf("Expected closed circuit; got %v", err)
+	}
+}
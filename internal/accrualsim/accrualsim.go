@@ -0,0 +1,320 @@
+// Package accrualsim реализует симулятор системы расчёта начислений
+// для локальной разработки и тестов.
+//
+// Симулятор поддерживает API системы начислений:
+//   - POST /api/goods — регистрация правила вознаграждения за товар;
+//   - POST /api/orders — регистрация заказа с товарами;
+//   - GET /api/orders/{number} — расчёт начислений по заказу.
+//
+// Поведение настраивается через Config: задержки переходов
+// REGISTERED → PROCESSING → PROCESSED, доля недействительных заказов,
+// ограничение числа запросов (429 с Retry-After), доля ошибок 500 и медленных
+// ответов. В сценарном режиме ответы по конкретным заказам задаются заранее (Script).
+package accrualsim
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+// Статусы расчёта начислений
+const (
+	StatusRegistered = "REGISTERED"
+	StatusProcessing = "PROCESSING"
+	StatusInvalid    = "INVALID"
+	StatusProcessed  = "PROCESSED"
+)
+
+// Типы вознаграждения
+const (
+	RewardPercent = "%"
+	RewardPoints  = "pt"
+)
+
+// Config — настройки симулятора
+type Config struct {
+	// RegisteredDelay — сколько заказ остаётся в статусе REGISTERED
+	RegisteredDelay time.Duration
+	// ProcessingDelay — сколько заказ остаётся в статусе PROCESSING
+	ProcessingDelay time.Duration
+	// InvalidRate — доля заказов (0..1), которые получают статус INVALID
+	InvalidRate float64
+	// AutoRegister — незарегистрированные заказы регистрируются при первом запросе
+	// с начислением DefaultAccrual вместо ответа 204
+	AutoRegister   bool
+	DefaultAccrual float64
+	// RateLimit — допустимое число запросов расчёта в минуту, 0 — без ограничения
+	RateLimit  int
+	RetryAfter time.Duration
+	// ErrorRate — доля запросов (0..1), на которые отвечаем 500
+	ErrorRate float64
+	// SlowRate — доля запросов (0..1), ответ на которые задерживается на SlowDelay
+	SlowRate  float64
+	SlowDelay time.Duration
+}
+
+// Reward — правило вознаграждения за товары, в описании которых встречается Match
+type Reward struct {
+	Match      string  `json:"match"`
+	Reward     float64 `json:"reward"`
+	RewardType string  `json:"reward_type"`
+}
+
+// Good — товар в составе заказа
+type Good struct {
+	Description string  `json:"description"`
+	Price       float64 `json:"price"`
+}
+
+// OrderRequest — тело запроса POST /api/orders
+type OrderRequest struct {
+	Order string `json:"order"`
+	Goods []Good `json:"goods"`
+}
+
+// OrderResponse — тело ответа GET /api/orders/{number}
+type OrderResponse struct {
+	Order   string   `json:"order"`
+	Status  string   `json:"status"`
+	Accrual *float64 `json:"accrual,omitempty"`
+}
+
+type order struct {
+	registeredAt time.Time
+	goods        []Good
+	invalid      bool
+	accrual      *float64
+}
+
+// Simulator — симулятор системы расчёта начислений
+type Simulator struct {
+	cfg Config
+	now func() time.Time
+
+	mu      sync.Mutex
+	rewards []Reward
+	orders  map[string]*order
+	scripts map[string][]Step
+
+	windowStart time.Time
+	windowCount int
+}
+
+// New создаёт симулятор с настройками cfg
+func New(cfg Config) *Simulator {
+	return &Simulator{
+		cfg:     cfg,
+		now:     time.Now,
+		orders:  make(map[string]*order),
+		scripts: make(map[string][]Step),
+	}
+}
+
+// Handler возвращает HTTP-обработчик API системы начислений
+func (s *Simulator) Handler() http.Handler {
+	r := chi.NewRouter()
+	r.Post("/api/goods", s.postGoodsHandler)
+	r.Post("/api/orders", s.postOrdersHandler)
+	r.Get("/api/orders/{number}", s.getOrderHandler)
+	return r
+}
+
+func (s *Simulator) postGoodsHandler(w http.ResponseWriter, r *http.Request) {
+	var reward Reward
+	if err := json.NewDecoder(r.Body).Decode(&reward); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if reward.Match == "" || reward.Reward <= 0 ||
+		(reward.RewardType != RewardPercent && reward.RewardType != RewardPoints) {
+		http.Error(w, "invalid reward", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.rewards {
+		if existing.Match == reward.Match {
+			http.Error(w, "reward already registered", http.StatusConflict)
+			return
+		}
+	}
+	s.rewards = append(s.rewards, reward)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Simulator) postOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	var req OrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Order == "" {
+		http.Error(w, "order number is required", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.orders[req.Order]; ok {
+		http.Error(w, "order already registered", http.StatusConflict)
+		return
+	}
+	s.orders[req.Order] = &order{
+		registeredAt: s.now(),
+		goods:        req.Goods,
+		invalid:      s.isInvalid(req.Order),
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Simulator) getOrderHandler(w http.ResponseWriter, r *http.Request) {
+	number := chi.URLParam(r, "number")
+
+	if step, ok := s.nextStep(number); ok {
+		s.writeStep(w, r, number, step)
+		return
+	}
+
+	if retryAfter, limited := s.limit(); limited {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+		http.Error(w, "No more than "+strconv.Itoa(s.cfg.RateLimit)+" requests per minute allowed",
+			http.StatusTooManyRequests)
+		return
+	}
+	if chance(s.cfg.ErrorRate) {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if chance(s.cfg.SlowRate) && !sleep(r, s.cfg.SlowDelay) {
+		return
+	}
+
+	resp, ok := s.status(number)
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// status рассчитывает текущий статус заказа по времени его регистрации
+func (s *Simulator) status(number string) (OrderResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[number]
+	if !ok {
+		if !s.cfg.AutoRegister {
+			return OrderResponse{}, false
+		}
+		accrual := s.cfg.DefaultAccrual
+		o = &order{registeredAt: s.now(), invalid: s.isInvalid(number), accrual: &accrual}
+		s.orders[number] = o
+	}
+
+	resp := OrderResponse{Order: number}
+	elapsed := s.now().Sub(o.registeredAt)
+	switch {
+	case elapsed < s.cfg.RegisteredDelay:
+		resp.Status = StatusRegistered
+	case elapsed < s.cfg.RegisteredDelay+s.cfg.ProcessingDelay:
+		resp.Status = StatusProcessing
+	case o.invalid:
+		resp.Status = StatusInvalid
+	default:
+		resp.Status = StatusProcessed
+		accrual := s.accrual(o)
+		resp.Accrual = &accrual
+	}
+	return resp, true
+}
+
+// accrual рассчитывает вознаграждение за заказ по зарегистрированным правилам:
+// к каждому товару применяется первое подходящее правило
+func (s *Simulator) accrual(o *order) float64 {
+	if o.accrual != nil {
+		return *o.accrual
+	}
+	var total float64
+	for _, good := range o.goods {
+		for _, reward := range s.rewards {
+			if !strings.Contains(good.Description, reward.Match) {
+				continue
+			}
+			if reward.RewardType == RewardPercent {
+				total += good.Price * reward.Reward / 100
+			} else {
+				total += reward.Reward
+			}
+			break
+		}
+	}
+	return total
+}
+
+// isInvalid детерминированно по номеру заказа решает, будет ли он недействительным
+func (s *Simulator) isInvalid(number string) bool {
+	if s.cfg.InvalidRate <= 0 {
+		return false
+	}
+	h := fnv.New32a()
+	h.Write([]byte(number)) //nolint
+	return float64(h.Sum32()%1000) < s.cfg.InvalidRate*1000
+}
+
+// limit учитывает запрос в текущем минутном окне и сообщает, превышен ли лимит
+func (s *Simulator) limit() (time.Duration, bool) {
+	if s.cfg.RateLimit <= 0 {
+		return 0, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if now.Sub(s.windowStart) >= time.Minute {
+		s.windowStart = now
+		s.windowCount = 0
+	}
+	s.windowCount++
+	if s.windowCount <= s.cfg.RateLimit {
+		return 0, false
+	}
+	retryAfter := s.cfg.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = time.Minute - now.Sub(s.windowStart)
+	}
+	return retryAfter, true
+}
+
+func chance(rate float64) bool {
+	return rate > 0 && rand.Float64() < rate
+}
+
+// sleep ждёт d или отмены запроса и сообщает, дождался ли
+func sleep(r *http.Request, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v) //nolint
+}
@@ -0,0 +1,76 @@
+package accrualsim
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSimulator(t *testing.T) {
+	now := time.Now()
+	sim := New(Config{RegisteredDelay: time.Second, ProcessingDelay: time.Second, RateLimit: 5, RetryAfter: 10 * time.Second})
+	sim.now = func() time.Time { return now }
+	h := sim.Handler()
+
+	post := func(path, body string) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		return w.Code
+	}
+	get := func(number string) (int, OrderResponse, http.Header) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/orders/"+number, nil))
+		var resp OrderResponse
+		if w.Code == http.StatusOK {
+			json.Unmarshal(w.Body.Bytes(), &resp) //nolint
+		}
+		return w.Code, resp, w.Header()
+	}
+
+	if code := post("/api/goods", `{"match":"Bork","reward":10,"reward_type":"%"}`); code != http.StatusOK {
+		t.Fatalf("Expected 200 for new reward; got %d", code)
+	}
+	if code := post("/api/goods", `{"match":"Bork","reward":5,"reward_type":"pt"}`); code != http.StatusConflict {
+		t.Errorf("Expected 409 for duplicate reward; got %d", code)
+	}
+	if code := post("/api/orders", `{"order":"12345678903","goods":[{"description":"Чайник Bork","price":7000}]}`); code != http.StatusAccepted {
+		t.Fatalf("Expected 202 for new order; got %d", code)
+	}
+
+	testCases := []struct {
+		name           string
+		elapsed        time.Duration
+		number         string
+		expectedCode   int
+		expectedStatus string
+	}{
+		{name: "unknown order", number: "9278923470", expectedCode: http.StatusNoContent},
+		{name: "registered", number: "12345678903", expectedCode: http.StatusOK, expectedStatus: StatusRegistered},
+		{name: "processing", elapsed: 1500 * time.Millisecond, number: "12345678903", expectedCode: http.StatusOK, expectedStatus: StatusProcessing},
+		{name: "processed", elapsed: 3 * time.Second, number: "12345678903", expectedCode: http.StatusOK, expectedStatus: StatusProcessed},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sim.now = func() time.Time { return now.Add(tc.elapsed) }
+			code, resp, _ := get(tc.number)
+			if code != tc.expectedCode || resp.Status != tc.expectedStatus {
+				t.Errorf("Expected %d %s; got %d %s", tc.expectedCode, tc.expectedStatus, code, resp.Status)
+			}
+			if resp.Status == StatusProcessed && (resp.Accrual == nil || *resp.Accrual != 700) {
+				t.Errorf("Expected accrual 700; got %v", resp.Accrual)
+			}
+		})
+	}
+
+	code, _, header := get("12345678903")
+	if code != http.StatusOK {
+		t.Fatalf("Expected 200 within rate limit; got %d", code)
+	}
+	code, _, header = get("12345678903")
+	if code != http.StatusTooManyRequests || header.Get("Retry-After") != "10" {
+		t.Errorf("Expected 429 with Retry-After 10; got %d %q", code, header.Get("Retry-After"))
+	}
+}
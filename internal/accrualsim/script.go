@@ -0,0 +1,107 @@
+package accrualsim
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Step — заранее заданный ответ на запрос расчёта по заказу.
+// Шаги сценария выдаются по очереди, последний повторяется.
+type Step struct {
+	// Code — код ответа; по умолчанию 200, если задан Status, иначе 204
+	Code int `yaml:"code"`
+	// Status и Accrual — содержимое ответа 200
+	Status  string   `yaml:"status"`
+	Accrual *float64 `yaml:"accrual"`
+	// Delay — задержка перед ответом
+	Delay time.Duration `yaml:"delay"`
+	// RetryAfter — значение заголовка Retry-After для ответа 429
+	RetryAfter time.Duration `yaml:"retry_after"`
+}
+
+// Script — сценарий ответов по номерам заказов
+type Script struct {
+	Orders map[string][]Step `yaml:"orders"`
+}
+
+// LoadScript читает сценарий из YAML-файла:
+//
+//	orders:
+//	  "12345678903":
+//	    - status: REGISTERED
+//	    - code: 429
+//	      retry_after: 5s
+//	    - status: PROCESSED
+//	      accrual: 500
+func LoadScript(path string) (Script, error) {
+	var script Script
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return script, err
+	}
+	if err := yaml.Unmarshal(b, &script); err != nil {
+		return script, fmt.Errorf("script %s: %w", path, err)
+	}
+	return script, nil
+}
+
+// SetScript задаёт сценарий ответов для заказа number.
+// Пока сценарий задан, настройки Config на этот заказ не действуют.
+func (s *Simulator) SetScript(number string, steps ...Step) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(steps) == 0 {
+		delete(s.scripts, number)
+		return
+	}
+	s.scripts[number] = steps
+}
+
+// ApplyScript задаёт сценарии для всех заказов из script
+func (s *Simulator) ApplyScript(script Script) {
+	for number, steps := range script.Orders {
+		s.SetScript(number, steps...)
+	}
+}
+
+func (s *Simulator) nextStep(number string) (Step, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	steps := s.scripts[number]
+	if len(steps) == 0 {
+		return Step{}, false
+	}
+	if len(steps) > 1 {
+		s.scripts[number] = steps[1:]
+	}
+	return steps[0], true
+}
+
+func (s *Simulator) writeStep(w http.ResponseWriter, r *http.Request, number string, step Step) {
+	if !sleep(r, step.Delay) {
+		return
+	}
+	code := step.Code
+	if code == 0 {
+		code = http.StatusNoContent
+		if step.Status != "" {
+			code = http.StatusOK
+		}
+	}
+	switch code {
+	case http.StatusOK:
+		writeJSON(w, code, OrderResponse{Order: number, Status: step.Status, Accrual: step.Accrual})
+	case http.StatusTooManyRequests:
+		if step.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(step.RetryAfter.Seconds())))
+		}
+		http.Error(w, "too many requests", code)
+	default:
+		w.WriteHeader(code)
+	}
+}
@@ -2,33 +2,132 @@ package app
 
 import (
 	"context"
+	"encoding/hex"
 	"net/http"
+	"sync"
+	"time"
 
+	"github.com/beliaevke/go-musthave-diploma/internal/accrual"
 	"github.com/beliaevke/go-musthave-diploma/internal/config"
 	"github.com/beliaevke/go-musthave-diploma/internal/db/postgres"
 	"github.com/beliaevke/go-musthave-diploma/internal/handlers/orders"
+	"github.com/beliaevke/go-musthave-diploma/internal/handlers/webhooks"
 	"github.com/beliaevke/go-musthave-diploma/internal/logger"
+	"github.com/beliaevke/go-musthave-diploma/internal/outbox"
 	"github.com/beliaevke/go-musthave-diploma/internal/router"
+	"github.com/beliaevke/go-musthave-diploma/internal/service"
+	"github.com/beliaevke/go-musthave-diploma/internal/storage"
+	"github.com/beliaevke/go-musthave-diploma/internal/storage/memory"
 )
 
 func Run(cfg config.ServerFlags, ctx context.Context) error {
 
-	db, err := postgres.NewDB(ctx, cfg)
+	store, err := newStorage(cfg, ctx)
 	if err != nil {
 		logger.Warnf("SetDB fail: " + err.Error())
 		return err
 	}
 
+	logger.Infof("Effective config: " + cfg.String())
 	logger.ServerRunningInfo(cfg.FlagRunAddr)
 
-	go orders.CheckOrders(cfg.FlagASAddr, cfg.CheckOrdersTimeout, db)
+	if cfg.JWTSecret == "" {
+		key, err := service.GenerateRandom(32)
+		if err != nil {
+			return err
+		}
+		cfg.JWTSecret = hex.EncodeToString(key)
+		logger.Warnf("jwt_secret is not set: sessions are signed with a random key and end on restart")
+	}
+
+	accrualClient := accrual.NewClient(accrual.Config{
+		Address:          cfg.FlagASAddr,
+		Timeout:          cfg.AccrualTimeout,
+		Retries:          cfg.AccrualRetries,
+		BackoffBase:      100 * time.Millisecond,
+		BackoffMax:       2 * time.Second,
+		BreakerThreshold: cfg.AccrualBreakerThreshold,
+		BreakerCooldown:  cfg.AccrualBreakerCooldown,
+	})
+	// фоновые обработчики останавливаются по отмене ctx; Run дожидается их завершения,
+	// чтобы начатые отправки не обрывались при выходе из процесса
+	var workers sync.WaitGroup
+	defer workers.Wait()
+	// при ошибке запуска обработчики останавливаются до ожидания
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		orders.CheckOrders(ctx, accrualClient, cfg.CheckOrdersTimeout, store.Orders, orders.RetryPolicy{
+			Base:        cfg.OrderRetryBase,
+			Max:         cfg.OrderRetryMax,
+			MaxAttempts: cfg.OrderMaxAttempts,
+			MaxAge:      cfg.OrderMaxAge,
+		})
+	}()
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		webhooks.DeliverWebhooks(ctx, webhooks.NewClient(cfg.WebhookTimeout, cfg.WebhookAllowPrivate), store.Webhooks, webhooks.RetryPolicy{
+			Base:        cfg.WebhookRetryBase,
+			Max:         cfg.WebhookRetryMax,
+			MaxAttempts: cfg.WebhookMaxAttempts,
+		})
+	}()
 
-	router := router.NewRouter(db)
+	if cfg.OutboxSink != config.OutboxNone {
+		sink, err := newOutboxSink(cfg)
+		if err != nil {
+			logger.Warnf("Outbox sink fail: " + err.Error())
+			return err
+		}
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			outbox.Relay(ctx, store.Outbox, sink, cfg.OutboxRetention)
+		}()
+	}
+
+	router := router.NewRouter(store, cfg)
 
-	if err := http.ListenAndServe(cfg.FlagRunAddr, router.R); err != nil && err != http.ErrServerClosed {
+	server := &http.Server{Addr: cfg.FlagRunAddr, Handler: router.R}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.DefaultTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logger.Warnf("App shutdown: " + err.Error())
+		}
+	}()
+
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		logger.Warnf("App start fail: " + err.Error())
 		return err
 	}
 
 	return nil
 }
+
+func newStorage(cfg config.ServerFlags, ctx context.Context) (storage.Storage, error) {
+	if cfg.Storage == config.StorageMemory {
+		logger.Warnf("Using in-memory storage, data will be lost on exit")
+		return memory.New(cfg.DefaultTimeout), nil
+	}
+	db, err := postgres.NewDB(ctx, cfg)
+	if err != nil {
+		return storage.Storage{}, err
+	}
+	return storage.NewPostgres(db), nil
+}
+
+func newOutboxSink(cfg config.ServerFlags) (outbox.Sink, error) {
+	switch cfg.OutboxSink {
+	case config.OutboxHTTP:
+		return outbox.NewHTTPSink(cfg.OutboxURL, cfg.DefaultTimeout), nil
+	case config.OutboxNATS:
+		return outbox.NewNATSSink(cfg.OutboxURL, cfg.OutboxSubject, cfg.DefaultTimeout)
+	}
+	return outbox.LogSink{}, nil
+}
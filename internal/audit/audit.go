@@ -0,0 +1,87 @@
+// Package audit записывает действия пользователей и администраторов в журнал аудита
+// (auditrepo) вместе с адресом клиента и User-Agent запроса.
+package audit
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/logger"
+	"github.com/beliaevke/go-musthave-diploma/internal/middleware/auth"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/auditrepo"
+)
+
+// Recorder — журнал аудита, в который пишут обработчики запросов
+type Recorder interface {
+	Record(ctx context.Context, e auditrepo.Entry) error
+	Timeout() time.Duration
+}
+
+// Предельные длины полей записи (как в таблице audit_log)
+const (
+	maxIPLength        = 64
+	maxUserAgentLength = 512
+	maxTargetLength    = 128
+)
+
+// Prepare дополняет запись e временем, адресом клиента, User-Agent запроса и, если автор
+// не указан, автором из сессии. Подготовленную запись хранилище записывает в транзакции
+// самого действия (auditrepo.Add), если действие изменяет баланс.
+func Prepare(r *http.Request, e auditrepo.Entry) auditrepo.Entry {
+	if e.ActorID == 0 && e.ActorRole == "" {
+		if claims, ok := auth.ClaimsFromContext(r.Context()); ok {
+			e.ActorID = claims.UserID
+			e.ActorRole = claims.Role
+		}
+	}
+	e.OccurredAt = time.Now()
+	e.IP = clean(ClientIP(r), maxIPLength)
+	e.UserAgent = clean(r.UserAgent(), maxUserAgentLength)
+	e.Target = clean(e.Target, maxTargetLength)
+	return e
+}
+
+// Write подготавливает запись e (Prepare) и записывает её в журнал. Ошибку записи
+// обработчик возвращает клиенту: действие администратора без записи в журнале
+// не должно выглядеть успешным.
+func Write(r *http.Request, rec Recorder, e auditrepo.Entry) error {
+	e = Prepare(r, e)
+	// запись не прерывается, если клиент закрыл соединение, не дождавшись ответа
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), rec.Timeout())
+	defer cancel()
+	if err := rec.Record(ctx, e); err != nil {
+		logger.WarnfCtx(ctx, "audit "+e.Action+": "+err.Error())
+		return err
+	}
+	return nil
+}
+
+// Log записывает запись e в журнал, как Write, но ошибка записи попадает только в лог
+// сервиса: так записываются попытки входа и отказы, не изменившие данных.
+func Log(r *http.Request, rec Recorder, e auditrepo.Entry) {
+	_ = Write(r, rec, e)
+}
+
+// ClientIP возвращает адрес, с которого пришёл запрос. Заголовки X-Forwarded-For
+// и X-Real-IP не учитываются: их может подставить сам клиент.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clean заменяет недопустимые в UTF-8 байты и обрезает строку до limit байт
+func clean(s string, limit int) string {
+	s = strings.ToValidUTF8(s, "?")
+	if len(s) <= limit {
+		return s
+	}
+	s = s[:limit]
+	// обрезка не должна разрывать многобайтовый символ
+	return strings.ToValidUTF8(s, "")
+}
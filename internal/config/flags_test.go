@@ -0,0 +1,213 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearEnv сбрасывает переменные окружения всех настроек, чтобы окружение,
+// в котором запущены тесты, не влияло на результат
+func clearEnv(t *testing.T) {
+	t.Helper()
+	st := reflect.TypeOf(ServerFlags{})
+	for i := 0; i < st.NumField(); i++ {
+		if name := st.Field(i).Tag.Get("env"); name != "" {
+			t.Setenv(name, "")
+			t.Setenv(name+"_FILE", "")
+		}
+	}
+}
+
+// writeFile создаёт во временном каталоге теста файл с содержимым content
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	const yamlConfig = `
+run_address: file:8080
+check_orders_timeout: 45s
+login_max_failures: 7
+`
+	testCases := []struct {
+		name string
+		// file — содержимое конфигурационного файла; путь к нему передаётся флагом -config,
+		// а если fileFromEnv — переменной окружения CONFIG
+		file        string
+		fileFromEnv bool
+		env         map[string]string
+		args        []string
+		check       func(t *testing.T, cfg ServerFlags)
+	}{
+		{
+			name: "defaults",
+			check: func(t *testing.T, cfg ServerFlags) {
+				if !reflect.DeepEqual(cfg, Default()) {
+					t.Errorf("Expected defaults; got %+v", cfg)
+				}
+			},
+		},
+		{
+			name: "file overrides defaults",
+			file: yamlConfig,
+			check: func(t *testing.T, cfg ServerFlags) {
+				if cfg.FlagRunAddr != "file:8080" || cfg.CheckOrdersTimeout != 45*time.Second || cfg.LoginMaxFailures != 7 {
+					t.Errorf("Expected values from file; got %+v", cfg)
+				}
+				if cfg.DefaultTimeout != Default().DefaultTimeout {
+					t.Errorf("Expected default for a key missing in file; got %s", cfg.DefaultTimeout)
+				}
+			},
+		},
+		{
+			name:        "file from CONFIG",
+			file:        yamlConfig,
+			fileFromEnv: true,
+			check: func(t *testing.T, cfg ServerFlags) {
+				if cfg.FlagRunAddr != "file:8080" {
+					t.Errorf("Expected run address from file; got %q", cfg.FlagRunAddr)
+				}
+			},
+		},
+		{
+			name: "env overrides file",
+			file: yamlConfig,
+			env:  map[string]string{"RUN_ADDRESS": "env:8080", "ORDER_MAX_ATTEMPTS": "4"},
+			check: func(t *testing.T, cfg ServerFlags) {
+				if cfg.FlagRunAddr != "env:8080" || cfg.OrderMaxAttempts != 4 {
+					t.Errorf("Expected values from env; got %+v", cfg)
+				}
+				if cfg.CheckOrdersTimeout != 45*time.Second {
+					t.Errorf("Expected file value for a variable that is not set; got %s", cfg.CheckOrdersTimeout)
+				}
+			},
+		},
+		{
+			name: "flags override env and file",
+			file: yamlConfig,
+			env:  map[string]string{"RUN_ADDRESS": "env:8080", "LOGIN_MAX_FAILURES": "9"},
+			args: []string{"-a", "flag:8080", "-skip-migrations"},
+			check: func(t *testing.T, cfg ServerFlags) {
+				if cfg.FlagRunAddr != "flag:8080" || !cfg.SkipMigrations {
+					t.Errorf("Expected values from flags; got %+v", cfg)
+				}
+				// флаг не передан — значение по умолчанию флага не затирает переменную окружения
+				if cfg.LoginMaxFailures != 9 {
+					t.Errorf("Expected login_max_failures from env; got %d", cfg.LoginMaxFailures)
+				}
+			},
+		},
+		{
+			name: "secret from file",
+			env:  map[string]string{"JWT_SECRET_FILE": writeFile(t, "jwt_secret", "from-file\n")},
+			check: func(t *testing.T, cfg ServerFlags) {
+				if cfg.JWTSecret != "from-file" {
+					t.Errorf("Expected secret from file without trailing newline; got %q", cfg.JWTSecret)
+				}
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clearEnv(t)
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+			args := tc.args
+			if tc.file != "" {
+				path := writeFile(t, "config.yaml", tc.file)
+				if tc.fileFromEnv {
+					t.Setenv("CONFIG", path)
+				} else {
+					args = append([]string{"-config", path}, args...)
+				}
+			}
+			cfg, err := Load(args)
+			if err != nil {
+				t.Fatalf("Load failed: %v", err)
+			}
+			tc.check(t, cfg)
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		file     string
+		env      map[string]string
+		args     []string
+		expected string
+	}{
+		{
+			name:     "unknown key in file",
+			file:     "run_adress: localhost:8080\n",
+			expected: "run_adress",
+		},
+		{
+			name:     "secret and its file",
+			env:      map[string]string{"ADMIN_TOKEN": "token", "ADMIN_TOKEN_FILE": "/nonexistent"},
+			expected: "ADMIN_TOKEN and ADMIN_TOKEN_FILE are mutually exclusive",
+		},
+		{
+			name:     "missing secret file",
+			env:      map[string]string{"ADMIN_TOKEN_FILE": "/nonexistent/admin_token"},
+			expected: "ADMIN_TOKEN_FILE",
+		},
+		{
+			name:     "unexpected argument",
+			args:     []string{"serve"},
+			expected: "unexpected arguments: serve",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clearEnv(t)
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+			args := tc.args
+			if tc.file != "" {
+				args = append([]string{"-config", writeFile(t, "config.yaml", tc.file)}, args...)
+			}
+			_, err := Load(args)
+			if err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("Expected error with %q; got %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+func TestNewConfigValidation(t *testing.T) {
+	clearEnv(t)
+	if _, err := NewConfig([]string{"-storage", "memory"}); err != nil {
+		t.Errorf("Expected defaults with memory storage to be valid; got %v", err)
+	}
+
+	// все ошибки выводятся сразу
+	_, err := NewConfig([]string{"-a", "no-port", "-dt", "0s", "-migrations-timeout", "-1s"})
+	if err == nil {
+		t.Fatal("Expected invalid config")
+	}
+	for _, expected := range []string{
+		"run_address (RUN_ADDRESS, -a)",
+		"database_uri (DATABASE_URI, -d): must be set",
+		"default_timeout (DEFAULT_TIMEOUT, -dt): must be positive",
+		"migrations_timeout (MIGRATIONS_TIMEOUT, -migrations-timeout): must be positive",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error %q; got %v", expected, err)
+		}
+	}
+}
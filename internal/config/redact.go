@@ -0,0 +1,34 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/redact"
+)
+
+// Redacted возвращает копию настроек со скрытыми секретами для вывода в лог или консоль.
+// Поля с тегом secret:"uri" — строки подключения, в них скрывается только пароль,
+// остальные поля с тегом secret скрываются целиком.
+func (cfg ServerFlags) Redacted() ServerFlags {
+	v := reflect.ValueOf(&cfg).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		kind, ok := t.Field(i).Tag.Lookup("secret")
+		if !ok || v.Field(i).String() == "" {
+			continue
+		}
+		if kind == "uri" {
+			v.Field(i).SetString(redact.URI(v.Field(i).String()))
+		} else {
+			v.Field(i).SetString(redact.Mask)
+		}
+	}
+	return cfg
+}
+
+// String позволяет безопасно выводить настройки через fmt и логер
+func (cfg ServerFlags) String() string {
+	type plain ServerFlags
+	return fmt.Sprintf("%+v", plain(cfg.Redacted()))
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// loadSecretFiles для каждой секретной настройки с переменной окружения NAME
+// читает значение из файла, путь к которому задан в переменной NAME_FILE
+// (так передаются секреты Docker и Kubernetes)
+func (cfg *ServerFlags) loadSecretFiles() error {
+	var errs []error
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("env")
+		if _, ok := field.Tag.Lookup("secret"); !ok || name == "" {
+			continue
+		}
+		path := os.Getenv(name + "_FILE")
+		if path == "" {
+			continue
+		}
+		if os.Getenv(name) != "" {
+			errs = append(errs, fmt.Errorf("%s and %s_FILE are mutually exclusive", name, name))
+			continue
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s_FILE: %w", name, err))
+			continue
+		}
+		v.Field(i).SetString(strings.TrimRight(string(b), "\r\n"))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,152 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Validate проверяет настройки и возвращает все найденные ошибки сразу
+func (cfg ServerFlags) Validate() error {
+	var errs []error
+
+	if _, _, err := net.SplitHostPort(cfg.FlagRunAddr); err != nil {
+		errs = append(errs, fmt.Errorf("run_address (RUN_ADDRESS, -a): %w", err))
+	}
+
+	switch cfg.Storage {
+	case StoragePostgres, StorageMemory:
+	default:
+		errs = append(errs, fmt.Errorf("storage (STORAGE, -storage): unknown storage %q", cfg.Storage))
+	}
+
+	if cfg.FlagDatabaseURI == "" && cfg.Storage == StoragePostgres {
+		errs = append(errs, errors.New("database_uri (DATABASE_URI, -d): must be set"))
+	} else if cfg.FlagDatabaseURI != "" {
+		if _, err := pgxpool.ParseConfig(cfg.FlagDatabaseURI); err != nil {
+			// текст ошибки pgx может содержать строку подключения целиком, поэтому не выводим его
+			errs = append(errs, errors.New("database_uri (DATABASE_URI, -d): cannot be parsed"))
+		}
+	}
+
+	if cfg.FlagASAddr != "" {
+		u, err := url.Parse(cfg.FlagASAddr)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, errors.New("accrual_system_address (ACCRUAL_SYSTEM_ADDRESS, -r): must be an http(s) URL"))
+		}
+	}
+
+	if cfg.DefaultTimeout <= 0 {
+		errs = append(errs, errors.New("default_timeout (DEFAULT_TIMEOUT, -dt): must be positive"))
+	}
+	if cfg.CheckOrdersTimeout <= 0 {
+		errs = append(errs, errors.New("check_orders_timeout (CHECK_ORDERS_TIMEOUT, -cot): must be positive"))
+	}
+
+	if cfg.AccrualTimeout <= 0 {
+		errs = append(errs, errors.New("accrual_timeout (ACCRUAL_TIMEOUT, -accrual-timeout): must be positive"))
+	}
+	if cfg.AccrualRetries < 0 {
+		errs = append(errs, errors.New("accrual_retries (ACCRUAL_RETRIES, -accrual-retries): must not be negative"))
+	}
+	if cfg.AccrualBreakerThreshold < 0 {
+		errs = append(errs, errors.New("accrual_breaker_threshold (ACCRUAL_BREAKER_THRESHOLD, -accrual-breaker-threshold): must not be negative"))
+	}
+	if cfg.AccrualBreakerCooldown <= 0 {
+		errs = append(errs, errors.New("accrual_breaker_cooldown (ACCRUAL_BREAKER_COOLDOWN, -accrual-breaker-cooldown): must be positive"))
+	}
+
+	if cfg.AccrualCallbackSecret != "" && len(cfg.AccrualCallbackSecret) < 16 {
+		errs = append(errs, errors.New("accrual_callback_secret (ACCRUAL_CALLBACK_SECRET, -accrual-callback-secret): must be at least 16 characters"))
+	}
+	if cfg.AccrualCallbackTolerance <= 0 {
+		errs = append(errs, errors.New("accrual_callback_tolerance (ACCRUAL_CALLBACK_TOLERANCE, -accrual-callback-tolerance): must be positive"))
+	}
+
+	if cfg.OrderRetryBase <= 0 {
+		errs = append(errs, errors.New("order_retry_base (ORDER_RETRY_BASE, -order-retry-base): must be positive"))
+	}
+	if cfg.OrderRetryMax < cfg.OrderRetryBase {
+		errs = append(errs, errors.New("order_retry_max (ORDER_RETRY_MAX, -order-retry-max): must not be less than order_retry_base"))
+	}
+	if cfg.OrderMaxAttempts <= 0 {
+		errs = append(errs, errors.New("order_max_attempts (ORDER_MAX_ATTEMPTS, -order-max-attempts): must be positive"))
+	}
+	if cfg.OrderMaxAge < 0 {
+		errs = append(errs, errors.New("order_max_age (ORDER_MAX_AGE, -order-max-age): must not be negative"))
+	}
+
+	if cfg.WebhookTimeout <= 0 {
+		errs = append(errs, errors.New("webhook_timeout (WEBHOOK_TIMEOUT, -webhook-timeout): must be positive"))
+	}
+	if cfg.WebhookRetryBase <= 0 {
+		errs = append(errs, errors.New("webhook_retry_base (WEBHOOK_RETRY_BASE, -webhook-retry-base): must be positive"))
+	}
+	if cfg.WebhookRetryMax < cfg.WebhookRetryBase {
+		errs = append(errs, errors.New("webhook_retry_max (WEBHOOK_RETRY_MAX, -webhook-retry-max): must not be less than webhook_retry_base"))
+	}
+	if cfg.WebhookMaxAttempts <= 0 {
+		errs = append(errs, errors.New("webhook_max_attempts (WEBHOOK_MAX_ATTEMPTS, -webhook-max-attempts): must be positive"))
+	}
+
+	switch cfg.OutboxSink {
+	case OutboxNone, OutboxLog:
+	case OutboxHTTP:
+		u, err := url.Parse(cfg.OutboxURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, errors.New("outbox_url (OUTBOX_URL, -outbox-url): must be an http(s) URL for outbox_sink http"))
+		}
+	case OutboxNATS:
+		if cfg.OutboxURL == "" {
+			errs = append(errs, errors.New("outbox_url (OUTBOX_URL, -outbox-url): must be set for outbox_sink nats"))
+		}
+		if cfg.OutboxSubject == "" {
+			errs = append(errs, errors.New("outbox_subject (OUTBOX_SUBJECT, -outbox-subject): must be set for outbox_sink nats"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("outbox_sink (OUTBOX_SINK, -outbox-sink): unknown sink %q", cfg.OutboxSink))
+	}
+	if cfg.OutboxRetention < 0 {
+		errs = append(errs, errors.New("outbox_retention (OUTBOX_RETENTION, -outbox-retention): must not be negative"))
+	}
+
+	if cfg.AdminToken != "" && len(cfg.AdminToken) < 16 {
+		errs = append(errs, errors.New("admin_token (ADMIN_TOKEN, -admin-token): must be at least 16 characters"))
+	}
+	if cfg.JWTSecret != "" && len(cfg.JWTSecret) < 32 {
+		errs = append(errs, errors.New("jwt_secret (JWT_SECRET, -jwt-secret): must be at least 32 characters"))
+	}
+	if cfg.AdjustmentLimitSupport < 0 {
+		errs = append(errs, errors.New("adjustment_limit_support (ADJUSTMENT_LIMIT_SUPPORT, -adjustment-limit-support): must not be negative"))
+	}
+	if cfg.AdjustmentLimitAdmin <= 0 {
+		errs = append(errs, errors.New("adjustment_limit_admin (ADJUSTMENT_LIMIT_ADMIN, -adjustment-limit-admin): must be positive"))
+	}
+
+	if cfg.LoginMaxFailures <= 0 {
+		errs = append(errs, errors.New("login_max_failures (LOGIN_MAX_FAILURES, -login-max-failures): must be positive"))
+	}
+	if cfg.LoginIPMaxFailures <= 0 {
+		errs = append(errs, errors.New("login_ip_max_failures (LOGIN_IP_MAX_FAILURES, -login-ip-max-failures): must be positive"))
+	}
+	if cfg.LoginLockout <= 0 {
+		errs = append(errs, errors.New("login_lockout (LOGIN_LOCKOUT, -login-lockout): must be positive"))
+	}
+	if cfg.LoginDelayBase < 0 {
+		errs = append(errs, errors.New("login_delay_base (LOGIN_DELAY_BASE, -login-delay-base): must not be negative"))
+	}
+	if cfg.LoginDelayMax < cfg.LoginDelayBase {
+		errs = append(errs, errors.New("login_delay_max (LOGIN_DELAY_MAX, -login-delay-max): must not be less than login_delay_base"))
+	}
+	if cfg.MigrationsTimeout <= 0 {
+		errs = append(errs, errors.New("migrations_timeout (MIGRATIONS_TIMEOUT, -migrations-timeout): must be positive"))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config: %w", errors.Join(errs...))
+	}
+	return nil
+}
@@ -1,21 +1,49 @@
 package migrations
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"embed"
 	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"text/tabwriter"
+	"time"
 
 	"github.com/beliaevke/go-musthave-diploma/internal/config"
 	"github.com/beliaevke/go-musthave-diploma/internal/logger"
 
 	"github.com/pressly/goose/v3"
+	"github.com/pressly/goose/v3/lock"
 )
 
 //go:embed sql/*.sql
 var embedMigrations embed.FS
 
+// Commands — поддерживаемые команды gophermart migrate
+var Commands = []string{"up", "down", "status", "version", "redo"}
+
+// Run применяет все новые миграции при запуске сервера
 func Run(cfg config.ServerFlags, ctx context.Context) error {
+	var buf bytes.Buffer
+	err := Exec(ctx, cfg, "up", &buf)
+	if buf.Len() > 0 {
+		logger.Infof("goose up: " + buf.String())
+	}
+	return err
+}
+
+// Exec выполняет команду миграции и выводит результат в w.
+// На время выполнения берётся advisory lock Postgres, поэтому несколько
+// одновременно запущенных экземпляров сервиса не применяют миграции параллельно.
+// Ожидание блокировки и сами миграции ограничены cfg.MigrationsTimeout.
+func Exec(ctx context.Context, cfg config.ServerFlags, command string, w io.Writer) error {
+	if !slices.Contains(Commands, command) {
+		return fmt.Errorf("unknown migrate command %q", command)
+	}
 
 	if cfg.FlagDatabaseURI == "" {
 		err := errors.New("database URI is empty")
@@ -26,6 +54,7 @@ func Run(cfg config.ServerFlags, ctx context.Context) error {
 	db, err := sql.Open("pgx", cfg.FlagDatabaseURI)
 	if err != nil {
 		logger.Warnf("sql.Open(): " + err.Error())
+		return err
 	}
 	defer func() {
 		if err := db.Close(); err != nil {
@@ -33,12 +62,89 @@ func Run(cfg config.ServerFlags, ctx context.Context) error {
 		}
 	}()
 
-	goose.SetBaseFS(embedMigrations)
-	ctx, cancel := context.WithTimeout(ctx, cfg.DefaultTimeout)
+	provider, err := newProvider(db)
+	if err != nil {
+		logger.Warnf("goose: " + err.Error())
+		return err
+	}
+
+	// миграции и ожидание чужой блокировки длятся дольше обычного запроса к базе
+	ctx, cancel := context.WithTimeout(ctx, cfg.MigrationsTimeout)
 	defer cancel()
-	if err := goose.UpContext(ctx, db, "sql"); err != nil {
-		logger.Warnf("goose up: run failed  " + err.Error())
+
+	switch command {
+	case "up":
+		results, err := provider.Up(ctx)
+		printResults(w, results...)
+		if err != nil {
+			logger.Warnf("goose up: run failed " + err.Error())
+			return fmt.Errorf("goose up: %w", err)
+		}
+	case "down":
+		result, err := provider.Down(ctx)
+		if err != nil {
+			return fmt.Errorf("goose down: %w", err)
+		}
+		printResults(w, result)
+	case "redo":
+		result, err := provider.Down(ctx)
+		if err != nil {
+			return fmt.Errorf("goose redo: %w", err)
+		}
+		printResults(w, result)
+		result, err = provider.UpByOne(ctx)
+		if err != nil {
+			return fmt.Errorf("goose redo: %w", err)
+		}
+		printResults(w, result)
+	case "status":
+		statuses, err := provider.Status(ctx)
+		if err != nil {
+			return fmt.Errorf("goose status: %w", err)
+		}
+		printStatus(w, statuses)
+	case "version":
+		version, err := provider.GetDBVersion(ctx)
+		if err != nil {
+			return fmt.Errorf("goose version: %w", err)
+		}
+		fmt.Fprintf(w, "version: %d\n", version)
+	default:
+		return fmt.Errorf("unknown migrate command %q", command)
 	}
+	return nil
+}
 
-	return err
+func newProvider(db *sql.DB) (*goose.Provider, error) {
+	fsys, err := fs.Sub(embedMigrations, "sql")
+	if err != nil {
+		return nil, err
+	}
+	locker, err := lock.NewPostgresSessionLocker()
+	if err != nil {
+		return nil, err
+	}
+	return goose.NewProvider(goose.DialectPostgres, db, fsys, goose.WithSessionLocker(locker))
+}
+
+func printResults(w io.Writer, results ...*goose.MigrationResult) {
+	for _, r := range results {
+		if r == nil {
+			continue
+		}
+		fmt.Fprintln(w, r.String())
+	}
+}
+
+func printStatus(w io.Writer, statuses []*goose.MigrationStatus) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tSTATE\tAPPLIED AT\tSOURCE")
+	for _, s := range statuses {
+		appliedAt := "-"
+		if s.State == goose.StateApplied {
+			appliedAt = s.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", s.Source.Version, s.State, appliedAt, s.Source.Path)
+	}
+	tw.Flush()
 }
@@ -0,0 +1,114 @@
+package migrations
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/config"
+
+	"github.com/pressly/goose/v3/lock"
+)
+
+// testDatabaseURI — переменная окружения с адресом тестовой базы Postgres.
+// Тесты с базой удаляют и заново создают схему, поэтому база должна быть отдельной.
+const testDatabaseURI = "TEST_DATABASE_URI"
+
+func TestExecWithoutDatabase(t *testing.T) {
+	cfg := config.Default()
+	if err := Exec(context.Background(), cfg, "sideways", &bytes.Buffer{}); err == nil || !strings.Contains(err.Error(), "unknown migrate command") {
+		t.Errorf("Expected unknown command error; got %v", err)
+	}
+	if err := Exec(context.Background(), cfg, "up", &bytes.Buffer{}); err == nil || err.Error() != "database URI is empty" {
+		t.Errorf("Expected empty database URI error; got %v", err)
+	}
+}
+
+// lastVersion возвращает номер последней встроенной миграции
+func lastVersion(t *testing.T) int64 {
+	t.Helper()
+	files, err := fs.Glob(embedMigrations, "sql/*.sql")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no embedded migrations: %v", err)
+	}
+	name := files[len(files)-1][len("sql/"):]
+	version, err := strconv.ParseInt(name[:strings.IndexByte(name, '_')], 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return version
+}
+
+func TestExecPostgres(t *testing.T) {
+	uri := os.Getenv(testDatabaseURI)
+	if uri == "" {
+		t.Skip(testDatabaseURI + " is not set")
+	}
+	ctx := context.Background()
+	cfg := config.Default()
+	cfg.FlagDatabaseURI = uri
+	last := lastVersion(t)
+
+	exec := func(command string) string {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := Exec(ctx, cfg, command, &buf); err != nil {
+			t.Fatalf("migrate %s: %v", command, err)
+		}
+		return buf.String()
+	}
+
+	exec("up")
+	if out := exec("version"); out != "version: "+strconv.FormatInt(last, 10)+"\n" {
+		t.Errorf("Expected version %d after up; got %q", last, out)
+	}
+	if out := exec("status"); strings.Contains(out, "pending") {
+		t.Errorf("Expected all migrations applied; got %s", out)
+	}
+	exec("redo")
+	exec("down")
+	if out := exec("version"); out != "version: "+strconv.FormatInt(last-1, 10)+"\n" {
+		t.Errorf("Expected version %d after down; got %q", last-1, out)
+	}
+	exec("up")
+	if out := exec("up"); out != "" {
+		t.Errorf("Expected nothing to apply; got %q", out)
+	}
+
+	// пока миграции выполняет другой экземпляр, ожидание ограничено migrations_timeout;
+	// последняя миграция откатывается, чтобы up пришлось ждать блокировку
+	exec("down")
+	t.Cleanup(func() {
+		exec("up")
+	})
+	db, err := sql.Open("pgx", uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lock.DefaultLockID); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", lock.DefaultLockID) //nolint
+
+	cfg.MigrationsTimeout = time.Second
+	started := time.Now()
+	err = Exec(ctx, cfg, "up", &bytes.Buffer{})
+	if err == nil {
+		t.Errorf("Expected timeout while another instance holds the lock; got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 10*time.Second {
+		t.Errorf("Expected to give up after migrations_timeout; waited %s", elapsed)
+	}
+}
@@ -0,0 +1,102 @@
+-- +goose Up
+
+-- Подготовка существующих данных
+
+-- строки без пользователя не могут быть восстановлены и мешают внешним ключам
+DELETE FROM Orders o WHERE NOT EXISTS (SELECT 1 FROM Users u WHERE u.userID = o.userID);
+DELETE FROM OrdersOperations op WHERE NOT EXISTS (SELECT 1 FROM Users u WHERE u.userID = op.userID);
+DELETE FROM UsersBalance b WHERE NOT EXISTS (SELECT 1 FROM Users u WHERE u.userID = b.userID);
+
+-- пустые значения заменяем значениями по умолчанию
+UPDATE Orders SET orderStatus = 'NEW' WHERE orderStatus IS NULL;
+UPDATE Orders SET accrual = 0 WHERE accrual IS NULL;
+UPDATE Orders SET uploadedAt = now() WHERE uploadedAt IS NULL;
+UPDATE OrdersOperations SET pointsQuantity = 0 WHERE pointsQuantity IS NULL;
+UPDATE OrdersOperations SET processedAt = now() WHERE processedAt IS NULL;
+UPDATE UsersBalance SET pointsSum = 0 WHERE pointsSum IS NULL;
+UPDATE UsersBalance SET pointsLoss = 0 WHERE pointsLoss IS NULL;
+
+-- дубликаты баланса обновлялись одними и теми же запросами по userID,
+-- поэтому оставляем по одной строке с наибольшими значениями
+WITH removed AS (
+    DELETE FROM UsersBalance RETURNING userID, pointsSum, pointsLoss
+)
+INSERT INTO UsersBalance (userID, pointsSum, pointsLoss)
+SELECT userID, MAX(pointsSum), MAX(pointsLoss) FROM removed GROUP BY userID;
+
+-- у каждого пользователя должна быть строка баланса
+INSERT INTO UsersBalance (userID, pointsSum, pointsLoss)
+SELECT u.userID, 0, 0 FROM Users u
+WHERE NOT EXISTS (SELECT 1 FROM UsersBalance b WHERE b.userID = u.userID);
+
+-- Orders
+
+ALTER TABLE Orders DROP CONSTRAINT IF EXISTS orders_ordernumber_key;
+ALTER TABLE Orders ADD CONSTRAINT orders_pkey PRIMARY KEY (orderNumber);
+ALTER TABLE Orders ADD CONSTRAINT orders_userid_fkey FOREIGN KEY (userID) REFERENCES Users (userID);
+ALTER TABLE Orders
+    ALTER COLUMN orderStatus SET DEFAULT 'NEW',
+    ALTER COLUMN orderStatus SET NOT NULL,
+    ALTER COLUMN accrual SET NOT NULL,
+    ALTER COLUMN uploadedAt TYPE timestamptz,
+    ALTER COLUMN uploadedAt SET DEFAULT now(),
+    ALTER COLUMN uploadedAt SET NOT NULL;
+
+CREATE INDEX orders_userid_uploadedat_idx ON Orders (userID, uploadedAt DESC);
+-- заказы, ожидающие расчёта начислений (GetAwaitOrdersQueryRow)
+CREATE INDEX orders_await_idx ON Orders (uploadedAt)
+    WHERE orderStatus != 'INVALID' AND orderStatus != 'PROCESSED';
+
+-- OrdersOperations
+
+ALTER TABLE OrdersOperations ADD COLUMN operationID bigint generated always as identity;
+ALTER TABLE OrdersOperations ADD CONSTRAINT ordersoperations_pkey PRIMARY KEY (operationID);
+ALTER TABLE OrdersOperations ADD CONSTRAINT ordersoperations_userid_fkey FOREIGN KEY (userID) REFERENCES Users (userID);
+ALTER TABLE OrdersOperations
+    ALTER COLUMN pointsQuantity SET NOT NULL,
+    ALTER COLUMN processedAt TYPE timestamptz,
+    ALTER COLUMN processedAt SET DEFAULT now(),
+    ALTER COLUMN processedAt SET NOT NULL;
+
+-- списания пользователя (GetWithdrawalsQuery)
+CREATE INDEX ordersoperations_withdrawals_idx ON OrdersOperations (userID, processedAt DESC)
+    WHERE pointsQuantity < 0;
+
+-- UsersBalance
+
+ALTER TABLE UsersBalance ADD CONSTRAINT usersbalance_pkey PRIMARY KEY (userID);
+ALTER TABLE UsersBalance ADD CONSTRAINT usersbalance_userid_fkey FOREIGN KEY (userID) REFERENCES Users (userID);
+ALTER TABLE UsersBalance
+    ALTER COLUMN pointsSum SET NOT NULL,
+    ALTER COLUMN pointsLoss SET NOT NULL;
+
+-- +goose Down
+
+ALTER TABLE UsersBalance
+    ALTER COLUMN pointsSum DROP NOT NULL,
+    ALTER COLUMN pointsLoss DROP NOT NULL;
+ALTER TABLE UsersBalance DROP CONSTRAINT usersbalance_userid_fkey;
+ALTER TABLE UsersBalance DROP CONSTRAINT usersbalance_pkey;
+
+DROP INDEX ordersoperations_withdrawals_idx;
+ALTER TABLE OrdersOperations
+    ALTER COLUMN pointsQuantity DROP NOT NULL,
+    ALTER COLUMN processedAt DROP NOT NULL,
+    ALTER COLUMN processedAt SET DEFAULT NULL,
+    ALTER COLUMN processedAt TYPE timestamp;
+ALTER TABLE OrdersOperations DROP CONSTRAINT ordersoperations_userid_fkey;
+ALTER TABLE OrdersOperations DROP CONSTRAINT ordersoperations_pkey;
+ALTER TABLE OrdersOperations DROP COLUMN operationID;
+
+DROP INDEX orders_await_idx;
+DROP INDEX orders_userid_uploadedat_idx;
+ALTER TABLE Orders
+    ALTER COLUMN orderStatus DROP DEFAULT,
+    ALTER COLUMN orderStatus DROP NOT NULL,
+    ALTER COLUMN accrual DROP NOT NULL,
+    ALTER COLUMN uploadedAt DROP NOT NULL,
+    ALTER COLUMN uploadedAt SET DEFAULT NULL,
+    ALTER COLUMN uploadedAt TYPE timestamp;
+ALTER TABLE Orders DROP CONSTRAINT orders_userid_fkey;
+ALTER TABLE Orders DROP CONSTRAINT orders_pkey;
+ALTER TABLE Orders ADD CONSTRAINT orders_ordernumber_key UNIQUE (orderNumber);
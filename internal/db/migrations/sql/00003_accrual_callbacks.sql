@@ -0,0 +1,11 @@
+-- +goose Up
+-- подписи принятых уведомлений системы начислений для защиты от повторной отправки
+CREATE TABLE AccrualCallbacks (
+    signature varchar(100) primary key,
+    receivedAt timestamptz not null default now()
+);
+
+CREATE INDEX accrualcallbacks_receivedat_idx ON AccrualCallbacks (receivedAt);
+
+-- +goose Down
+DROP TABLE AccrualCallbacks;
@@ -0,0 +1,26 @@
+-- +goose Up
+-- расписание повторов по каждому заказу: заказ, расчёт которого раз за разом
+-- завершается ошибкой, проверяется всё реже и в итоге получает статус FAILED
+ALTER TABLE Orders
+    ADD COLUMN attempts integer not null default 0,
+    ADD COLUMN nextAttemptAt timestamptz,
+    ADD COLUMN lastError text;
+
+DROP INDEX orders_await_idx;
+CREATE INDEX orders_await_idx ON Orders (uploadedAt)
+    WHERE orderStatus != 'INVALID' AND orderStatus != 'PROCESSED' AND orderStatus != 'FAILED';
+CREATE INDEX orders_failed_idx ON Orders (uploadedAt)
+    WHERE orderStatus = 'FAILED';
+
+-- +goose Down
+DROP INDEX orders_failed_idx;
+DROP INDEX orders_await_idx;
+-- заказы из очереди недоставленных снова ожидают расчёта
+UPDATE Orders SET orderStatus = 'PROCESSING' WHERE orderStatus = 'FAILED';
+CREATE INDEX orders_await_idx ON Orders (uploadedAt)
+    WHERE orderStatus != 'INVALID' AND orderStatus != 'PROCESSED';
+
+ALTER TABLE Orders
+    DROP COLUMN lastError,
+    DROP COLUMN nextAttemptAt,
+    DROP COLUMN attempts;
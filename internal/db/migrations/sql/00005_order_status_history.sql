@@ -0,0 +1,21 @@
+-- +goose Up
+-- история переходов заказа между статусами: когда, откуда пришёл новый статус
+-- (загрузка, опрос системы начислений, уведомление от неё, администратор)
+-- и ответ системы начислений, на основании которого статус изменён
+CREATE TABLE order_status_history (
+    historyID bigint generated always as identity primary key,
+    orderNumber varchar(200) not null references Orders (orderNumber),
+    status varchar(100) not null,
+    source varchar(20) not null,
+    accrualResponse jsonb,
+    changedAt timestamptz not null default now()
+);
+
+CREATE INDEX order_status_history_ordernumber_idx ON order_status_history (orderNumber, changedAt);
+
+-- для существующих заказов известен только текущий статус
+INSERT INTO order_status_history (orderNumber, status, source, changedAt)
+SELECT orderNumber, orderStatus, 'migration', uploadedAt FROM Orders;
+
+-- +goose Down
+DROP TABLE order_status_history;
@@ -0,0 +1,11 @@
+-- +goose Up
+-- статусы заказа ограничены значениями ordersrepo.OrderStatus,
+-- допустимые переходы между ними проверяются в ordersrepo.CheckTransition
+ALTER TABLE Orders ADD CONSTRAINT orders_orderstatus_check
+    CHECK (orderStatus IN ('NEW', 'PROCESSING', 'INVALID', 'PROCESSED', 'FAILED'));
+ALTER TABLE order_status_history ADD CONSTRAINT order_status_history_status_check
+    CHECK (status IN ('NEW', 'PROCESSING', 'INVALID', 'PROCESSED', 'FAILED'));
+
+-- +goose Down
+ALTER TABLE order_status_history DROP CONSTRAINT order_status_history_status_check;
+ALTER TABLE Orders DROP CONSTRAINT orders_orderstatus_check;
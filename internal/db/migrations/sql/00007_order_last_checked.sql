@@ -0,0 +1,6 @@
+-- +goose Up
+-- время последнего обращения к системе начислений по заказу (опрос или уведомление)
+ALTER TABLE Orders ADD COLUMN lastCheckedAt timestamptz;
+
+-- +goose Down
+ALTER TABLE Orders DROP COLUMN lastCheckedAt;
@@ -0,0 +1,15 @@
+-- +goose Up
+-- события пользователя для потока GET /api/user/events: смена статуса заказа и
+-- изменение баланса. Номер события задаёт порядок и служит Last-Event-ID.
+CREATE TABLE user_events (
+    eventID bigint generated always as identity primary key,
+    userID int not null references Users (userID),
+    eventType varchar(20) not null,
+    payload jsonb not null,
+    createdAt timestamptz not null default now()
+);
+
+CREATE INDEX user_events_userid_idx ON user_events (userID, eventID);
+
+-- +goose Down
+DROP TABLE user_events;
@@ -0,0 +1,36 @@
+-- +goose Up
+-- адреса пользователей для уведомлений о событиях (исходящие вебхуки)
+CREATE TABLE webhooks (
+    webhookID bigint generated always as identity primary key,
+    userID int not null references Users (userID),
+    url varchar(2000) not null,
+    secret varchar(100) not null,
+    events text[] not null,
+    createdAt timestamptz not null default now()
+);
+
+CREATE INDEX webhooks_userid_idx ON webhooks (userID);
+
+-- очередь и журнал доставки уведомлений. Записи добавляются в той же транзакции,
+-- что и событие, поэтому уведомление отправляется только о зафиксированных изменениях.
+CREATE TABLE webhook_deliveries (
+    deliveryID bigint generated always as identity primary key,
+    webhookID bigint not null references webhooks (webhookID) ON DELETE CASCADE,
+    eventType varchar(30) not null,
+    payload jsonb not null,
+    status varchar(20) not null default 'PENDING'
+        CONSTRAINT webhook_deliveries_status_check CHECK (status IN ('PENDING', 'DELIVERED', 'FAILED')),
+    attempts int not null default 0,
+    nextAttemptAt timestamptz not null default now(),
+    responseCode int,
+    lastError text,
+    createdAt timestamptz not null default now(),
+    deliveredAt timestamptz
+);
+
+CREATE INDEX webhook_deliveries_pending_idx ON webhook_deliveries (nextAttemptAt) WHERE status = 'PENDING';
+CREATE INDEX webhook_deliveries_webhookid_idx ON webhook_deliveries (webhookID, createdAt);
+
+-- +goose Down
+DROP TABLE webhook_deliveries;
+DROP TABLE webhooks;
@@ -0,0 +1,17 @@
+-- +goose Up
+-- исходящие доменные события (transactional outbox). События записываются в тех же
+-- транзакциях, что и изменения, и передаются внешним получателям после фиксации.
+CREATE TABLE outbox (
+    eventID bigint generated always as identity primary key,
+    eventType varchar(40) not null,
+    userID int not null,
+    payload jsonb not null,
+    createdAt timestamptz not null default now(),
+    publishedAt timestamptz
+);
+
+CREATE INDEX outbox_unpublished_idx ON outbox (eventID) WHERE publishedAt IS NULL;
+CREATE INDEX outbox_publishedat_idx ON outbox (publishedAt) WHERE publishedAt IS NOT NULL;
+
+-- +goose Down
+DROP TABLE outbox;
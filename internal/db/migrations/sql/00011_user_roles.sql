@@ -0,0 +1,25 @@
+-- +goose Up
+-- роли пользователей для административного API и блокировка учётных записей
+ALTER TABLE Users
+    ADD COLUMN role varchar(20) not null default 'user'
+        CONSTRAINT users_role_check CHECK (role IN ('user', 'support', 'admin')),
+    ADD COLUMN disabledAt timestamptz;
+
+-- ручные корректировки баланса с указанием причины и автора
+CREATE TABLE balance_adjustments (
+    adjustmentID bigint generated always as identity primary key,
+    userID int not null references Users (userID),
+    amount real not null CONSTRAINT balance_adjustments_amount_check CHECK (amount != 0),
+    reason text not null,
+    -- actorID — пользователь с ролью admin; NULL — запрос с токеном ADMIN_TOKEN
+    actorID int references Users (userID),
+    createdAt timestamptz not null default now()
+);
+
+CREATE INDEX balance_adjustments_userid_idx ON balance_adjustments (userID, createdAt);
+
+-- +goose Down
+DROP TABLE balance_adjustments;
+ALTER TABLE Users
+    DROP COLUMN disabledAt,
+    DROP COLUMN role;
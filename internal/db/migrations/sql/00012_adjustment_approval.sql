@@ -0,0 +1,33 @@
+-- +goose Up
+-- корректировки баланса проводятся после согласования вторым администратором
+ALTER TABLE balance_adjustments RENAME COLUMN actorID TO requestedBy;
+ALTER TABLE balance_adjustments
+    -- корректировки, созданные до согласования, уже проведены
+    ADD COLUMN status varchar(20) not null default 'APPROVED'
+        CONSTRAINT balance_adjustments_status_check CHECK (status IN ('PENDING', 'APPROVED', 'REJECTED')),
+    -- reviewedBy — администратор, принявший решение; NULL — решения нет или корректировка проведена без согласования
+    ADD COLUMN reviewedBy int references Users (userID),
+    ADD COLUMN reviewedAt timestamptz,
+    ADD COLUMN reviewComment text not null default '';
+ALTER TABLE balance_adjustments ALTER COLUMN status SET DEFAULT 'PENDING';
+UPDATE balance_adjustments SET reviewedAt = createdAt;
+
+CREATE INDEX balance_adjustments_pending_idx ON balance_adjustments (createdAt) WHERE status = 'PENDING';
+
+-- проведённые корректировки попадают в журнал движения баллов, но не в списания
+ALTER TABLE OrdersOperations ADD COLUMN adjustmentID bigint references balance_adjustments (adjustmentID);
+INSERT INTO OrdersOperations (userID, orderNumber, pointsQuantity, processedAt, adjustmentID)
+SELECT userID, '', amount, createdAt, adjustmentID FROM balance_adjustments;
+
+-- +goose Down
+DELETE FROM OrdersOperations WHERE adjustmentID IS NOT NULL;
+ALTER TABLE OrdersOperations DROP COLUMN adjustmentID;
+DROP INDEX balance_adjustments_pending_idx;
+-- несогласованные корректировки не проведены
+DELETE FROM balance_adjustments WHERE status != 'APPROVED';
+ALTER TABLE balance_adjustments
+    DROP COLUMN reviewComment,
+    DROP COLUMN reviewedAt,
+    DROP COLUMN reviewedBy,
+    DROP COLUMN status;
+ALTER TABLE balance_adjustments RENAME COLUMN requestedBy TO actorID;
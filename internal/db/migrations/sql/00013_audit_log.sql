@@ -0,0 +1,44 @@
+-- +goose Up
+-- журнал аудита: входы, регистрации, списания и действия администраторов.
+-- Записи связаны цепочкой хешей: hash каждой записи вычисляется от prevHash
+-- (хеша предыдущей записи) и её содержимого, поэтому изменение, удаление
+-- или вставка записи задним числом обнаруживаются проверкой цепочки.
+CREATE TABLE audit_log (
+    auditID bigint generated always as identity primary key,
+    occurredAt timestamptz not null,
+    actorID int not null default 0,
+    actorRole varchar(20) not null default '',
+    ip varchar(64) not null default '',
+    userAgent varchar(512) not null default '',
+    action varchar(64) not null,
+    target varchar(128) not null default '',
+    result varchar(20) not null CHECK (result IN ('success', 'failure', 'denied')),
+    details jsonb,
+    prevHash char(64) not null,
+    hash char(64) not null UNIQUE
+);
+
+CREATE INDEX audit_log_occurredat_idx ON audit_log (occurredAt, auditID);
+CREATE INDEX audit_log_actor_idx ON audit_log (actorID, occurredAt);
+CREATE INDEX audit_log_target_idx ON audit_log (target, occurredAt);
+
+-- журнал только дополняется: изменение и удаление записей запрещены
+-- +goose StatementBegin
+CREATE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER audit_log_append_only_row
+    BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+
+CREATE TRIGGER audit_log_append_only_truncate
+    BEFORE TRUNCATE ON audit_log
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
+
+-- +goose Down
+DROP TABLE audit_log;
+DROP FUNCTION audit_log_append_only();
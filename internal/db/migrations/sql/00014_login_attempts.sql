@@ -0,0 +1,14 @@
+-- +goose Up
+-- неудачные попытки входа по логину (login:<логин>) и адресу клиента (ip:<адрес>).
+-- До blockedUntil вход с этим логином или адреса отклоняется без проверки пароля.
+CREATE TABLE login_attempts (
+    attemptKey text primary key,
+    failures int not null,
+    lastFailureAt timestamptz not null,
+    blockedUntil timestamptz not null
+);
+
+CREATE INDEX login_attempts_blockeduntil_idx ON login_attempts (blockedUntil);
+
+-- +goose Down
+DROP TABLE login_attempts;
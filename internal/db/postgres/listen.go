@@ -0,0 +1,78 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/logger"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Listen подписывается на канал уведомлений Postgres (LISTEN) и возвращает их содержимое.
+// Соединение забирается из пула на всё время подписки; при обрыве подписка
+// восстанавливается, уведомления, отправленные в промежутке, теряются.
+// Канал закрывается после отмены ctx.
+func (db *DB) Listen(ctx context.Context, channel string) (<-chan string, error) {
+	conn, err := db.listen(ctx, channel)
+	if err != nil {
+		return nil, err
+	}
+	payloads := make(chan string, 64)
+	go func() {
+		defer close(payloads)
+		for {
+			for conn == nil {
+				if !sleep(ctx, time.Second) {
+					return
+				}
+				conn, err = db.listen(ctx, channel)
+				if err != nil {
+					logger.WarnfCtx(ctx, "LISTEN "+channel+": "+err.Error())
+				}
+			}
+			n, err := conn.WaitForNotification(ctx)
+			if err != nil {
+				conn.Close(context.Background()) //nolint
+				conn = nil
+				if ctx.Err() != nil {
+					return
+				}
+				logger.WarnfCtx(ctx, "WaitForNotification: "+err.Error())
+				continue
+			}
+			select {
+			case payloads <- n.Payload:
+			case <-ctx.Done():
+				conn.Close(context.Background()) //nolint
+				return
+			}
+		}
+	}()
+	return payloads, nil
+}
+
+// listen забирает соединение из пула и подписывает его на канал
+func (db *DB) listen(ctx context.Context, channel string) (*pgx.Conn, error) {
+	pooled, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn := pooled.Hijack()
+	if _, err = conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+		conn.Close(context.Background()) //nolint
+		return nil, err
+	}
+	return conn, nil
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
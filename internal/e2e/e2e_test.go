@@ -0,0 +1,1586 @@
+// Package e2e проверяет API сервиса целиком: настоящий роутер поверх хранилища
+// в памяти (или Postgres, если задана TEST_DATABASE_URI) и симулятор системы
+// расчёта начислений на httptest.
+package e2e
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/accrual"
+	"github.com/beliaevke/go-musthave-diploma/internal/accrualsim"
+	"github.com/beliaevke/go-musthave-diploma/internal/config"
+	"github.com/beliaevke/go-musthave-diploma/internal/handlers/orders"
+	"github.com/beliaevke/go-musthave-diploma/internal/handlers/webhooks"
+	"github.com/beliaevke/go-musthave-diploma/internal/middleware/auth"
+	"github.com/beliaevke/go-musthave-diploma/internal/router"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	callbackSecret = "e2e-callback-secret"
+	adminToken     = "e2e-admin-token-value"
+	jwtSecret      = "e2e-jwt-secret-value-0123456789ab"
+)
+
+type testServer struct {
+	*httptest.Server
+	accrual *accrualsim.Simulator
+}
+
+// newTestServer запускает сервис; configure меняет настройки перед запуском
+func newTestServer(t *testing.T, configure ...func(cfg *config.ServerFlags)) *testServer {
+	t.Helper()
+	cfg := config.Default()
+	cfg.AccrualCallbackSecret = callbackSecret
+	cfg.AdminToken = adminToken
+	cfg.JWTSecret = jwtSecret
+	// все запросы тестов идут с одного адреса: паузы после неудачного входа проверяет TestLoginLockout
+	cfg.LoginDelayBase = 0
+	// короткое расписание повторов, чтобы заказ быстро попадал в FAILED
+	cfg.OrderRetryBase = 50 * time.Millisecond
+	cfg.OrderRetryMax = 100 * time.Millisecond
+	cfg.OrderMaxAttempts = 3
+	for _, fn := range configure {
+		fn(&cfg)
+	}
+	store := newStorage(t, cfg)
+
+	// симулятор в сценарном режиме: незаданные заказы не зарегистрированы (204)
+	sim := accrualsim.New(accrualsim.Config{})
+	as := httptest.NewServer(sim.Handler())
+	t.Cleanup(as.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go orders.CheckOrders(ctx, accrual.NewClient(accrual.DefaultConfig(as.URL)), cfg.CheckOrdersTimeout, store.Orders, orders.RetryPolicy{
+		Base:        cfg.OrderRetryBase,
+		Max:         cfg.OrderRetryMax,
+		MaxAttempts: cfg.OrderMaxAttempts,
+		MaxAge:      cfg.OrderMaxAge,
+	})
+	// получатели уведомлений в тестах запущены на localhost
+	go webhooks.DeliverWebhooks(ctx, webhooks.NewClient(5*time.Second, true), store.Webhooks,
+		webhooks.RetryPolicy{Base: 50 * time.Millisecond, Max: 100 * time.Millisecond, MaxAttempts: 3})
+
+	ts := httptest.NewServer(router.NewRouter(store, cfg).R)
+	t.Cleanup(ts.Close)
+	return &testServer{Server: ts, accrual: sim}
+}
+
+// newClient возвращает клиента, сохраняющего куки сессии
+func newClient(t *testing.T) *http.Client {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{Jar: jar}
+}
+
+func testRequest(t *testing.T, client *http.Client, method, url, contentType, body string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(respBody)
+}
+
+func expectStatus(t *testing.T, resp *http.Response, body string, expected int) {
+	t.Helper()
+	if resp.StatusCode != expected {
+		t.Fatalf("%s %s: expected status %d; got %d, body %q",
+			resp.Request.Method, resp.Request.URL.Path, expected, resp.StatusCode, body)
+	}
+}
+
+func register(t *testing.T, ts *testServer, login, password string) *http.Client {
+	t.Helper()
+	client := newClient(t)
+	resp, body := testRequest(t, client, http.MethodPost, ts.URL+"/api/user/register", "application/json",
+		fmt.Sprintf(`{"login":%q,"password":%q}`, login, password))
+	expectStatus(t, resp, body, http.StatusOK)
+	return client
+}
+
+// login открывает новую сессию пользователя
+func login(t *testing.T, ts *testServer, login, password string) *http.Client {
+	t.Helper()
+	client := newClient(t)
+	resp, body := testRequest(t, client, http.MethodPost, ts.URL+"/api/user/login", "application/json",
+		fmt.Sprintf(`{"login":%q,"password":%q}`, login, password))
+	expectStatus(t, resp, body, http.StatusOK)
+	return client
+}
+
+type order struct {
+	Number  string  `json:"number"`
+	Status  string  `json:"status"`
+	Accrual float32 `json:"accrual"`
+}
+
+// waitOrderStatus опрашивает GET /api/user/orders, пока заказ не получит нужный статус
+func waitOrderStatus(t *testing.T, ts *testServer, client *http.Client, number, status string) order {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	var last order
+	for time.Now().Before(deadline) {
+		resp, body := testRequest(t, client, http.MethodGet, ts.URL+"/api/user/orders", "", "")
+		expectStatus(t, resp, body, http.StatusOK)
+		var list []order
+		if err := json.Unmarshal([]byte(body), &list); err != nil {
+			t.Fatalf("unmarshal orders: %v", err)
+		}
+		for _, o := range list {
+			if o.Number == number {
+				last = o
+			}
+		}
+		if last.Status == status {
+			return last
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	t.Fatalf("order %s: expected status %s; last seen %+v", number, status, last)
+	return last
+}
+
+func TestUsers(t *testing.T) {
+	ts := newTestServer(t)
+	register(t, ts, "user", "secret")
+
+	testCases := []struct {
+		name           string
+		path           string
+		body           string
+		expectedStatus int
+		expectCookie   bool
+	}{
+		{name: "register duplicate login", path: "/api/user/register", body: `{"login":"user","password":"other"}`, expectedStatus: http.StatusConflict},
+		{name: "register empty body", path: "/api/user/register", body: ``, expectedStatus: http.StatusBadRequest},
+		{name: "register without password", path: "/api/user/register", body: `{"login":"nopass"}`, expectedStatus: http.StatusBadRequest},
+		{name: "login", path: "/api/user/login", body: `{"login":"user","password":"secret"}`, expectedStatus: http.StatusOK, expectCookie: true},
+		{name: "login wrong password", path: "/api/user/login", body: `{"login":"user","password":"wrong"}`, expectedStatus: http.StatusUnauthorized},
+		{name: "login unknown user", path: "/api/user/login", body: `{"login":"nobody","password":"secret"}`, expectedStatus: http.StatusUnauthorized},
+		{name: "login empty body", path: "/api/user/login", body: ``, expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, body := testRequest(t, newClient(t), http.MethodPost, ts.URL+tc.path, "application/json", tc.body)
+			expectStatus(t, resp, body, tc.expectedStatus)
+			if tc.expectCookie && len(resp.Cookies()) == 0 {
+				t.Errorf("Expected session cookies")
+			}
+		})
+	}
+}
+
+func TestUnauthorized(t *testing.T) {
+	ts := newTestServer(t)
+	for _, path := range []string{"/api/user/orders", "/api/user/balance", "/api/user/withdrawals"} {
+		resp, body := testRequest(t, newClient(t), http.MethodGet, ts.URL+path, "", "")
+		expectStatus(t, resp, body, http.StatusUnauthorized)
+	}
+}
+
+func TestOrdersUpload(t *testing.T) {
+	ts := newTestServer(t)
+	alice := register(t, ts, "alice", "secret")
+	bob := register(t, ts, "bob", "secret")
+
+	resp, body := testRequest(t, alice, http.MethodGet, ts.URL+"/api/user/orders", "", "")
+	expectStatus(t, resp, body, http.StatusNoContent)
+
+	testCases := []struct {
+		name           string
+		client         *http.Client
+		number         string
+		expectedStatus int
+	}{
+		{name: "new order", client: alice, number: "12345678903", expectedStatus: http.StatusAccepted},
+		{name: "already uploaded by same user", client: alice, number: "12345678903", expectedStatus: http.StatusOK},
+		{name: "already uploaded by another user", client: bob, number: "12345678903", expectedStatus: http.StatusConflict},
+		{name: "invalid number", client: alice, number: "12345678901", expectedStatus: http.StatusUnprocessableEntity},
+		{name: "empty body", client: alice, number: "", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, body := testRequest(t, tc.client, http.MethodPost, ts.URL+"/api/user/orders", "text/plain", tc.number)
+			expectStatus(t, resp, body, tc.expectedStatus)
+		})
+	}
+
+	resp, body = testRequest(t, bob, http.MethodGet, ts.URL+"/api/user/orders", "", "")
+	expectStatus(t, resp, body, http.StatusNoContent)
+}
+
+func TestAccrualAndBalance(t *testing.T) {
+	ts := newTestServer(t)
+	reward := 500.0
+	ts.accrual.SetScript("12345678903",
+		accrualsim.Step{Status: accrualsim.StatusRegistered},
+		accrualsim.Step{Code: http.StatusTooManyRequests, RetryAfter: time.Second},
+		accrualsim.Step{Status: accrualsim.StatusProcessing},
+		accrualsim.Step{Status: accrualsim.StatusProcessed, Accrual: &reward},
+	)
+	ts.accrual.SetScript("9278923470", accrualsim.Step{Status: accrualsim.StatusInvalid})
+
+	client := register(t, ts, "user", "secret")
+	for _, number := range []string{"12345678903", "9278923470"} {
+		resp, body := testRequest(t, client, http.MethodPost, ts.URL+"/api/user/orders", "text/plain", number)
+		expectStatus(t, resp, body, http.StatusAccepted)
+	}
+
+	processed := waitOrderStatus(t, ts, client, "12345678903", "PROCESSED")
+	if processed.Accrual != 500 {
+		t.Errorf("Expected accrual 500; got %v", processed.Accrual)
+	}
+	waitOrderStatus(t, ts, client, "9278923470", "INVALID")
+
+	var balance struct {
+		Current   float32 `json:"current"`
+		Withdrawn float32 `json:"withdrawn"`
+	}
+	getBalance := func() {
+		t.Helper()
+		resp, body := testRequest(t, client, http.MethodGet, ts.URL+"/api/user/balance", "", "")
+		expectStatus(t, resp, body, http.StatusOK)
+		if err := json.Unmarshal([]byte(body), &balance); err != nil {
+			t.Fatalf("unmarshal balance: %v", err)
+		}
+	}
+	getBalance()
+	if balance.Current != 500 || balance.Withdrawn != 0 {
+		t.Errorf("Expected balance 500/0; got %+v", balance)
+	}
+
+	resp, body := testRequest(t, client, http.MethodGet, ts.URL+"/api/user/withdrawals", "", "")
+	expectStatus(t, resp, body, http.StatusNoContent)
+
+	testCases := []struct {
+		name           string
+		body           string
+		expectedStatus int
+	}{
+		{name: "insufficient funds", body: `{"order":"2377225624","sum":751}`, expectedStatus: http.StatusPaymentRequired},
+		{name: "invalid order number", body: `{"order":"2377225625","sum":10}`, expectedStatus: http.StatusUnprocessableEntity},
+		{name: "negative sum", body: `{"order":"2377225624","sum":-100}`, expectedStatus: http.StatusBadRequest},
+		{name: "zero sum", body: `{"order":"2377225624","sum":0}`, expectedStatus: http.StatusBadRequest},
+		{name: "withdraw", body: `{"order":"2377225624","sum":251}`, expectedStatus: http.StatusOK},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, body := testRequest(t, client, http.MethodPost, ts.URL+"/api/user/balance/withdraw", "application/json", tc.body)
+			expectStatus(t, resp, body, tc.expectedStatus)
+		})
+	}
+
+	getBalance()
+	if balance.Current != 249 || balance.Withdrawn != 251 {
+		t.Errorf("Expected balance 249/251; got %+v", balance)
+	}
+
+	resp, body = testRequest(t, client, http.MethodGet, ts.URL+"/api/user/withdrawals", "", "")
+	expectStatus(t, resp, body, http.StatusOK)
+	var withdrawals []struct {
+		Order string  `json:"order"`
+		Sum   float32 `json:"sum"`
+	}
+	if err := json.Unmarshal([]byte(body), &withdrawals); err != nil {
+		t.Fatalf("unmarshal withdrawals: %v", err)
+	}
+	if len(withdrawals) != 1 || withdrawals[0].Order != "2377225624" || withdrawals[0].Sum != 251 {
+		t.Errorf("Unexpected withdrawals: %+v", withdrawals)
+	}
+
+	// списания выдаются постранично, сначала новые
+	resp, body = testRequest(t, client, http.MethodPost, ts.URL+"/api/user/balance/withdraw", "application/json",
+		`{"order":"12345678903","sum":9}`)
+	expectStatus(t, resp, body, http.StatusOK)
+	resp, body = testRequest(t, client, http.MethodGet, ts.URL+"/api/user/withdrawals?limit=1", "", "")
+	expectStatus(t, resp, body, http.StatusOK)
+	if !strings.Contains(body, `"order":"12345678903"`) || resp.Header.Get("X-Next-Cursor") == "" {
+		t.Fatalf("Expected latest withdrawal and next cursor; got %s", body)
+	}
+	resp, body = testRequest(t, client, http.MethodGet,
+		ts.URL+"/api/user/withdrawals?limit=1&cursor="+resp.Header.Get("X-Next-Cursor"), "", "")
+	expectStatus(t, resp, body, http.StatusOK)
+	if !strings.Contains(body, `"order":"2377225624"`) || resp.Header.Get("X-Next-Cursor") != "" {
+		t.Errorf("Expected last withdrawal without next cursor; got %s", body)
+	}
+}
+
+func TestConcurrentWithdrawals(t *testing.T) {
+	ts := newTestServer(t)
+	reward := 500.0
+	ts.accrual.SetScript("12345678903", accrualsim.Step{Status: accrualsim.StatusProcessed, Accrual: &reward})
+	client := register(t, ts, "user", "secret")
+	resp, body := testRequest(t, client, http.MethodPost, ts.URL+"/api/user/orders", "text/plain", "12345678903")
+	expectStatus(t, resp, body, http.StatusAccepted)
+	waitOrderStatus(t, ts, client, "12345678903", "PROCESSED")
+
+	// одновременные списания не расходуют одни и те же баллы дважды
+	const requests = 10
+	codes := make(chan int, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, _ := testRequest(t, client, http.MethodPost, ts.URL+"/api/user/balance/withdraw", "application/json",
+				`{"order":"2377225624","sum":100}`)
+			codes <- resp.StatusCode
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	counts := map[int]int{}
+	for code := range codes {
+		counts[code]++
+	}
+	if counts[http.StatusOK] != 5 || counts[http.StatusPaymentRequired] != 5 {
+		t.Errorf("Expected 5 withdrawals and 5 refusals; got %v", counts)
+	}
+	resp, body = testRequest(t, client, http.MethodGet, ts.URL+"/api/user/balance", "", "")
+	expectStatus(t, resp, body, http.StatusOK)
+	if !strings.Contains(body, `"current":0,"withdrawn":500`) {
+		t.Errorf("Expected balance 0/500; got %s", body)
+	}
+}
+
+func TestOrderTimeline(t *testing.T) {
+	ts := newTestServer(t)
+	reward := 150.0
+	ts.accrual.SetScript("12345678903",
+		accrualsim.Step{Status: accrualsim.StatusProcessing},
+		accrualsim.Step{Status: accrualsim.StatusProcessed, Accrual: &reward},
+	)
+
+	client := register(t, ts, "user", "secret")
+	resp, body := testRequest(t, client, http.MethodPost, ts.URL+"/api/user/orders", "text/plain", "12345678903")
+	expectStatus(t, resp, body, http.StatusAccepted)
+	waitOrderStatus(t, ts, client, "12345678903", "PROCESSED")
+
+	resp, body = testRequest(t, client, http.MethodGet, ts.URL+"/api/user/orders/12345678903", "", "")
+	expectStatus(t, resp, body, http.StatusOK)
+	var timeline struct {
+		order
+		UploadedAt    time.Time  `json:"uploaded_at"`
+		UpdatedAt     time.Time  `json:"updated_at"`
+		LastCheckedAt *time.Time `json:"last_checked_at"`
+		History       []struct {
+			Status          string          `json:"status"`
+			Source          string          `json:"source"`
+			AccrualResponse json.RawMessage `json:"accrual_response"`
+			ChangedAt       time.Time       `json:"changed_at"`
+		} `json:"history"`
+	}
+	if err := json.Unmarshal([]byte(body), &timeline); err != nil {
+		t.Fatalf("unmarshal order: %v", err)
+	}
+	if timeline.Status != "PROCESSED" || timeline.Accrual != 150 {
+		t.Errorf("Expected PROCESSED with accrual 150; got %+v", timeline.order)
+	}
+	var statuses []string
+	for _, h := range timeline.History {
+		statuses = append(statuses, h.Status+"/"+h.Source)
+	}
+	if strings.Join(statuses, ",") != "NEW/upload,PROCESSING/poller,PROCESSED/poller" {
+		t.Errorf("Unexpected history %v", statuses)
+	}
+	if len(timeline.History) == 3 {
+		if !strings.Contains(string(timeline.History[2].AccrualResponse), `"accrual":150`) {
+			t.Errorf("Expected accrual response in history; got %s", timeline.History[2].AccrualResponse)
+		}
+		// время загрузки заказа не меняется при смене статуса
+		if !timeline.UploadedAt.Equal(timeline.History[0].ChangedAt) {
+			t.Errorf("Expected uploaded_at %v; got %v", timeline.History[0].ChangedAt, timeline.UploadedAt)
+		}
+		if !timeline.UpdatedAt.Equal(timeline.History[2].ChangedAt) {
+			t.Errorf("Expected updated_at %v; got %v", timeline.History[2].ChangedAt, timeline.UpdatedAt)
+		}
+	}
+	if timeline.LastCheckedAt == nil || timeline.LastCheckedAt.Before(timeline.UploadedAt) {
+		t.Errorf("Expected last_checked_at after upload; got %v", timeline.LastCheckedAt)
+	}
+
+	resp, body = testRequest(t, client, http.MethodGet, ts.URL+"/api/user/orders/9278923470", "", "")
+	expectStatus(t, resp, body, http.StatusNotFound)
+	other := register(t, ts, "other", "secret")
+	resp, body = testRequest(t, other, http.MethodGet, ts.URL+"/api/user/orders/12345678903", "", "")
+	expectStatus(t, resp, body, http.StatusForbidden)
+}
+
+type sseEvent struct {
+	ID   string
+	Type string
+	Data string
+}
+
+// openEvents открывает поток GET /api/user/events и разбирает его события в канал
+func openEvents(t *testing.T, ts *testServer, client *http.Client, lastEventID string) <-chan sseEvent {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/api/user/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		resp.Body.Close()
+		t.Fatalf("GET /api/user/events: unexpected status %d, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	events := make(chan sseEvent, 16)
+	go func() {
+		defer resp.Body.Close()
+		defer close(events)
+		var e sseEvent
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			field, value, _ := strings.Cut(scanner.Text(), ": ")
+			switch field {
+			case "id":
+				e.ID = value
+			case "event":
+				e.Type = value
+			case "data":
+				e.Data = value
+			case "":
+				if e.ID != "" {
+					events <- e
+				}
+				e = sseEvent{}
+			}
+		}
+	}()
+	return events
+}
+
+func nextEvent(t *testing.T, events <-chan sseEvent) sseEvent {
+	t.Helper()
+	select {
+	case e, ok := <-events:
+		if !ok {
+			t.Fatal("event stream closed")
+		}
+		return e
+	case <-time.After(10 * time.Second):
+		t.Fatal("no event received")
+	}
+	return sseEvent{}
+}
+
+func TestUserEvents(t *testing.T) {
+	ts := newTestServer(t)
+	reward := 150.0
+	ts.accrual.SetScript("12345678903",
+		accrualsim.Step{Status: accrualsim.StatusProcessing},
+		accrualsim.Step{Status: accrualsim.StatusProcessed, Accrual: &reward},
+	)
+	client := register(t, ts, "user", "secret")
+	other := register(t, ts, "other", "secret")
+
+	events := openEvents(t, ts, client, "")
+	otherEvents := openEvents(t, ts, other, "0")
+
+	resp, body := testRequest(t, client, http.MethodPost, ts.URL+"/api/user/orders", "text/plain", "12345678903")
+	expectStatus(t, resp, body, http.StatusAccepted)
+	var received []sseEvent
+	for len(received) < 3 {
+		received = append(received, nextEvent(t, events))
+	}
+	resp, body = testRequest(t, client, http.MethodPost, ts.URL+"/api/user/balance/withdraw", "application/json",
+		`{"order":"2377225624","sum":100}`)
+	expectStatus(t, resp, body, http.StatusOK)
+	received = append(received, nextEvent(t, events))
+
+	expected := []string{
+		`order {"number":"12345678903","status":"PROCESSING","changed_at":`,
+		`order {"number":"12345678903","status":"PROCESSED","accrual":150,"changed_at":`,
+		`balance {"current":150,"withdrawn":0,"order":"12345678903","sum":150,"changed_at":`,
+		`balance {"current":50,"withdrawn":100,"order":"2377225624","sum":-100,"changed_at":`,
+	}
+	for i, e := range received {
+		if !strings.HasPrefix(e.Type+" "+e.Data, expected[i]) {
+			t.Errorf("Event %d: expected %s...; got %s %s", i, expected[i], e.Type, e.Data)
+		}
+	}
+
+	// после переподключения поток продолжается с события, следующего за Last-Event-ID
+	resumed := openEvents(t, ts, client, received[0].ID)
+	for i := 1; i < len(received); i++ {
+		if e := nextEvent(t, resumed); e != received[i] {
+			t.Errorf("Resumed event %d: expected %+v; got %+v", i, received[i], e)
+		}
+	}
+
+	select {
+	case e := <-otherEvents:
+		t.Errorf("Expected no events for another user; got %+v", e)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/user/events", nil)
+	req.Header.Set("Last-Event-ID", "abc")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid Last-Event-ID; got %d", resp.StatusCode)
+	}
+}
+
+func TestOrdersBatch(t *testing.T) {
+	ts := newTestServer(t)
+	owner := register(t, ts, "owner", "secret")
+	resp, body := testRequest(t, owner, http.MethodPost, ts.URL+"/api/user/orders", "text/plain", "79927398713")
+	expectStatus(t, resp, body, http.StatusAccepted)
+
+	client := register(t, ts, "user", "secret")
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		expected    string
+	}{
+		{"json", "application/json", `["12345678903", "12345678903", "12345678901", "79927398713"]`,
+			"12345678903:accepted,12345678903:already_yours,12345678901:invalid,79927398713:conflict"},
+		{"text", "text/plain; charset=utf-8", "12345678903\r\n\n9278923470\n",
+			"12345678903:already_yours,9278923470:accepted"},
+		{"csv", "text/csv", "number,comment\n2377225624,\"first, csv\"\n",
+			"2377225624:accepted"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := testRequest(t, client, http.MethodPost, ts.URL+"/api/user/orders/batch", tt.contentType, tt.body)
+			expectStatus(t, resp, body, http.StatusOK)
+			var items []struct {
+				Number string `json:"number"`
+				Result string `json:"result"`
+			}
+			if err := json.Unmarshal([]byte(body), &items); err != nil {
+				t.Fatalf("unmarshal batch results: %v", err)
+			}
+			var got []string
+			for _, item := range items {
+				got = append(got, item.Number+":"+item.Result)
+			}
+			if strings.Join(got, ",") != tt.expected {
+				t.Errorf("Expected %s; got %s", tt.expected, strings.Join(got, ","))
+			}
+		})
+	}
+
+	resp, body = testRequest(t, client, http.MethodPost, ts.URL+"/api/user/orders/batch", "application/xml", "<orders/>")
+	expectStatus(t, resp, body, http.StatusUnsupportedMediaType)
+	resp, body = testRequest(t, client, http.MethodPost, ts.URL+"/api/user/orders/batch", "application/json", "[]")
+	expectStatus(t, resp, body, http.StatusBadRequest)
+	resp, body = testRequest(t, client, http.MethodPost, ts.URL+"/api/user/orders/batch", "application/json", `{"order":"1"}`)
+	expectStatus(t, resp, body, http.StatusBadRequest)
+
+	resp, body = testRequest(t, client, http.MethodGet, ts.URL+"/api/user/orders", "", "")
+	expectStatus(t, resp, body, http.StatusOK)
+	var orders []order
+	if err := json.Unmarshal([]byte(body), &orders); err != nil {
+		t.Fatalf("unmarshal orders: %v", err)
+	}
+	if len(orders) != 3 {
+		t.Errorf("Expected 3 orders; got %+v", orders)
+	}
+}
+
+func TestOrdersPagination(t *testing.T) {
+	ts := newTestServer(t)
+	numbers := []string{"12345678903", "9278923470", "2377225624"}
+	for _, number := range numbers[:2] {
+		ts.accrual.SetScript(number, accrualsim.Step{Status: accrualsim.StatusRegistered})
+	}
+	ts.accrual.SetScript(numbers[2], accrualsim.Step{Status: accrualsim.StatusInvalid})
+
+	client := register(t, ts, "user", "secret")
+	for _, number := range numbers {
+		resp, body := testRequest(t, client, http.MethodPost, ts.URL+"/api/user/orders", "text/plain", number)
+		expectStatus(t, resp, body, http.StatusAccepted)
+	}
+	waitOrderStatus(t, ts, client, numbers[0], "PROCESSING")
+	waitOrderStatus(t, ts, client, numbers[1], "PROCESSING")
+	waitOrderStatus(t, ts, client, numbers[2], "INVALID")
+
+	// list проходит все страницы и возвращает номера заказов в порядке выдачи
+	list := func(query string) []string {
+		t.Helper()
+		var got []string
+		url := ts.URL + "/api/user/orders?" + query
+		for page := 0; page < 10; page++ {
+			resp, body := testRequest(t, client, http.MethodGet, url, "", "")
+			if resp.StatusCode == http.StatusNoContent {
+				break
+			}
+			expectStatus(t, resp, body, http.StatusOK)
+			var orders []order
+			if err := json.Unmarshal([]byte(body), &orders); err != nil {
+				t.Fatalf("unmarshal orders: %v", err)
+			}
+			for _, o := range orders {
+				got = append(got, o.Number)
+			}
+			link := resp.Header.Get("Link")
+			if link == "" {
+				if resp.Header.Get("X-Next-Cursor") != "" {
+					t.Errorf("Expected Link header with X-Next-Cursor")
+				}
+				break
+			}
+			url = ts.URL + strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+		}
+		return got
+	}
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{"", []string{numbers[2], numbers[1], numbers[0]}},
+		{"limit=1", []string{numbers[2], numbers[1], numbers[0]}},
+		{"limit=2&sort=uploaded_at", []string{numbers[0], numbers[1], numbers[2]}},
+		{"status=processing&limit=1", []string{numbers[1], numbers[0]}},
+		{"status=INVALID", []string{numbers[2]}},
+		{"status=NEW", nil},
+		{"to=2000-01-01T00:00:00Z", nil},
+	}
+	for _, tt := range tests {
+		if got := list(tt.query); strings.Join(got, ",") != strings.Join(tt.expected, ",") {
+			t.Errorf("%q: expected %v; got %v", tt.query, tt.expected, got)
+		}
+	}
+
+	for _, query := range []string{"limit=0", "sort=number", "status=DONE", "status=FAILED", "cursor=broken"} {
+		resp, body := testRequest(t, client, http.MethodGet, ts.URL+"/api/user/orders?"+query, "", "")
+		expectStatus(t, resp, body, http.StatusBadRequest)
+	}
+}
+
+func TestAccrualCallback(t *testing.T) {
+	ts := newTestServer(t)
+	// опрос не продвинет заказ дальше REGISTERED, результат придёт уведомлением
+	ts.accrual.SetScript("12345678903", accrualsim.Step{Status: accrualsim.StatusRegistered})
+
+	client := register(t, ts, "user", "secret")
+	resp, body := testRequest(t, client, http.MethodPost, ts.URL+"/api/user/orders", "text/plain", "12345678903")
+	expectStatus(t, resp, body, http.StatusAccepted)
+	waitOrderStatus(t, ts, client, "12345678903", "PROCESSING")
+
+	callback := func(payload string, sentAt time.Time, secret string) (*http.Response, string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/internal/accrual/callback", strings.NewReader(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(orders.TimestampHeader, strconv.FormatInt(sentAt.Unix(), 10))
+		req.Header.Set(orders.SignatureHeader, orders.Sign([]byte(secret), sentAt.Unix(), []byte(payload)))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		respBody, _ := io.ReadAll(resp.Body)
+		return resp, string(respBody)
+	}
+
+	processed := `{"order":"12345678903","status":"PROCESSED","accrual":300}`
+	now := time.Now()
+
+	resp, body = callback(processed, now, "wrong-secret-value")
+	expectStatus(t, resp, body, http.StatusUnauthorized)
+	resp, body = callback(processed, now.Add(-time.Hour), callbackSecret)
+	expectStatus(t, resp, body, http.StatusUnauthorized)
+	resp, body = callback(`{"order":"9278923470","status":"PROCESSED","accrual":300}`, now, callbackSecret)
+	expectStatus(t, resp, body, http.StatusNotFound)
+
+	resp, body = callback(processed, now, callbackSecret)
+	expectStatus(t, resp, body, http.StatusOK)
+	resp, body = callback(processed, now, callbackSecret)
+	expectStatus(t, resp, body, http.StatusConflict)
+	// повторный результат с новой подписью не начисляет баллы второй раз
+	resp, body = callback(processed, now.Add(time.Second), callbackSecret)
+	expectStatus(t, resp, body, http.StatusOK)
+
+	waitOrderStatus(t, ts, client, "12345678903", "PROCESSED")
+	// рассчитанный заказ нельзя вернуть в обработку
+	resp, body = callback(`{"order":"12345678903","status":"PROCESSING"}`, now.Add(2*time.Second), callbackSecret)
+	expectStatus(t, resp, body, http.StatusConflict)
+	resp, body = testRequest(t, client, http.MethodGet, ts.URL+"/api/user/balance", "", "")
+	expectStatus(t, resp, body, http.StatusOK)
+	if !strings.Contains(body, `"current":300`) {
+		t.Errorf("Expected balance 300; got %s", body)
+	}
+}
+
+func TestFailedOrdersRequeue(t *testing.T) {
+	ts := newTestServer(t)
+	// система начислений отвечает на заказ неожиданным кодом, пока сценарий не изменится
+	ts.accrual.SetScript("2377225624", accrualsim.Step{Code: http.StatusNotFound})
+
+	client := register(t, ts, "user", "secret")
+	resp, body := testRequest(t, client, http.MethodPost, ts.URL+"/api/user/orders", "text/plain", "2377225624")
+	expectStatus(t, resp, body, http.StatusAccepted)
+
+	admin := &http.Client{Transport: bearerTransport(adminToken)}
+
+	resp, body = testRequest(t, http.DefaultClient, http.MethodGet, ts.URL+"/api/admin/orders/failed", "", "")
+	expectStatus(t, resp, body, http.StatusUnauthorized)
+
+	type failedOrder struct {
+		UserID    int    `json:"user_id"`
+		Number    string `json:"number"`
+		Attempts  int    `json:"attempts"`
+		LastError string `json:"last_error"`
+	}
+	var failed []failedOrder
+	deadline := time.Now().Add(10 * time.Second)
+	for len(failed) == 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+		resp, body = testRequest(t, admin, http.MethodGet, ts.URL+"/api/admin/orders/failed", "", "")
+		expectStatus(t, resp, body, http.StatusOK)
+		if err := json.Unmarshal([]byte(body), &failed); err != nil {
+			t.Fatalf("unmarshal failed orders: %v", err)
+		}
+	}
+	if len(failed) != 1 || failed[0].Number != "2377225624" || failed[0].Attempts != 3 || failed[0].LastError == "" {
+		t.Fatalf("Expected order 2377225624 failed after 3 attempts; got %+v", failed)
+	}
+	// пользователь видит приостановленный заказ в обработке
+	waitOrderStatus(t, ts, client, "2377225624", "PROCESSING")
+
+	resp, body = testRequest(t, admin, http.MethodPost, ts.URL+"/api/admin/orders/12345678903/requeue", "", "")
+	expectStatus(t, resp, body, http.StatusNotFound)
+
+	reward := 100.0
+	ts.accrual.SetScript("2377225624", accrualsim.Step{Status: accrualsim.StatusProcessed, Accrual: &reward})
+	resp, body = testRequest(t, admin, http.MethodPost, ts.URL+"/api/admin/orders/2377225624/requeue", "", "")
+	expectStatus(t, resp, body, http.StatusOK)
+	waitOrderStatus(t, ts, client, "2377225624", "PROCESSED")
+
+	resp, body = testRequest(t, admin, http.MethodPost, ts.URL+"/api/admin/orders/2377225624/requeue", "", "")
+	expectStatus(t, resp, body, http.StatusConflict)
+}
+
+func TestOrdersAfterPollTimeout(t *testing.T) {
+	ts := newTestServer(t, func(cfg *config.ServerFlags) {
+		cfg.CheckOrdersTimeout = 200 * time.Millisecond
+	})
+	reward := 100.0
+	ts.accrual.SetScript("12345678903", accrualsim.Step{Status: accrualsim.StatusProcessed, Accrual: &reward})
+	client := register(t, ts, "user", "secret")
+
+	// обработчик продолжает работу после check_orders_timeout: он ограничивает только один опрос
+	time.Sleep(500 * time.Millisecond)
+	resp, body := testRequest(t, client, http.MethodPost, ts.URL+"/api/user/orders", "text/plain", "12345678903")
+	expectStatus(t, resp, body, http.StatusAccepted)
+	waitOrderStatus(t, ts, client, "12345678903", "PROCESSED")
+}
+
+func TestOrderRetryAfterPollTimeout(t *testing.T) {
+	ts := newTestServer(t, func(cfg *config.ServerFlags) {
+		cfg.CheckOrdersTimeout = 200 * time.Millisecond
+		cfg.OrderRetryBase = time.Second
+		cfg.OrderRetryMax = time.Second
+	})
+	reward := 100.0
+	ts.accrual.SetScript("2377225624",
+		accrualsim.Step{Code: http.StatusNotFound},
+		accrualsim.Step{Status: accrualsim.StatusProcessed, Accrual: &reward},
+	)
+	client := register(t, ts, "user", "secret")
+	resp, body := testRequest(t, client, http.MethodPost, ts.URL+"/api/user/orders", "text/plain", "2377225624")
+	expectStatus(t, resp, body, http.StatusAccepted)
+
+	// повтор назначен позже check_orders_timeout и всё равно выполняется
+	start := time.Now()
+	waitOrderStatus(t, ts, client, "2377225624", "PROCESSED")
+	if elapsed := time.Since(start); elapsed < 800*time.Millisecond {
+		t.Errorf("Expected order processed by the retry in about a second; got %s", elapsed)
+	}
+}
+
+func TestAdminEventsWebSocket(t *testing.T) {
+	ts := newTestServer(t)
+	reward := 100.0
+	ts.accrual.SetScript("12345678903", accrualsim.Step{Status: accrualsim.StatusProcessed, Accrual: &reward})
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/api/admin/events/ws"
+	header := http.Header{"Authorization": {"Bearer " + adminToken}}
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 without admin token; got %v", err)
+	}
+	_, resp, err = websocket.DefaultDialer.Dial(wsURL+"?types=order.unknown", header)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for unknown event type; got %v", err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"?types=order.uploaded,order.processed,withdrawal", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	type message struct {
+		Type    string  `json:"type"`
+		UserID  int     `json:"user_id"`
+		Order   string  `json:"order"`
+		Accrual float32 `json:"accrual"`
+		Sum     float32 `json:"sum"`
+		Error   string  `json:"error"`
+	}
+	next := func() message {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+		var m message
+		if err := conn.ReadJSON(&m); err != nil {
+			t.Fatalf("read event: %v", err)
+		}
+		return m
+	}
+
+	client := register(t, ts, "user", "secret")
+	resp, body := testRequest(t, client, http.MethodPost, ts.URL+"/api/user/orders", "text/plain", "12345678903")
+	expectStatus(t, resp, body, http.StatusAccepted)
+	if m := next(); m.Type != "order.uploaded" || m.Order != "12345678903" || m.UserID == 0 {
+		t.Errorf("Expected order.uploaded; got %+v", m)
+	}
+	if m := next(); m.Type != "order.processed" || m.Accrual != 100 {
+		t.Errorf("Expected order.processed with accrual 100; got %+v", m)
+	}
+	resp, body = testRequest(t, client, http.MethodPost, ts.URL+"/api/user/balance/withdraw", "application/json",
+		`{"order":"2377225624","sum":40}`)
+	expectStatus(t, resp, body, http.StatusOK)
+	if m := next(); m.Type != "withdrawal" || m.Order != "2377225624" || m.Sum != 40 {
+		t.Errorf("Expected withdrawal of 40; got %+v", m)
+	}
+
+	if err = conn.WriteJSON(map[string]any{"types": []string{"order.invalid"}}); err != nil {
+		t.Fatal(err)
+	}
+	if m := next(); m.Type != "subscribed" {
+		t.Errorf("Expected subscription ack; got %+v", m)
+	}
+	if err = conn.WriteJSON(map[string]any{"types": []string{"order.unknown"}}); err != nil {
+		t.Fatal(err)
+	}
+	if m := next(); m.Type != "error" || m.Error == "" {
+		t.Errorf("Expected subscription error; got %+v", m)
+	}
+}
+
+// bearerTransport добавляет к запросам заголовок Authorization с токеном
+type bearerTransport string
+
+func (token bearerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", "Bearer "+string(token))
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+type webhookDelivery struct {
+	ID           int64           `json:"id"`
+	Event        string          `json:"event"`
+	Payload      json.RawMessage `json:"payload"`
+	Status       string          `json:"status"`
+	Attempts     int             `json:"attempts"`
+	ResponseCode int             `json:"response_code"`
+	LastError    string          `json:"last_error"`
+}
+
+// waitDelivery опрашивает журнал доставки, пока последнее уведомление не получит нужный статус
+func waitDelivery(t *testing.T, ts *testServer, client *http.Client, webhookID int64, status string) webhookDelivery {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	var last webhookDelivery
+	for time.Now().Before(deadline) {
+		resp, body := testRequest(t, client, http.MethodGet,
+			ts.URL+"/api/user/webhooks/"+strconv.FormatInt(webhookID, 10)+"/deliveries", "", "")
+		if resp.StatusCode == http.StatusOK {
+			var list []webhookDelivery
+			if err := json.Unmarshal([]byte(body), &list); err != nil {
+				t.Fatalf("unmarshal deliveries: %v", err)
+			}
+			last = list[0]
+			if last.Status == status {
+				return last
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("webhook %d: expected delivery status %s; last seen %+v", webhookID, status, last)
+	return last
+}
+
+func TestWebhooks(t *testing.T) {
+	ts := newTestServer(t)
+	reward := 100.0
+	ts.accrual.SetScript("12345678903", accrualsim.Step{Status: accrualsim.StatusProcessed, Accrual: &reward})
+
+	type received struct {
+		header http.Header
+		body   []byte
+	}
+	requests := make(chan received, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- received{header: r.Header.Clone(), body: body}
+	}))
+	defer receiver.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	client := register(t, ts, "user", "secret")
+	resp, body := testRequest(t, client, http.MethodPost, ts.URL+"/api/user/webhooks", "application/json",
+		`{"url":"ftp://example.com/hook"}`)
+	expectStatus(t, resp, body, http.StatusBadRequest)
+	resp, body = testRequest(t, client, http.MethodPost, ts.URL+"/api/user/webhooks", "application/json",
+		fmt.Sprintf(`{"url":%q,"events":["order.unknown"]}`, receiver.URL))
+	expectStatus(t, resp, body, http.StatusBadRequest)
+
+	type webhook struct {
+		ID     int64    `json:"id"`
+		Secret string   `json:"secret"`
+		Events []string `json:"events"`
+	}
+	resp, body = testRequest(t, client, http.MethodPost, ts.URL+"/api/user/webhooks", "application/json",
+		fmt.Sprintf(`{"url":%q,"events":["order.processed"]}`, receiver.URL))
+	expectStatus(t, resp, body, http.StatusCreated)
+	var hook webhook
+	if err := json.Unmarshal([]byte(body), &hook); err != nil || hook.Secret == "" {
+		t.Fatalf("Expected webhook with secret; got %q", body)
+	}
+	resp, body = testRequest(t, client, http.MethodPost, ts.URL+"/api/user/webhooks", "application/json",
+		fmt.Sprintf(`{"url":%q,"events":["withdrawal"]}`, failing.URL))
+	expectStatus(t, resp, body, http.StatusCreated)
+	var failingHook webhook
+	if err := json.Unmarshal([]byte(body), &failingHook); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, body = testRequest(t, client, http.MethodGet, ts.URL+"/api/user/webhooks", "", "")
+	expectStatus(t, resp, body, http.StatusOK)
+	var hooks []webhook
+	if err := json.Unmarshal([]byte(body), &hooks); err != nil || len(hooks) != 2 || hooks[0].Secret != "" {
+		t.Errorf("Expected 2 webhooks without secrets; got %q", body)
+	}
+
+	// уведомление о расчёте заказа подписано ключом адреса
+	resp, body = testRequest(t, client, http.MethodPost, ts.URL+"/api/user/orders", "text/plain", "12345678903")
+	expectStatus(t, resp, body, http.StatusAccepted)
+	var got received
+	select {
+	case got = <-requests:
+	case <-time.After(10 * time.Second):
+		t.Fatal("webhook was not delivered")
+	}
+	timestamp, err := strconv.ParseInt(got.header.Get(webhooks.TimestampHeader), 10, 64)
+	if err != nil {
+		t.Fatalf("invalid timestamp header: %v", err)
+	}
+	if sig := got.header.Get(webhooks.SignatureHeader); sig != webhooks.Sign([]byte(hook.Secret), timestamp, got.body) {
+		t.Errorf("Invalid webhook signature %q", sig)
+	}
+	if event := got.header.Get(webhooks.EventHeader); event != "order.processed" {
+		t.Errorf("Expected event order.processed; got %q", event)
+	}
+	var payload struct {
+		Type    string  `json:"type"`
+		Order   string  `json:"order"`
+		Accrual float32 `json:"accrual"`
+	}
+	if err = json.Unmarshal(got.body, &payload); err != nil || payload.Order != "12345678903" || payload.Accrual != 100 {
+		t.Errorf("Unexpected webhook payload %s", got.body)
+	}
+	delivered := waitDelivery(t, ts, client, hook.ID, "DELIVERED")
+	if delivered.Attempts != 1 || delivered.ResponseCode != http.StatusOK ||
+		got.header.Get(webhooks.DeliveryHeader) != strconv.FormatInt(delivered.ID, 10) {
+		t.Errorf("Unexpected delivery log entry %+v", delivered)
+	}
+
+	// адрес, отвечающий ошибкой, получает повторы до исчерпания попыток
+	resp, body = testRequest(t, client, http.MethodPost, ts.URL+"/api/user/balance/withdraw", "application/json",
+		`{"order":"2377225624","sum":40}`)
+	expectStatus(t, resp, body, http.StatusOK)
+	failed := waitDelivery(t, ts, client, failingHook.ID, "FAILED")
+	if failed.Attempts != 3 || failed.ResponseCode != http.StatusServiceUnavailable || failed.LastError == "" {
+		t.Errorf("Unexpected failed delivery %+v", failed)
+	}
+	// баланс в уведомлении — после списания, как его записала база
+	if !strings.Contains(string(failed.Payload), `"balance":{"current":60,"withdrawn":40}`) {
+		t.Errorf("Expected balance after withdrawal in payload; got %s", failed.Payload)
+	}
+
+	// чужой адрес недоступен
+	other := register(t, ts, "other", "secret")
+	resp, body = testRequest(t, other, http.MethodGet,
+		ts.URL+"/api/user/webhooks/"+strconv.FormatInt(hook.ID, 10)+"/deliveries", "", "")
+	expectStatus(t, resp, body, http.StatusNotFound)
+	resp, body = testRequest(t, other, http.MethodDelete, ts.URL+"/api/user/webhooks/"+strconv.FormatInt(hook.ID, 10), "", "")
+	expectStatus(t, resp, body, http.StatusNotFound)
+
+	resp, body = testRequest(t, client, http.MethodDelete, ts.URL+"/api/user/webhooks/"+strconv.FormatInt(hook.ID, 10), "", "")
+	expectStatus(t, resp, body, http.StatusNoContent)
+	resp, body = testRequest(t, client, http.MethodGet,
+		ts.URL+"/api/user/webhooks/"+strconv.FormatInt(hook.ID, 10)+"/deliveries", "", "")
+	expectStatus(t, resp, body, http.StatusNotFound)
+}
+
+// setRole назначает пользователю роль запросом с токеном администратора и возвращает его номер
+func setRole(t *testing.T, ts *testServer, login, role string) int {
+	t.Helper()
+	token := &http.Client{Transport: bearerTransport(adminToken)}
+	resp, body := testRequest(t, token, http.MethodGet, ts.URL+"/api/admin/users?login="+login, "", "")
+	expectStatus(t, resp, body, http.StatusOK)
+	var found []account
+	if err := json.Unmarshal([]byte(body), &found); err != nil {
+		t.Fatalf("unmarshal users: %v", err)
+	}
+	resp, body = testRequest(t, token, http.MethodPut, ts.URL+"/api/admin/users/"+strconv.Itoa(found[0].ID)+"/role", "application/json",
+		fmt.Sprintf(`{"role":%q}`, role))
+	expectStatus(t, resp, body, http.StatusOK)
+	return found[0].ID
+}
+
+type account struct {
+	ID         int        `json:"id"`
+	Login      string     `json:"login"`
+	Role       string     `json:"role"`
+	DisabledAt *time.Time `json:"disabled_at"`
+	Current    float32    `json:"current"`
+	Withdrawn  float32    `json:"withdrawn"`
+}
+
+func TestAdminRBAC(t *testing.T) {
+	ts := newTestServer(t)
+	register(t, ts, "agent", "secret")
+	register(t, ts, "boss", "secret")
+	customer := register(t, ts, "customer", "secret")
+	token := &http.Client{Transport: bearerTransport(adminToken)}
+
+	resp, body := testRequest(t, customer, http.MethodPost, ts.URL+"/api/user/orders", "text/plain", "12345678903")
+	expectStatus(t, resp, body, http.StatusAccepted)
+
+	// обычный пользователь не имеет доступа к административному API
+	resp, body = testRequest(t, customer, http.MethodGet, ts.URL+"/api/admin/users?login=agent", "", "")
+	expectStatus(t, resp, body, http.StatusForbidden)
+	resp, body = testRequest(t, http.DefaultClient, http.MethodGet, ts.URL+"/api/admin/users", "", "")
+	expectStatus(t, resp, body, http.StatusUnauthorized)
+
+	// токен с ролью admin, подписанный чужим ключом, отклоняется
+	forged, err := auth.SignToken([]byte("not-the-server-key"), auth.Claims{UserID: 1, Role: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/admin/users", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&http.Cookie{Name: "session_token", Value: forged})
+	forgedResp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forgedResp.Body.Close()
+	if forgedResp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected forged token to be rejected; got status %d", forgedResp.StatusCode)
+	}
+
+	var found []account
+	resp, body = testRequest(t, token, http.MethodGet, ts.URL+"/api/admin/users?login=AGE", "", "")
+	expectStatus(t, resp, body, http.StatusOK)
+	if err := json.Unmarshal([]byte(body), &found); err != nil {
+		t.Fatalf("unmarshal users: %v", err)
+	}
+	if len(found) != 1 || found[0].Login != "agent" || found[0].Role != "user" {
+		t.Fatalf("Expected to find agent; got %+v", found)
+	}
+	agentID := found[0].ID
+	// символы шаблона LIKE ищутся как обычные
+	resp, body = testRequest(t, token, http.MethodGet, ts.URL+"/api/admin/users?login=%25", "", "")
+	expectStatus(t, resp, body, http.StatusNoContent)
+
+	resp, body = testRequest(t, token, http.MethodPut, ts.URL+"/api/admin/users/"+strconv.Itoa(agentID)+"/role", "application/json", `{"role":"support"}`)
+	expectStatus(t, resp, body, http.StatusOK)
+	resp, body = testRequest(t, token, http.MethodPut, ts.URL+"/api/admin/users/"+strconv.Itoa(agentID)+"/role", "application/json", `{"role":"root"}`)
+	expectStatus(t, resp, body, http.StatusBadRequest)
+	resp, body = testRequest(t, token, http.MethodPut, ts.URL+"/api/admin/users/999/role", "application/json", `{"role":"admin"}`)
+	expectStatus(t, resp, body, http.StatusNotFound)
+
+	agent := login(t, ts, "agent", "secret")
+	resp, body = testRequest(t, agent, http.MethodGet, ts.URL+"/api/admin/users?login=customer", "", "")
+	expectStatus(t, resp, body, http.StatusOK)
+	if err := json.Unmarshal([]byte(body), &found); err != nil {
+		t.Fatalf("unmarshal users: %v", err)
+	}
+	customerID := found[0].ID
+
+	var inspected struct {
+		UserID  int    `json:"user_id"`
+		Number  string `json:"number"`
+		History []struct {
+			Status string `json:"status"`
+		} `json:"history"`
+	}
+	resp, body = testRequest(t, agent, http.MethodGet, ts.URL+"/api/admin/orders/12345678903", "", "")
+	expectStatus(t, resp, body, http.StatusOK)
+	if err := json.Unmarshal([]byte(body), &inspected); err != nil {
+		t.Fatalf("unmarshal order: %v", err)
+	}
+	if inspected.UserID != customerID || inspected.Number != "12345678903" || len(inspected.History) == 0 {
+		t.Errorf("Unexpected order inspection %+v", inspected)
+	}
+	resp, body = testRequest(t, agent, http.MethodGet, ts.URL+"/api/admin/orders/2377225624", "", "")
+	expectStatus(t, resp, body, http.StatusNotFound)
+
+	// поддержка не может изменять учётные записи
+	resp, body = testRequest(t, agent, http.MethodPost, ts.URL+"/api/admin/users/"+strconv.Itoa(customerID)+"/disable", "", "")
+	expectStatus(t, resp, body, http.StatusForbidden)
+
+	setRole(t, ts, "boss", "admin")
+	boss := login(t, ts, "boss", "secret")
+
+	// заблокированный пользователь не может войти, разблокированный — может
+	resp, body = testRequest(t, boss, http.MethodPost, ts.URL+"/api/admin/users/"+strconv.Itoa(customerID)+"/disable", "", "")
+	expectStatus(t, resp, body, http.StatusOK)
+	var disabled account
+	if err := json.Unmarshal([]byte(body), &disabled); err != nil {
+		t.Fatalf("unmarshal account: %v", err)
+	}
+	if disabled.DisabledAt == nil || disabled.Login != "customer" {
+		t.Errorf("Expected disabled customer account; got %+v", disabled)
+	}
+	resp, body = testRequest(t, newClient(t), http.MethodPost, ts.URL+"/api/user/login", "application/json", `{"login":"customer","password":"secret"}`)
+	expectStatus(t, resp, body, http.StatusForbidden)
+	// открытая до блокировки сессия тоже перестаёт действовать
+	resp, body = testRequest(t, customer, http.MethodGet, ts.URL+"/api/user/balance", "", "")
+	expectStatus(t, resp, body, http.StatusForbidden)
+	resp, body = testRequest(t, boss, http.MethodPost, ts.URL+"/api/admin/users/"+strconv.Itoa(customerID)+"/enable", "", "")
+	expectStatus(t, resp, body, http.StatusOK)
+	login(t, ts, "customer", "secret")
+	resp, body = testRequest(t, customer, http.MethodGet, ts.URL+"/api/user/balance", "", "")
+	expectStatus(t, resp, body, http.StatusOK)
+
+	// отозванная роль перестаёт действовать в открытых сессиях сразу
+	resp, body = testRequest(t, boss, http.MethodPut, ts.URL+"/api/admin/users/"+strconv.Itoa(agentID)+"/role", "application/json", `{"role":"user"}`)
+	expectStatus(t, resp, body, http.StatusOK)
+	resp, body = testRequest(t, agent, http.MethodGet, ts.URL+"/api/admin/users?login=customer", "", "")
+	expectStatus(t, resp, body, http.StatusForbidden)
+}
+
+type adjustment struct {
+	ID          int64   `json:"id"`
+	UserID      int     `json:"user_id"`
+	Amount      float32 `json:"amount"`
+	Status      string  `json:"status"`
+	RequestedBy int     `json:"requested_by"`
+	ReviewedBy  int     `json:"reviewed_by"`
+	Balance     *struct {
+		Current float32 `json:"current"`
+	} `json:"balance"`
+}
+
+func TestBalanceAdjustmentApproval(t *testing.T) {
+	ts := newTestServer(t)
+	customer := register(t, ts, "customer", "secret")
+	for _, l := range []string{"agent", "boss", "chief"} {
+		register(t, ts, l, "secret")
+	}
+	customerID := setRole(t, ts, "customer", "user")
+	agentID := setRole(t, ts, "agent", "support")
+	setRole(t, ts, "boss", "admin")
+	chiefID := setRole(t, ts, "chief", "admin")
+	agent := login(t, ts, "agent", "secret")
+	boss := login(t, ts, "boss", "secret")
+	chief := login(t, ts, "chief", "secret")
+	token := &http.Client{Transport: bearerTransport(adminToken)}
+	adjustURL := ts.URL + "/api/admin/users/" + strconv.Itoa(customerID) + "/balance/adjustments"
+
+	request := func(client *http.Client, body string, expected int) adjustment {
+		t.Helper()
+		resp, respBody := testRequest(t, client, http.MethodPost, adjustURL, "application/json", body)
+		expectStatus(t, resp, respBody, expected)
+		var a adjustment
+		if expected == http.StatusCreated {
+			if err := json.Unmarshal([]byte(respBody), &a); err != nil {
+				t.Fatalf("unmarshal adjustment: %v", err)
+			}
+		}
+		return a
+	}
+	review := func(client *http.Client, id int64, action string, expected int) adjustment {
+		t.Helper()
+		resp, respBody := testRequest(t, client, http.MethodPost, ts.URL+"/api/admin/balance/adjustments/"+strconv.FormatInt(id, 10)+"/"+action,
+			"application/json", `{"comment":"checked"}`)
+		expectStatus(t, resp, respBody, expected)
+		var a adjustment
+		if expected == http.StatusOK {
+			if err := json.Unmarshal([]byte(respBody), &a); err != nil {
+				t.Fatalf("unmarshal adjustment: %v", err)
+			}
+		}
+		return a
+	}
+
+	request(agent, `{"amount":100}`, http.StatusBadRequest)
+	request(agent, `{"amount":0,"reason":"nothing"}`, http.StatusBadRequest)
+	request(agent, `{"amount":5000,"reason":"over the support limit"}`, http.StatusForbidden)
+	request(customer, `{"amount":1,"reason":"self service"}`, http.StatusForbidden)
+
+	// пользователь получает уведомления о корректировках баланса
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(receiver.Close)
+	resp, body := testRequest(t, customer, http.MethodPost, ts.URL+"/api/user/webhooks", "application/json",
+		fmt.Sprintf(`{"url":%q,"events":["balance.adjusted"]}`, receiver.URL))
+	expectStatus(t, resp, body, http.StatusCreated)
+	var hook struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.Unmarshal([]byte(body), &hook); err != nil {
+		t.Fatal(err)
+	}
+
+	// запрос поддержки проводится только после согласования администратором
+	goodwill := request(agent, `{"amount":150.5,"reason":"compensation for lost order"}`, http.StatusCreated)
+	if goodwill.Status != "PENDING" || goodwill.RequestedBy != agentID {
+		t.Fatalf("Expected pending adjustment requested by support; got %+v", goodwill)
+	}
+	resp, body = testRequest(t, customer, http.MethodGet, ts.URL+"/api/user/balance", "", "")
+	expectStatus(t, resp, body, http.StatusOK)
+	if !strings.Contains(body, `"current":0`) {
+		t.Errorf("Expected balance unchanged before approval; got %s", body)
+	}
+	review(agent, goodwill.ID, "approve", http.StatusForbidden)
+	review(token, goodwill.ID, "approve", http.StatusForbidden)
+	approved := review(boss, goodwill.ID, "approve", http.StatusOK)
+	if approved.Status != "APPROVED" || approved.Balance == nil || approved.Balance.Current != 150.5 {
+		t.Fatalf("Expected approved adjustment with balance 150.5; got %+v", approved)
+	}
+	review(chief, goodwill.ID, "approve", http.StatusConflict)
+	delivered := waitDelivery(t, ts, customer, hook.ID, "DELIVERED")
+	if !strings.Contains(string(delivered.Payload), `"reason":"compensation for lost order","balance":{"current":150.5,"withdrawn":0}`) {
+		t.Errorf("Expected adjustment webhook with reason and balance; got %s", delivered.Payload)
+	}
+
+	// администратор не согласует собственный запрос
+	correction := request(boss, `{"amount":-50.5,"reason":"duplicate compensation"}`, http.StatusCreated)
+	review(boss, correction.ID, "approve", http.StatusForbidden)
+	if a := review(chief, correction.ID, "approve", http.StatusOK); a.ReviewedBy != chiefID || a.Balance.Current != 100 {
+		t.Fatalf("Expected adjustment approved by chief with balance 100; got %+v", a)
+	}
+
+	// списание больше баланса не проводится и остаётся на согласовании
+	overdraft := request(token, `{"amount":-500,"reason":"chargeback"}`, http.StatusCreated)
+	review(boss, overdraft.ID, "approve", http.StatusConflict)
+	if a := review(boss, overdraft.ID, "reject", http.StatusOK); a.Status != "REJECTED" || a.Balance != nil {
+		t.Fatalf("Expected rejected adjustment without balance; got %+v", a)
+	}
+	review(boss, 999, "approve", http.StatusNotFound)
+
+	var list []adjustment
+	resp, body = testRequest(t, agent, http.MethodGet, ts.URL+"/api/admin/balance/adjustments?status=APPROVED&sort=created_at", "", "")
+	expectStatus(t, resp, body, http.StatusOK)
+	if err := json.Unmarshal([]byte(body), &list); err != nil {
+		t.Fatalf("unmarshal adjustments: %v", err)
+	}
+	if len(list) != 2 || list[0].ID != goodwill.ID || list[1].ID != correction.ID {
+		t.Errorf("Expected two approved adjustments; got %+v", list)
+	}
+	resp, body = testRequest(t, agent, http.MethodGet, ts.URL+"/api/admin/balance/adjustments?status=PENDING", "", "")
+	expectStatus(t, resp, body, http.StatusNoContent)
+	resp, body = testRequest(t, agent, http.MethodGet, ts.URL+"/api/admin/balance/adjustments?status=DONE", "", "")
+	expectStatus(t, resp, body, http.StatusBadRequest)
+
+	// корректировки не попадают в списания пользователя
+	resp, body = testRequest(t, customer, http.MethodGet, ts.URL+"/api/user/balance", "", "")
+	expectStatus(t, resp, body, http.StatusOK)
+	if !strings.Contains(body, `"current":100`) || !strings.Contains(body, `"withdrawn":0`) {
+		t.Errorf("Expected balance 100 with nothing withdrawn; got %s", body)
+	}
+	resp, body = testRequest(t, customer, http.MethodGet, ts.URL+"/api/user/withdrawals", "", "")
+	expectStatus(t, resp, body, http.StatusNoContent)
+}
+
+type auditEntry struct {
+	ID        int64             `json:"id"`
+	ActorID   int               `json:"actor_id"`
+	ActorRole string            `json:"actor_role"`
+	IP        string            `json:"ip"`
+	UserAgent string            `json:"user_agent"`
+	Action    string            `json:"action"`
+	Target    string            `json:"target"`
+	Result    string            `json:"result"`
+	Details   map[string]string `json:"details"`
+	PrevHash  string            `json:"prev_hash"`
+	Hash      string            `json:"hash"`
+}
+
+func TestAuditLog(t *testing.T) {
+	ts := newTestServer(t)
+	alice := register(t, ts, "alice", "secret")
+	register(t, ts, "boss", "secret")
+	register(t, ts, "agent", "secret")
+	aliceID := setRole(t, ts, "alice", "user")
+	bossID := setRole(t, ts, "boss", "admin")
+	setRole(t, ts, "agent", "support")
+	boss := login(t, ts, "boss", "secret")
+	agent := login(t, ts, "agent", "secret")
+
+	resp, body := testRequest(t, newClient(t), http.MethodPost, ts.URL+"/api/user/login", "application/json",
+		`{"login":"alice","password":"guess"}`)
+	expectStatus(t, resp, body, http.StatusUnauthorized)
+	resp, body = testRequest(t, alice, http.MethodPost, ts.URL+"/api/user/balance/withdraw", "application/json",
+		`{"order":"2377225624","sum":10}`)
+	expectStatus(t, resp, body, http.StatusPaymentRequired)
+	resp, body = testRequest(t, boss, http.MethodPost, ts.URL+"/api/admin/users/"+strconv.Itoa(aliceID)+"/disable", "", "")
+	expectStatus(t, resp, body, http.StatusOK)
+	resp, body = testRequest(t, newClient(t), http.MethodPost, ts.URL+"/api/user/login", "application/json",
+		`{"login":"alice","password":"secret"}`)
+	expectStatus(t, resp, body, http.StatusForbidden)
+
+	query := func(params string) []auditEntry {
+		t.Helper()
+		resp, body := testRequest(t, boss, http.MethodGet, ts.URL+"/api/admin/audit?sort=occurred_at&"+params, "", "")
+		if resp.StatusCode == http.StatusNoContent {
+			return nil
+		}
+		expectStatus(t, resp, body, http.StatusOK)
+		var entries []auditEntry
+		if err := json.Unmarshal([]byte(body), &entries); err != nil {
+			t.Fatalf("unmarshal audit: %v", err)
+		}
+		return entries
+	}
+
+	if got := query("target=login:alice"); len(got) != 1 || got[0].Action != "user.login" || got[0].Result != "failure" ||
+		got[0].ActorID != 0 || got[0].IP != "127.0.0.1" || got[0].UserAgent == "" {
+		t.Errorf("Expected one failed login of alice from 127.0.0.1; got %+v", got)
+	}
+	if got := query("target=user:" + strconv.Itoa(aliceID) + "&result=denied"); len(got) != 1 || got[0].Action != "user.login" ||
+		got[0].ActorID != aliceID || got[0].Details["error"] != "account is disabled" {
+		t.Errorf("Expected denied login of disabled alice; got %+v", got)
+	}
+	if got := query("action=balance.withdraw"); len(got) != 1 || got[0].ActorID != aliceID || got[0].Result != "failure" ||
+		got[0].Target != "order:2377225624" || got[0].Details["sum"] != "10" {
+		t.Errorf("Expected failed withdrawal of alice; got %+v", got)
+	}
+	if got := query("actor_id=" + strconv.Itoa(bossID)); len(got) != 3 || got[0].Action != "user.register" ||
+		got[1].Action != "user.login" || got[1].ActorRole != "admin" ||
+		got[2].Action != "admin.user.disable" || got[2].Target != "user:"+strconv.Itoa(aliceID) {
+		t.Errorf("Expected registration, login and disable by boss; got %+v", got)
+	}
+	// роли назначены токеном ADMIN_TOKEN: автор без учётной записи с ролью admin
+	if got := query("action=admin.user.role&actor_id=0"); len(got) != 3 || got[0].ActorRole != "admin" || got[0].Details["role"] != "user" {
+		t.Errorf("Expected three role changes made with the admin token; got %+v", got)
+	}
+	if got := query("action=user.register"); len(got) != 3 || got[0].ActorID != aliceID || got[0].Result != "success" {
+		t.Errorf("Expected three registrations; got %+v", got)
+	}
+	resp, body = testRequest(t, boss, http.MethodGet, ts.URL+"/api/admin/audit?result=maybe", "", "")
+	expectStatus(t, resp, body, http.StatusBadRequest)
+	resp, body = testRequest(t, agent, http.MethodGet, ts.URL+"/api/admin/audit", "", "")
+	expectStatus(t, resp, body, http.StatusForbidden)
+
+	// записи связаны в цепочку хешей
+	all := query("limit=100")
+	for i := 1; i < len(all); i++ {
+		if all[i].PrevHash != all[i-1].Hash {
+			t.Fatalf("Entry %d is not chained to entry %d", all[i].ID, all[i-1].ID)
+		}
+	}
+	resp, body = testRequest(t, boss, http.MethodGet, ts.URL+"/api/admin/audit/verify", "", "")
+	expectStatus(t, resp, body, http.StatusOK)
+	var verification struct {
+		Valid    bool   `json:"valid"`
+		Checked  int    `json:"checked"`
+		LastHash string `json:"last_hash"`
+	}
+	if err := json.Unmarshal([]byte(body), &verification); err != nil {
+		t.Fatalf("unmarshal verification: %v", err)
+	}
+	if !verification.Valid || verification.Checked != len(all) || verification.LastHash != all[len(all)-1].Hash {
+		t.Errorf("Expected valid chain of %d entries; got %s", len(all), body)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	ts := newTestServer(t, func(cfg *config.ServerFlags) {
+		cfg.LoginMaxFailures = 3
+		cfg.LoginIPMaxFailures = 100
+		cfg.LoginLockout = time.Hour
+		cfg.LoginDelayBase = 200 * time.Millisecond
+		cfg.LoginDelayMax = 300 * time.Millisecond
+	})
+	register(t, ts, "alice", "secret")
+	register(t, ts, "bob", "secret")
+	register(t, ts, "boss", "secret")
+	setRole(t, ts, "boss", "admin")
+	boss := login(t, ts, "boss", "secret")
+
+	attempt := func(login, password string, expected int) *http.Response {
+		t.Helper()
+		resp, body := testRequest(t, newClient(t), http.MethodPost, ts.URL+"/api/user/login", "application/json",
+			fmt.Sprintf(`{"login":%q,"password":%q}`, login, password))
+		expectStatus(t, resp, body, expected)
+		return resp
+	}
+
+	// после каждой неудачи вход приостанавливается, пароль в это время не проверяется
+	attempt("alice", "guess", http.StatusUnauthorized)
+	if resp := attempt("alice", "secret", http.StatusTooManyRequests); resp.Header.Get("Retry-After") != "1" {
+		t.Errorf("Expected Retry-After 1; got %q", resp.Header.Get("Retry-After"))
+	}
+	time.Sleep(350 * time.Millisecond)
+	attempt("alice", "guess", http.StatusUnauthorized)
+	attempt("alice", "guess", http.StatusTooManyRequests)
+	time.Sleep(350 * time.Millisecond)
+
+	// третья неудача блокирует логин на login_lockout
+	attempt("alice", "guess", http.StatusUnauthorized)
+	time.Sleep(350 * time.Millisecond)
+	resp := attempt("alice", "secret", http.StatusTooManyRequests)
+	if retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After")); retryAfter < 3500 || retryAfter > 3600 {
+		t.Errorf("Expected Retry-After of about an hour; got %q", resp.Header.Get("Retry-After"))
+	}
+	// пауза адреса истекла, другой пользователь входит
+	attempt("bob", "secret", http.StatusOK)
+
+	resp, body := testRequest(t, boss, http.MethodGet, ts.URL+"/api/admin/lockouts", "", "")
+	expectStatus(t, resp, body, http.StatusOK)
+	var lockouts []struct {
+		Key      string `json:"key"`
+		Failures int    `json:"failures"`
+	}
+	if err := json.Unmarshal([]byte(body), &lockouts); err != nil {
+		t.Fatalf("unmarshal lockouts: %v", err)
+	}
+	if len(lockouts) != 1 || lockouts[0].Key != "login:alice" || lockouts[0].Failures != 3 {
+		t.Errorf("Expected alice locked after 3 failures; got %s", body)
+	}
+
+	resp, body = testRequest(t, boss, http.MethodDelete, ts.URL+"/api/admin/lockouts/login/alice", "", "")
+	expectStatus(t, resp, body, http.StatusNoContent)
+	attempt("alice", "secret", http.StatusOK)
+	resp, body = testRequest(t, boss, http.MethodDelete, ts.URL+"/api/admin/lockouts/login/alice", "", "")
+	expectStatus(t, resp, body, http.StatusNotFound)
+	resp, body = testRequest(t, boss, http.MethodDelete, ts.URL+"/api/admin/lockouts/user/alice", "", "")
+	expectStatus(t, resp, body, http.StatusNotFound)
+	resp, body = testRequest(t, boss, http.MethodGet, ts.URL+"/api/admin/lockouts", "", "")
+	expectStatus(t, resp, body, http.StatusNoContent)
+
+	resp, body = testRequest(t, boss, http.MethodGet, ts.URL+"/api/admin/audit?target=login:alice&result=denied", "", "")
+	expectStatus(t, resp, body, http.StatusOK)
+	if !strings.Contains(body, "too many failed attempts") {
+		t.Errorf("Expected throttled logins in the audit log; got %s", body)
+	}
+	resp, body = testRequest(t, boss, http.MethodGet, ts.URL+"/api/admin/audit?action=admin.lockout.clear", "", "")
+	expectStatus(t, resp, body, http.StatusOK)
+}
+
+func TestLoginLockoutByAddress(t *testing.T) {
+	ts := newTestServer(t, func(cfg *config.ServerFlags) {
+		cfg.LoginIPMaxFailures = 2
+		cfg.LoginLockout = 300 * time.Millisecond
+	})
+	register(t, ts, "bob", "secret")
+	register(t, ts, "boss", "secret")
+	setRole(t, ts, "boss", "admin")
+	boss := login(t, ts, "boss", "secret")
+
+	attempt := func(login, password string, expected int) {
+		t.Helper()
+		resp, body := testRequest(t, newClient(t), http.MethodPost, ts.URL+"/api/user/login", "application/json",
+			fmt.Sprintf(`{"login":%q,"password":%q}`, login, password))
+		expectStatus(t, resp, body, expected)
+	}
+
+	// подбор по разным логинам блокирует адрес клиента
+	attempt("admin", "guess", http.StatusUnauthorized)
+	attempt("root", "guess", http.StatusUnauthorized)
+	attempt("bob", "secret", http.StatusTooManyRequests)
+
+	// блокировка снимается по истечении login_lockout
+	time.Sleep(350 * time.Millisecond)
+	attempt("bob", "secret", http.StatusOK)
+
+	// или администратором
+	attempt("admin", "guess", http.StatusUnauthorized)
+	attempt("root", "guess", http.StatusUnauthorized)
+	attempt("bob", "secret", http.StatusTooManyRequests)
+	resp, body := testRequest(t, boss, http.MethodDelete, ts.URL+"/api/admin/lockouts/ip/127.0.0.1", "", "")
+	expectStatus(t, resp, body, http.StatusNoContent)
+	attempt("bob", "secret", http.StatusOK)
+}
+
+func TestParallelLoginGuesses(t *testing.T) {
+	ts := newTestServer(t, func(cfg *config.ServerFlags) {
+		cfg.LoginMaxFailures = 3
+		cfg.LoginIPMaxFailures = 100
+		cfg.LoginLockout = time.Hour
+	})
+	register(t, ts, "alice", "secret")
+
+	// одновременные попытки не проверяют больше паролей, чем допускает login_max_failures
+	const requests = 20
+	codes := make(chan int, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, _ := testRequest(t, newClient(t), http.MethodPost, ts.URL+"/api/user/login", "application/json",
+				fmt.Sprintf(`{"login":"alice","password":"guess%d"}`, i))
+			codes <- resp.StatusCode
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	counts := map[int]int{}
+	for code := range codes {
+		counts[code]++
+	}
+	if counts[http.StatusUnauthorized] != 3 || counts[http.StatusTooManyRequests] != requests-3 {
+		t.Errorf("Expected 3 checked passwords and %d refusals; got %v", requests-3, counts)
+	}
+}
@@ -0,0 +1,43 @@
+package e2e
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/config"
+	"github.com/beliaevke/go-musthave-diploma/internal/db/migrations"
+	"github.com/beliaevke/go-musthave-diploma/internal/db/postgres"
+	"github.com/beliaevke/go-musthave-diploma/internal/storage"
+	"github.com/beliaevke/go-musthave-diploma/internal/storage/memory"
+)
+
+// testDatabaseURI — переменная окружения с адресом тестовой базы Postgres.
+// Если она задана, тесты работают с Postgres вместо хранилища в памяти;
+// перед каждым тестом схема базы удаляется, поэтому база должна быть отдельной.
+const testDatabaseURI = "TEST_DATABASE_URI"
+
+// newStorage возвращает хранилище для теста: в памяти или в чистой базе Postgres
+func newStorage(t *testing.T, cfg config.ServerFlags) storage.Storage {
+	t.Helper()
+	uri := os.Getenv(testDatabaseURI)
+	if uri == "" {
+		return memory.New(5 * time.Second)
+	}
+
+	ctx := context.Background()
+	cfg.FlagDatabaseURI = uri
+	db, err := postgres.NewDB(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Pool.Close)
+	if _, err = db.Pool.Exec(ctx, "DROP SCHEMA public CASCADE; CREATE SCHEMA public"); err != nil {
+		t.Fatalf("reset schema: %v", err)
+	}
+	if err = migrations.Run(cfg, ctx); err != nil {
+		t.Fatalf("migrations: %v", err)
+	}
+	return storage.NewPostgres(db)
+}
@@ -0,0 +1,317 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/audit"
+	"github.com/beliaevke/go-musthave-diploma/internal/logger"
+	"github.com/beliaevke/go-musthave-diploma/internal/middleware/auth"
+	"github.com/beliaevke/go-musthave-diploma/internal/pagination"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/auditrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/balancerepo"
+
+	"github.com/go-chi/chi"
+)
+
+type adjustments interface {
+	RequestAdjustment(ctx context.Context, a balancerepo.Adjustment, entry balancerepo.AuditEntry) (balancerepo.Adjustment, error)
+	GetAdjustment(ctx context.Context, adjustmentID int64) (balancerepo.Adjustment, error)
+	ListAdjustments(ctx context.Context, f balancerepo.AdjustmentFilter) ([]balancerepo.Adjustment, *pagination.Cursor, error)
+	ReviewAdjustment(ctx context.Context, adjustmentID int64, r balancerepo.Review, entry balancerepo.AuditEntry) (balancerepo.Adjustment, balancerepo.Balance, error)
+	Timeout() time.Duration
+}
+
+// AdjustmentLimits — наибольшая сумма (по модулю) запроса на корректировку баланса для роли;
+// роли без лимита запросы создавать не могут
+type AdjustmentLimits map[string]float32
+
+// maxReasonLength — предельная длина причины корректировки и комментария к решению
+const maxReasonLength = 500
+
+var adjustmentStatuses = []string{balancerepo.AdjustmentPending, balancerepo.AdjustmentApproved, balancerepo.AdjustmentRejected}
+
+// RequestAdjustmentHandler создаёт запрос на зачисление (amount > 0) или списание (amount < 0)
+// баллов: тело запроса {"amount": 100, "reason": "..."}. Баланс изменится после согласования
+// другим администратором (ReviewAdjustmentHandler). 403 — сумма больше лимита роли,
+// 404 — пользователь не найден.
+func RequestAdjustmentHandler(users accounts, balance adjustments, journal audit.Recorder, limits AdjustmentLimits) func(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		requestedBy, err := strconv.Atoi(w.Header().Get("UID"))
+		if err != nil {
+			logger.WarnfCtx(r.Context(), "UID validate error: "+err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		claims, ok := auth.ClaimsFromContext(r.Context())
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		var req struct {
+			Amount float32 `json:"amount"`
+			Reason string  `json:"reason"`
+		}
+		if err = json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<12)).Decode(&req); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		req.Reason = strings.TrimSpace(req.Reason)
+		if req.Amount == 0 {
+			http.Error(w, "amount must be a non-zero number", http.StatusBadRequest)
+			return
+		}
+		if req.Reason == "" || len(req.Reason) > maxReasonLength {
+			http.Error(w, "reason must be set and not longer than "+strconv.Itoa(maxReasonLength)+" characters", http.StatusBadRequest)
+			return
+		}
+		entry := auditrepo.Entry{
+			Action: auditrepo.ActionRequestAdjustment,
+			Target: auditrepo.TargetUser(userID),
+			Result: auditrepo.ResultSuccess,
+			Details: map[string]string{
+				"amount": strconv.FormatFloat(float64(req.Amount), 'f', -1, 32),
+				"reason": req.Reason,
+			},
+		}
+		if limit, ok := limits[claims.Role]; !ok || req.Amount > limit || -req.Amount > limit {
+			entry.Result = auditrepo.ResultDenied
+			entry.Details["error"] = "amount exceeds the adjustment limit"
+			audit.Log(r, journal, entry)
+			http.Error(w, "amount exceeds the adjustment limit for role "+claims.Role, http.StatusForbidden)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), balance.Timeout())
+		defer cancel()
+
+		if _, found, err := users.GetAccount(ctx, userID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		} else if !found {
+			entry.Result = auditrepo.ResultFailure
+			entry.Details["error"] = "user not found"
+			audit.Log(r, journal, entry)
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		adj, err := balance.RequestAdjustment(ctx, balancerepo.Adjustment{
+			UserID:      userID,
+			Amount:      req.Amount,
+			Reason:      req.Reason,
+			RequestedBy: requestedBy,
+			CreatedAt:   time.Now(),
+		}, func(adj balancerepo.Adjustment) auditrepo.Entry {
+			entry.Details["adjustment_id"] = strconv.FormatInt(adj.ID, 10)
+			return audit.Prepare(r, entry)
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		logger.InfofCtx(ctx, "balance adjustment "+strconv.FormatInt(adj.ID, 10)+" for user "+strconv.Itoa(userID)+
+			" requested by "+strconv.Itoa(requestedBy))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		if err = json.NewEncoder(w).Encode(&adj); err != nil {
+			logger.WarnfCtx(r.Context(), "JSON encode error: "+err.Error())
+		}
+	}
+	return fn
+}
+
+// ListAdjustmentsHandler возвращает страницу запросов на корректировку баланса.
+// Параметры status (PENDING, APPROVED, REJECTED) и user_id отбирают запросы,
+// параметры страницы описаны в pagination.Parse (поле сортировки created_at).
+func ListAdjustmentsHandler(repo adjustments) func(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		q := r.URL.Query()
+		page, err := pagination.Parse(q, "created_at")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f := balancerepo.AdjustmentFilter{Status: q.Get("status"), Params: page}
+		if f.Status != "" && !slices.Contains(adjustmentStatuses, f.Status) {
+			http.Error(w, "status must be one of "+strings.Join(adjustmentStatuses, ", "), http.StatusBadRequest)
+			return
+		}
+		if raw := q.Get("user_id"); raw != "" {
+			if f.UserID, err = strconv.Atoi(raw); err != nil || f.UserID < 1 {
+				http.Error(w, "invalid user_id "+strconv.Quote(raw), http.StatusBadRequest)
+				return
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), repo.Timeout())
+		defer cancel()
+
+		list, next, err := repo.ListAdjustments(ctx, f)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(list) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		pagination.SetNext(w, r, next)
+		encoder := json.NewEncoder(w)
+		err = encoder.Encode(&list)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	return fn
+}
+
+// GetAdjustmentHandler возвращает запрос на корректировку баланса; 404 — запрос не найден
+func GetAdjustmentHandler(repo adjustments) func(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		adjustmentID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			http.Error(w, "adjustment not found", http.StatusNotFound)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), repo.Timeout())
+		defer cancel()
+
+		adj, err := repo.GetAdjustment(ctx, adjustmentID)
+		if errors.Is(err, balancerepo.ErrAdjustmentNotFound) {
+			http.Error(w, "adjustment not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err = json.NewEncoder(w).Encode(&adj); err != nil {
+			logger.WarnfCtx(r.Context(), "JSON encode error: "+err.Error())
+		}
+	}
+	return fn
+}
+
+// ReviewAdjustmentHandler согласует (approve = true) или отклоняет запрос на корректировку
+// баланса. Необязательное тело запроса: {"comment": "..."}. Решение принимает администратор
+// с учётной записью, не создававший запрос (403 — иначе). 404 — запрос не найден,
+// 409 — решение уже принято или после корректировки баланс стал бы отрицательным.
+func ReviewAdjustmentHandler(repo adjustments, journal audit.Recorder, approve bool) func(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		reviewedBy, err := strconv.Atoi(w.Header().Get("UID"))
+		if err != nil {
+			logger.WarnfCtx(r.Context(), "UID validate error: "+err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		adjustmentID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			http.Error(w, "adjustment not found", http.StatusNotFound)
+			return
+		}
+		entry := auditrepo.Entry{
+			Action: auditrepo.ActionRejectAdjustment,
+			Target: auditrepo.TargetAdjustment(adjustmentID),
+			Result: auditrepo.ResultSuccess,
+		}
+		if approve {
+			entry.Action = auditrepo.ActionApproveAdjustment
+		}
+		// fail записывает отказ в журнал аудита и отвечает клиенту
+		fail := func(result string, msg string, status int) {
+			entry.Result = result
+			entry.Details = map[string]string{"error": msg}
+			audit.Log(r, journal, entry)
+			http.Error(w, msg, status)
+		}
+		// запрос с токеном ADMIN_TOKEN не указывает на человека, поэтому не может быть второй подписью
+		if reviewedBy == 0 {
+			fail(auditrepo.ResultDenied, "adjustments must be reviewed by an admin account", http.StatusForbidden)
+			return
+		}
+		var req struct {
+			Comment string `json:"comment"`
+		}
+		if r.ContentLength != 0 {
+			err = json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<12)).Decode(&req)
+			if err != nil && !errors.Is(err, io.EOF) {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+		}
+		req.Comment = strings.TrimSpace(req.Comment)
+		if len(req.Comment) > maxReasonLength {
+			http.Error(w, "comment must not be longer than "+strconv.Itoa(maxReasonLength)+" characters", http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), repo.Timeout())
+		defer cancel()
+
+		adj, current, err := repo.ReviewAdjustment(ctx, adjustmentID, balancerepo.Review{
+			Approve:    approve,
+			ReviewedBy: reviewedBy,
+			Comment:    req.Comment,
+			ReviewedAt: time.Now(),
+		}, func(adj balancerepo.Adjustment) auditrepo.Entry {
+			e := entry
+			e.Details = map[string]string{
+				"user_id": strconv.Itoa(adj.UserID),
+				"amount":  strconv.FormatFloat(float64(adj.Amount), 'f', -1, 32),
+			}
+			if adj.ReviewComment != "" {
+				e.Details["comment"] = adj.ReviewComment
+			}
+			return audit.Prepare(r, e)
+		})
+		switch {
+		case err == nil:
+		case errors.Is(err, balancerepo.ErrAdjustmentNotFound):
+			fail(auditrepo.ResultFailure, "adjustment not found", http.StatusNotFound)
+			return
+		case errors.Is(err, balancerepo.ErrSelfReview):
+			fail(auditrepo.ResultDenied, err.Error(), http.StatusForbidden)
+			return
+		case errors.Is(err, balancerepo.ErrAdjustmentReviewed):
+			fail(auditrepo.ResultFailure, err.Error(), http.StatusConflict)
+			return
+		case errors.Is(err, balancerepo.ErrInsufficientFunds):
+			fail(auditrepo.ResultFailure, "balance would become negative", http.StatusConflict)
+			return
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		logger.InfofCtx(ctx, "balance adjustment "+strconv.FormatInt(adj.ID, 10)+" "+strings.ToLower(adj.Status)+
+			" by admin "+strconv.Itoa(reviewedBy))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		resp := struct {
+			balancerepo.Adjustment
+			Balance *balancerepo.Balance `json:"balance,omitempty"`
+		}{Adjustment: adj}
+		if approve {
+			resp.Balance = &current
+		}
+		if err = json.NewEncoder(w).Encode(&resp); err != nil {
+			logger.WarnfCtx(r.Context(), "JSON encode error: "+err.Error())
+		}
+	}
+	return fn
+}
@@ -0,0 +1,89 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/logger"
+	"github.com/beliaevke/go-musthave-diploma/internal/pagination"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/auditrepo"
+)
+
+type auditLog interface {
+	ListEntries(ctx context.Context, f auditrepo.Filter) ([]auditrepo.Entry, *pagination.Cursor, error)
+	Verify(ctx context.Context) (auditrepo.Verification, error)
+	Timeout() time.Duration
+}
+
+// ListAuditHandler возвращает страницу журнала аудита. Параметры actor_id (0 — действия
+// без учётной записи), action, target (например, user:7) и result (success, failure, denied)
+// отбирают записи, параметры страницы описаны в pagination.Parse (поле сортировки occurred_at).
+func ListAuditHandler(repo auditLog) func(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		q := r.URL.Query()
+		page, err := pagination.Parse(q, "occurred_at")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f := auditrepo.Filter{Action: q.Get("action"), Target: q.Get("target"), Result: q.Get("result"), Params: page}
+		if raw := q.Get("actor_id"); raw != "" {
+			actorID, err := strconv.Atoi(raw)
+			if err != nil || actorID < 0 {
+				http.Error(w, "invalid actor_id "+strconv.Quote(raw), http.StatusBadRequest)
+				return
+			}
+			f.ActorID = &actorID
+		}
+		if f.Result != "" && !slices.Contains(auditrepo.Results, f.Result) {
+			http.Error(w, "result must be one of "+strings.Join(auditrepo.Results, ", "), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), repo.Timeout())
+		defer cancel()
+
+		entries, next, err := repo.ListEntries(ctx, f)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(entries) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		pagination.SetNext(w, r, next)
+		encoder := json.NewEncoder(w)
+		err = encoder.Encode(&entries)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	return fn
+}
+
+// VerifyAuditHandler проверяет цепочку хешей журнала аудита от первой записи до последней.
+// Ответ 200 с valid = false и номером первой испорченной записи означает, что журнал изменён.
+func VerifyAuditHandler(repo auditLog) func(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		// проверка читает весь журнал, поэтому ограничена временем запроса, а не хранилища
+		result, err := repo.Verify(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err = json.NewEncoder(w).Encode(&result); err != nil {
+			logger.WarnfCtx(r.Context(), "JSON encode error: "+err.Error())
+		}
+	}
+	return fn
+}
@@ -0,0 +1,96 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/audit"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/auditrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/lockoutrepo"
+
+	"github.com/go-chi/chi"
+)
+
+type lockouts interface {
+	ListLockouts(ctx context.Context, now time.Time) ([]lockoutrepo.Attempts, error)
+	ClearLockout(ctx context.Context, key string) (bool, error)
+	Timeout() time.Duration
+}
+
+// GetLockoutsHandler возвращает логины и адреса клиентов, вход по которым сейчас
+// приостановлен или заблокирован после неудачных попыток
+func GetLockoutsHandler(repo lockouts) func(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		ctx, cancel := context.WithTimeout(r.Context(), repo.Timeout())
+		defer cancel()
+
+		list, err := repo.ListLockouts(ctx, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(list) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		encoder := json.NewEncoder(w)
+		err = encoder.Encode(&list)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	return fn
+}
+
+// ClearLockoutHandler снимает блокировку входа и обнуляет счётчик неудач логина
+// (DELETE /lockouts/login/{value}) или адреса клиента (DELETE /lockouts/ip/{value}).
+// 404 — неудачных входов по логину или с адреса нет.
+func ClearLockoutHandler(repo lockouts, journal audit.Recorder) func(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		// логин может содержать символы, экранированные в пути запроса
+		value, err := url.PathUnescape(chi.URLParam(r, "value"))
+		if err != nil {
+			http.Error(w, "lockout not found", http.StatusNotFound)
+			return
+		}
+		var key string
+		switch chi.URLParam(r, "kind") {
+		case "login":
+			key = lockoutrepo.KeyLogin(value)
+		case "ip":
+			key = lockoutrepo.KeyIP(value)
+		default:
+			http.Error(w, "lockout not found", http.StatusNotFound)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), repo.Timeout())
+		defer cancel()
+
+		found, err := repo.ClearLockout(ctx, key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "lockout not found", http.StatusNotFound)
+			return
+		}
+		err = audit.Write(r, journal, auditrepo.Entry{
+			Action: auditrepo.ActionClearLockout,
+			Target: key,
+			Result: auditrepo.ResultSuccess,
+		})
+		if err != nil {
+			http.Error(w, "audit log is unavailable", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+	return fn
+}
@@ -0,0 +1,49 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/auditrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/lockoutrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/storage/memory"
+
+	"github.com/go-chi/chi"
+)
+
+// brokenJournal не может записать ни одной записи
+type brokenJournal struct{}
+
+func (brokenJournal) Record(ctx context.Context, e auditrepo.Entry) error {
+	return errors.New("connection reset")
+}
+
+func (brokenJournal) Timeout() time.Duration {
+	return time.Second
+}
+
+func TestClearLockoutAuditFailure(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New(time.Second)
+	_, err := store.Lockouts.Attempt(ctx, []lockoutrepo.Limit{
+		{Key: lockoutrepo.KeyLogin("alice"), Policy: lockoutrepo.Policy{MaxFailures: 1, Lockout: time.Hour}},
+	}, func(ctx context.Context) (bool, error) {
+		return false, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := chi.NewRouter()
+	router.Delete("/lockouts/{kind}/{value}", ClearLockoutHandler(store.Lockouts, brokenJournal{}))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/lockouts/login/alice", nil))
+	// действие без записи в журнале не выглядит успешным
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status %d; got %d", http.StatusInternalServerError, rec.Code)
+	}
+}
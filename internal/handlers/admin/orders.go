@@ -0,0 +1,132 @@
+// Package admin — обработчики административного API
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/audit"
+	"github.com/beliaevke/go-musthave-diploma/internal/logger"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/auditrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/ordersrepo"
+
+	"github.com/go-chi/chi"
+)
+
+type orders interface {
+	GetOrder(ctx context.Context, orderNumber string) (int, error)
+	GetOrderDetails(ctx context.Context, orderNumber string) (int, ordersrepo.OrderDetails, error)
+	GetOrderHistory(ctx context.Context, orderNumber string) ([]ordersrepo.HistoryEntry, error)
+	GetFailedOrders(ctx context.Context) ([]ordersrepo.FailedOrder, error)
+	RequeueOrder(ctx context.Context, orderNumber string) (bool, error)
+	Timeout() time.Duration
+}
+
+// GetFailedOrdersHandler возвращает заказы, расчёт по которым прекращён (статус FAILED)
+func GetFailedOrdersHandler(repo orders) func(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), repo.Timeout())
+		defer cancel()
+
+		failed, err := repo.GetFailedOrders(ctx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if failed == nil {
+			failed = []ordersrepo.FailedOrder{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(failed)
+	}
+	return fn
+}
+
+// orderInspection — заказ с владельцем и историей во внутренних статусах (включая FAILED)
+type orderInspection struct {
+	UserID int `json:"user_id"`
+	ordersrepo.OrderDetails
+	History []ordersrepo.HistoryEntry `json:"history"`
+}
+
+// GetOrderHandler возвращает заказ любого пользователя с владельцем и историей
+// изменения статуса без перевода статусов в видимые пользователю; 404 — заказ не найден
+func GetOrderHandler(repo orders) func(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		number := chi.URLParam(r, "number")
+		ctx, cancel := context.WithTimeout(logger.WithOrderNumber(r.Context(), number), repo.Timeout())
+		defer cancel()
+
+		orderUID, order, err := repo.GetOrderDetails(ctx, number)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if orderUID == -1 {
+			http.Error(w, "order not found", http.StatusNotFound)
+			return
+		}
+		history, err := repo.GetOrderHistory(ctx, number)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if history == nil {
+			history = []ordersrepo.HistoryEntry{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(orderInspection{UserID: orderUID, OrderDetails: order, History: history})
+	}
+	return fn
+}
+
+// RequeueOrderHandler возвращает заказ из статуса FAILED на расчёт.
+// 404 — заказ не найден, 409 — заказ не в статусе FAILED.
+func RequeueOrderHandler(repo orders, journal audit.Recorder) func(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		number := chi.URLParam(r, "number")
+		ctx, cancel := context.WithTimeout(logger.WithOrderNumber(r.Context(), number), repo.Timeout())
+		defer cancel()
+
+		requeued, err := repo.RequeueOrder(ctx, number)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		entry := auditrepo.Entry{
+			Action: auditrepo.ActionRequeueOrder,
+			Target: auditrepo.TargetOrder(number),
+			Result: auditrepo.ResultSuccess,
+		}
+		if requeued {
+			logger.InfofCtx(ctx, "order requeued by admin")
+			if err = audit.Write(r, journal, entry); err != nil {
+				http.Error(w, "audit log is unavailable", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		orderUID, err := repo.GetOrder(ctx, number)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		entry.Result = auditrepo.ResultFailure
+		if orderUID == -1 {
+			entry.Details = map[string]string{"error": "order not found"}
+			audit.Log(r, journal, entry)
+			http.Error(w, "order not found", http.StatusNotFound)
+			return
+		}
+		entry.Details = map[string]string{"error": "order is not failed"}
+		audit.Log(r, journal, entry)
+		http.Error(w, "order is not failed", http.StatusConflict)
+	}
+	return fn
+}
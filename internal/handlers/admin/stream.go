@@ -0,0 +1,265 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/logger"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/eventsrepo"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// sendBuffer — число событий, которые могут ждать отправки клиенту.
+	// Клиент, не успевающий их читать, отключается.
+	sendBuffer = 256
+	writeWait  = 10 * time.Second
+	pongWait   = 60 * time.Second
+	pingPeriod = pongWait * 9 / 10
+	// maxMessageSize — предельный размер сообщения клиента (подписки)
+	maxMessageSize = 4096
+)
+
+// adminListener — источник событий панели администратора (LISTEN/NOTIFY)
+type adminListener interface {
+	ListenAdminEvents(ctx context.Context) (<-chan eventsrepo.AdminEvent, error)
+}
+
+// subscription — фильтр событий клиента: пустой список означает «все»
+type subscription struct {
+	Types   []string `json:"types"`
+	UserIDs []int    `json:"user_ids"`
+}
+
+func (s *subscription) validate() error {
+	for _, t := range s.Types {
+		if !slices.Contains(eventsrepo.AdminTypes, t) {
+			return errors.New("unknown event type " + strconv.Quote(t))
+		}
+	}
+	return nil
+}
+
+func (s *subscription) match(e eventsrepo.AdminEvent) bool {
+	return (len(s.Types) == 0 || slices.Contains(s.Types, e.Type)) &&
+		(len(s.UserIDs) == 0 || slices.Contains(s.UserIDs, e.UserID))
+}
+
+type streamClient struct {
+	send    chan eventsrepo.AdminEvent
+	filter  atomic.Pointer[subscription]
+	dropped chan struct{}
+}
+
+// Stream раздаёт события панели администратора подключённым WebSocket-клиентам.
+// На процесс открывается одна подписка на базу; события приходят после фиксации
+// транзакции на любой реплике сервиса. Раздача не ждёт клиентов: у каждого
+// своя очередь, и клиент с переполненной очередью отключается.
+type Stream struct {
+	repo adminListener
+	once sync.Once
+
+	mu      sync.Mutex
+	clients map[*streamClient]struct{}
+}
+
+// NewStream создаёт раздатчик; подписка на базу открывается при первом клиенте
+// и живёт до завершения процесса
+func NewStream(repo adminListener) *Stream {
+	return &Stream{repo: repo, clients: make(map[*streamClient]struct{})}
+}
+
+func (s *Stream) subscribe(filter *subscription) *streamClient {
+	s.once.Do(func() {
+		// первая подписка открывается сразу, чтобы клиент получил события, отправленные после подключения
+		ctx := context.Background()
+		events, err := s.repo.ListenAdminEvents(ctx)
+		go s.run(ctx, events, err)
+	})
+	c := &streamClient{
+		send:    make(chan eventsrepo.AdminEvent, sendBuffer),
+		dropped: make(chan struct{}),
+	}
+	c.filter.Store(filter)
+	s.mu.Lock()
+	s.clients[c] = struct{}{}
+	s.mu.Unlock()
+	return c
+}
+
+func (s *Stream) unsubscribe(c *streamClient) {
+	s.mu.Lock()
+	delete(s.clients, c)
+	s.mu.Unlock()
+}
+
+func (s *Stream) run(ctx context.Context, events <-chan eventsrepo.AdminEvent, err error) {
+	for {
+		if err != nil {
+			logger.WarnfCtx(ctx, "ListenAdminEvents: "+err.Error())
+		} else {
+			for e := range events {
+				s.broadcast(e)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+		events, err = s.repo.ListenAdminEvents(ctx)
+	}
+}
+
+func (s *Stream) broadcast(e eventsrepo.AdminEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.clients {
+		if !c.filter.Load().match(e) {
+			continue
+		}
+		select {
+		case c.send <- e:
+		default:
+			delete(s.clients, c)
+			close(c.dropped)
+		}
+	}
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// streamReply — ответ клиенту на сообщение с подпиской
+type streamReply struct {
+	Type    string   `json:"type"`
+	Types   []string `json:"types,omitempty"`
+	UserIDs []int    `json:"user_ids,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// EventsWebSocketHandler отдаёт по WebSocket события панели администратора:
+// загрузка заказа, завершение расчёта (PROCESSED, INVALID) и списание.
+// Начальный фильтр задаётся параметрами types и user_id (списки через запятую),
+// клиент может заменить его сообщением {"types": [...], "user_ids": [...]}.
+func EventsWebSocketHandler(stream *Stream) func(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseSubscription(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// подписываемся до ответа клиенту, чтобы после подключения он не пропустил событий
+		client := stream.subscribe(filter)
+		defer stream.unsubscribe(client)
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// ответ с ошибкой уже отправлен Upgrade
+			logger.WarnfCtx(r.Context(), "WebSocket upgrade: "+err.Error())
+			return
+		}
+		defer conn.Close()
+
+		replies := make(chan streamReply, 1)
+		closed := make(chan struct{})
+		stop := make(chan struct{})
+		defer close(stop)
+		go readSubscriptions(conn, client, replies, closed, stop)
+
+		ping := time.NewTicker(pingPeriod)
+		defer ping.Stop()
+		for {
+			var msg any
+			select {
+			case <-closed:
+				return
+			case <-client.dropped:
+				conn.SetWriteDeadline(time.Now().Add(writeWait)) //nolint
+				conn.WriteMessage(websocket.CloseMessage,        //nolint
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "slow consumer"))
+				logger.WarnfCtx(r.Context(), "WebSocket client dropped: slow consumer")
+				return
+			case <-ping.C:
+				conn.SetWriteDeadline(time.Now().Add(writeWait)) //nolint
+				if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+					return
+				}
+				continue
+			case e := <-client.send:
+				msg = e
+			case reply := <-replies:
+				msg = reply
+			}
+			conn.SetWriteDeadline(time.Now().Add(writeWait)) //nolint
+			if err := conn.WriteJSON(msg); err != nil {
+				return
+			}
+		}
+	}
+	return fn
+}
+
+// readSubscriptions читает сообщения клиента до закрытия соединения и меняет его фильтр
+func readSubscriptions(conn *websocket.Conn, client *streamClient, replies chan<- streamReply, closed chan<- struct{}, stop <-chan struct{}) {
+	defer close(closed)
+	conn.SetReadLimit(maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(pongWait)) //nolint
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	for {
+		// ошибка чтения в gorilla/websocket окончательна: соединение закрыто или истёк срок
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var (
+			filter subscription
+			reply  streamReply
+		)
+		if err = json.Unmarshal(data, &filter); err == nil {
+			err = filter.validate()
+		}
+		if err != nil {
+			reply = streamReply{Type: "error", Error: "invalid subscription: " + err.Error()}
+		} else {
+			client.filter.Store(&filter)
+			reply = streamReply{Type: "subscribed", Types: filter.Types, UserIDs: filter.UserIDs}
+		}
+		select {
+		case replies <- reply:
+		case <-stop:
+			return
+		}
+	}
+}
+
+func parseSubscription(r *http.Request) (*subscription, error) {
+	q := r.URL.Query()
+	filter := &subscription{}
+	if types := q.Get("types"); types != "" {
+		filter.Types = strings.Split(types, ",")
+	}
+	if ids := q.Get("user_id"); ids != "" {
+		for _, id := range strings.Split(ids, ",") {
+			userID, err := strconv.Atoi(id)
+			if err != nil {
+				return nil, errors.New("invalid user_id " + strconv.Quote(id))
+			}
+			filter.UserIDs = append(filter.UserIDs, userID)
+		}
+	}
+	return filter, filter.validate()
+}
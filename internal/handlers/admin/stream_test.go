@@ -0,0 +1,37 @@
+package admin
+
+import (
+	"testing"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/eventsrepo"
+)
+
+func TestStreamBroadcast(t *testing.T) {
+	stream := NewStream(nil)
+	all := &streamClient{send: make(chan eventsrepo.AdminEvent, 2), dropped: make(chan struct{})}
+	all.filter.Store(&subscription{})
+	withdrawals := &streamClient{send: make(chan eventsrepo.AdminEvent, 2), dropped: make(chan struct{})}
+	withdrawals.filter.Store(&subscription{Types: []string{eventsrepo.AdminWithdrawal}, UserIDs: []int{1}})
+	stream.clients[all] = struct{}{}
+	stream.clients[withdrawals] = struct{}{}
+
+	stream.broadcast(eventsrepo.AdminEvent{Type: eventsrepo.AdminOrderUploaded, UserID: 1})
+	stream.broadcast(eventsrepo.AdminEvent{Type: eventsrepo.AdminWithdrawal, UserID: 2})
+	stream.broadcast(eventsrepo.AdminEvent{Type: eventsrepo.AdminWithdrawal, UserID: 1})
+
+	if len(withdrawals.send) != 1 {
+		t.Errorf("Expected 1 filtered event; got %d", len(withdrawals.send))
+	}
+	// очередь клиента без фильтра переполнена третьим событием: клиент отключается
+	select {
+	case <-all.dropped:
+	default:
+		t.Error("Expected slow client to be dropped")
+	}
+	if _, ok := stream.clients[all]; ok {
+		t.Error("Expected slow client to be removed")
+	}
+	if _, ok := stream.clients[withdrawals]; !ok {
+		t.Error("Expected filtered client to stay subscribed")
+	}
+}
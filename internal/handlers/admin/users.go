@@ -0,0 +1,203 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/audit"
+	"github.com/beliaevke/go-musthave-diploma/internal/logger"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/auditrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/usersrepo"
+
+	"github.com/go-chi/chi"
+)
+
+type accounts interface {
+	GetAccount(ctx context.Context, userID int) (usersrepo.Account, bool, error)
+	SearchUsers(ctx context.Context, login string, limit int) ([]usersrepo.Account, error)
+	SetRole(ctx context.Context, userID int, role string) (bool, error)
+	SetDisabled(ctx context.Context, userID int, disabledAt *time.Time) (bool, error)
+	Timeout() time.Duration
+}
+
+const (
+	// defaultSearchLimit и maxSearchLimit — число пользователей в ответе на поиск
+	defaultSearchLimit = 50
+	maxSearchLimit     = 100
+)
+
+// SearchUsersHandler ищет пользователей по части логина (параметр login, без учёта регистра).
+// Параметр limit ограничивает число пользователей в ответе (по умолчанию 50, не больше 100).
+func SearchUsersHandler(repo accounts) func(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		limit := defaultSearchLimit
+		if raw := r.URL.Query().Get("limit"); raw != "" {
+			var err error
+			limit, err = strconv.Atoi(raw)
+			if err != nil || limit < 1 || limit > maxSearchLimit {
+				http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxSearchLimit), http.StatusBadRequest)
+				return
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), repo.Timeout())
+		defer cancel()
+
+		found, err := repo.SearchUsers(ctx, r.URL.Query().Get("login"), limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(found) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		encoder := json.NewEncoder(w)
+		err = encoder.Encode(&found)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	return fn
+}
+
+// GetUserHandler возвращает учётную запись пользователя с балансом; 404 — пользователь не найден
+func GetUserHandler(repo accounts) func(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), repo.Timeout())
+		defer cancel()
+
+		writeAccount(ctx, w, repo, userID)
+	}
+	return fn
+}
+
+// SetRoleHandler назначает пользователю роль: тело запроса {"role": "support"}.
+// Роль действует со следующего входа пользователя.
+func SetRoleHandler(repo accounts, journal audit.Recorder) func(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		var req struct {
+			Role string `json:"role"`
+		}
+		if err = json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&req); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if !slices.Contains(usersrepo.Roles, req.Role) {
+			http.Error(w, "role must be one of "+strings.Join(usersrepo.Roles, ", "), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), repo.Timeout())
+		defer cancel()
+
+		found, err := repo.SetRole(ctx, userID, req.Role)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		entry := auditrepo.Entry{
+			Action:  auditrepo.ActionSetRole,
+			Target:  auditrepo.TargetUser(userID),
+			Result:  auditrepo.ResultSuccess,
+			Details: map[string]string{"role": req.Role},
+		}
+		if !found {
+			entry.Result = auditrepo.ResultFailure
+			entry.Details["error"] = "user not found"
+			audit.Log(r, journal, entry)
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		if err = audit.Write(r, journal, entry); err != nil {
+			http.Error(w, "audit log is unavailable", http.StatusInternalServerError)
+			return
+		}
+		logger.InfofCtx(ctx, "user "+strconv.Itoa(userID)+" role set to "+req.Role+" by admin "+w.Header().Get("UID"))
+		writeAccount(ctx, w, repo, userID)
+	}
+	return fn
+}
+
+// SetDisabledHandler блокирует (disabled = true) или разблокирует учётную запись.
+// Заблокированный пользователь не может войти; открытая сессия действует до истечения токена.
+func SetDisabledHandler(repo accounts, journal audit.Recorder, disabled bool) func(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), repo.Timeout())
+		defer cancel()
+
+		var disabledAt *time.Time
+		if disabled {
+			now := time.Now()
+			disabledAt = &now
+		}
+		found, err := repo.SetDisabled(ctx, userID, disabledAt)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		entry := auditrepo.Entry{
+			Action: auditrepo.ActionEnable,
+			Target: auditrepo.TargetUser(userID),
+			Result: auditrepo.ResultSuccess,
+		}
+		if disabled {
+			entry.Action = auditrepo.ActionDisable
+		}
+		if !found {
+			entry.Result = auditrepo.ResultFailure
+			entry.Details = map[string]string{"error": "user not found"}
+			audit.Log(r, journal, entry)
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		if err = audit.Write(r, journal, entry); err != nil {
+			http.Error(w, "audit log is unavailable", http.StatusInternalServerError)
+			return
+		}
+		logger.InfofCtx(ctx, "user "+strconv.Itoa(userID)+" disabled="+strconv.FormatBool(disabled)+" by admin "+w.Header().Get("UID"))
+		writeAccount(ctx, w, repo, userID)
+	}
+	return fn
+}
+
+func writeAccount(ctx context.Context, w http.ResponseWriter, repo accounts, userID int) {
+	account, found, err := repo.GetAccount(ctx, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(w).Encode(&account); err != nil {
+		logger.WarnfCtx(ctx, "JSON encode error: "+err.Error())
+	}
+}
@@ -4,12 +4,15 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
 
-	"github.com/beliaevke/go-musthave-diploma/internal/db/postgres"
+	"github.com/beliaevke/go-musthave-diploma/internal/audit"
 	"github.com/beliaevke/go-musthave-diploma/internal/logger"
+	"github.com/beliaevke/go-musthave-diploma/internal/pagination"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/auditrepo"
 	"github.com/beliaevke/go-musthave-diploma/internal/repository/balancerepo"
 
 	"github.com/ShiraazMoollatjie/goluhn"
@@ -18,21 +21,17 @@ import (
 
 type database interface {
 	GetBalance(ctx context.Context, userID int) (balancerepo.Balance, error)
-	BalanceWithdraw(ctx context.Context, userID int, userBalance balancerepo.Balance, withdraw balancerepo.Withdraw) error
-	GetWithdrawals(ctx context.Context, userID int) ([]balancerepo.Withdrawals, error)
+	BalanceWithdraw(ctx context.Context, userID int, withdraw balancerepo.Withdraw, entry auditrepo.Entry) (balancerepo.Balance, error)
+	ListWithdrawals(ctx context.Context, userID int, p pagination.Params) ([]balancerepo.Withdrawals, *pagination.Cursor, error)
 	Timeout() time.Duration
 }
 
-func NewRepo(db *postgres.DB) database {
-	return balancerepo.NewBalance(db)
-}
-
 func GetBalanceHandler(repo database) func(w http.ResponseWriter, r *http.Request) {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		userID, err := strconv.Atoi(w.Header().Get("UID"))
 		if err != nil {
-			logger.Warnf("UID validate error: " + err.Error())
+			logger.WarnfCtx(r.Context(), "UID validate error: "+err.Error())
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
@@ -56,12 +55,14 @@ func GetBalanceHandler(repo database) func(w http.ResponseWriter, r *http.Reques
 	return fn
 }
 
-func PostBalanceWithdrawHandler(repo database) func(w http.ResponseWriter, r *http.Request) {
+// PostBalanceWithdrawHandler списывает баллы в счёт заказа. Списание записывается в журнал
+// аудита в одной транзакции с изменением баланса, отказ из-за нехватки баллов — после него.
+func PostBalanceWithdrawHandler(repo database, journal audit.Recorder) func(w http.ResponseWriter, r *http.Request) {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		userID, err := strconv.Atoi(w.Header().Get("UID"))
 		if err != nil {
-			logger.Warnf("UID validate error: " + err.Error())
+			logger.WarnfCtx(r.Context(), "UID validate error: "+err.Error())
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
@@ -85,33 +86,41 @@ func PostBalanceWithdrawHandler(repo database) func(w http.ResponseWriter, r *ht
 			retry.Delay(1000*time.Millisecond),
 		)
 		if err != nil {
-			logger.Warnf("JSON error: " + err.Error())
+			logger.WarnfCtx(r.Context(), "JSON error: "+err.Error())
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		ctx, cancel := context.WithTimeout(r.Context(), repo.Timeout())
-		defer cancel()
-
 		err = goluhn.Validate(withdraw.OrderNumber)
 		if err != nil {
-			logger.Infof("goluhn validate error: " + err.Error() + " - " + withdraw.OrderNumber)
+			logger.InfofCtx(r.Context(), "goluhn validate error: "+err.Error()+" - "+withdraw.OrderNumber)
 			http.Error(w, "incorrect order number", http.StatusUnprocessableEntity)
 			return
 		}
-
-		userBalance, err := repo.GetBalance(ctx, userID)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		// отрицательная сумма при списании начислила бы баллы
+		if withdraw.Sum <= 0 {
+			http.Error(w, "sum must be positive", http.StatusBadRequest)
 			return
 		}
-		if userBalance.PointsSum < withdraw.Sum {
+
+		ctx, cancel := context.WithTimeout(r.Context(), repo.Timeout())
+		defer cancel()
+
+		entry := auditrepo.Entry{
+			Action:  auditrepo.ActionWithdraw,
+			Target:  auditrepo.TargetOrder(withdraw.OrderNumber),
+			Result:  auditrepo.ResultSuccess,
+			Details: map[string]string{"sum": strconv.FormatFloat(float64(withdraw.Sum), 'f', -1, 32)},
+		}
+		_, err = repo.BalanceWithdraw(ctx, userID, withdraw, audit.Prepare(r, entry))
+		switch {
+		case errors.Is(err, balancerepo.ErrInsufficientFunds):
+			entry.Result = auditrepo.ResultFailure
+			entry.Details["error"] = "insufficient funds"
+			audit.Log(r, journal, entry)
 			http.Error(w, "there are insufficient funds in the account", http.StatusPaymentRequired)
 			return
-		}
-
-		err = repo.BalanceWithdraw(ctx, userID, userBalance, withdraw)
-		if err != nil {
+		case err != nil:
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -120,12 +129,19 @@ func PostBalanceWithdrawHandler(repo database) func(w http.ResponseWriter, r *ht
 	return fn
 }
 
+// GetWithdrawalsHandler возвращает страницу списаний пользователя.
+// Параметры страницы описаны в pagination.Parse (поле сортировки processed_at).
 func GetWithdrawalsHandler(repo database) func(w http.ResponseWriter, r *http.Request) {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		userID, err := strconv.Atoi(w.Header().Get("UID"))
 		if err != nil {
-			logger.Warnf("UID validate error: " + err.Error())
+			logger.WarnfCtx(r.Context(), "UID validate error: "+err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		page, err := pagination.Parse(r.URL.Query(), "processed_at")
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
@@ -133,7 +149,7 @@ func GetWithdrawalsHandler(repo database) func(w http.ResponseWriter, r *http.Re
 		ctx, cancel := context.WithTimeout(r.Context(), repo.Timeout())
 		defer cancel()
 
-		withdrawals, err := repo.GetWithdrawals(ctx, userID)
+		withdrawals, next, err := repo.ListWithdrawals(ctx, userID, page)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -142,6 +158,7 @@ func GetWithdrawalsHandler(repo database) func(w http.ResponseWriter, r *http.Re
 			w.WriteHeader(http.StatusNoContent)
 			return
 		}
+		pagination.SetNext(w, r, next)
 		encoder := json.NewEncoder(w)
 		err = encoder.Encode(&withdrawals)
 		if err != nil {
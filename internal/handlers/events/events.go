@@ -0,0 +1,117 @@
+// Package events — поток событий пользователя (Server-Sent Events)
+package events
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/logger"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/eventsrepo"
+)
+
+// Heartbeat — период комментариев, которые не дают прокси закрыть простаивающее соединение.
+// По нему же перечитываются события на случай потерянного уведомления.
+const Heartbeat = 15 * time.Second
+
+// batchSize — число событий, читаемых из базы за один запрос
+const batchSize = 100
+
+type database interface {
+	ListEvents(ctx context.Context, userID int, afterID int64, limit int) ([]eventsrepo.Event, error)
+	LastEventID(ctx context.Context, userID int) (int64, error)
+	Timeout() time.Duration
+}
+
+// GetEventsHandler отдаёт поток событий пользователя: смена статуса заказа (event: order)
+// и изменение баланса (event: balance). С заголовком Last-Event-ID поток начинается
+// с событий после указанного, без него — с новых событий.
+func GetEventsHandler(repo database, hub *Hub) func(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+
+		userID, err := strconv.Atoi(w.Header().Get("UID"))
+		if err != nil {
+			logger.WarnfCtx(r.Context(), "UID validate error: "+err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var lastID int64
+		lastEventID := r.Header.Get("Last-Event-ID")
+		if lastEventID != "" {
+			lastID, err = strconv.ParseInt(lastEventID, 10, 64)
+			if err != nil || lastID < 0 {
+				http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+				return
+			}
+		}
+
+		// подписываемся до чтения базы, чтобы не пропустить события между чтением и подпиской
+		signals, unsubscribe := hub.Subscribe(userID)
+		defer unsubscribe()
+
+		if lastEventID == "" {
+			ctx, cancel := context.WithTimeout(r.Context(), repo.Timeout())
+			lastID, err = repo.LastEventID(ctx, userID)
+			cancel()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		rc := http.NewResponseController(w)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		if err = rc.Flush(); err != nil {
+			logger.WarnfCtx(r.Context(), "SSE flush: "+err.Error())
+			return
+		}
+
+		heartbeat := time.NewTicker(Heartbeat)
+		defer heartbeat.Stop()
+		for {
+			if lastID, err = sendEvents(r.Context(), w, repo, userID, lastID); err != nil {
+				return
+			}
+			if err = rc.Flush(); err != nil {
+				return
+			}
+			select {
+			case <-r.Context().Done():
+				return
+			case <-signals:
+			case <-heartbeat.C:
+				if _, err = fmt.Fprint(w, ": ping\n\n"); err != nil {
+					return
+				}
+			}
+		}
+	}
+	return fn
+}
+
+// sendEvents пишет в поток события пользователя после lastID и возвращает номер последнего отправленного
+func sendEvents(ctx context.Context, w http.ResponseWriter, repo database, userID int, lastID int64) (int64, error) {
+	for {
+		qctx, cancel := context.WithTimeout(ctx, repo.Timeout())
+		events, err := repo.ListEvents(qctx, userID, lastID, batchSize)
+		cancel()
+		if err != nil {
+			return lastID, err
+		}
+		for _, e := range events {
+			if _, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, e.Data); err != nil {
+				return lastID, err
+			}
+			lastID = e.ID
+		}
+		if len(events) < batchSize {
+			return lastID, nil
+		}
+	}
+}
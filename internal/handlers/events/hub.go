@@ -0,0 +1,87 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/logger"
+)
+
+// listener — источник номеров пользователей с новыми событиями (LISTEN/NOTIFY)
+type listener interface {
+	ListenEvents(ctx context.Context) (<-chan int, error)
+}
+
+// Hub раздаёт уведомления о новых событиях потокам пользователей процесса.
+// На процесс открывается одна подписка на базу; уведомления приходят после
+// фиксации транзакции на любой реплике сервиса.
+type Hub struct {
+	repo listener
+	once sync.Once
+
+	mu   sync.Mutex
+	subs map[int]map[chan struct{}]struct{}
+}
+
+// NewHub создаёт раздатчик; подписка на базу открывается при первом потоке
+// и живёт до завершения процесса
+func NewHub(repo listener) *Hub {
+	return &Hub{repo: repo, subs: make(map[int]map[chan struct{}]struct{})}
+}
+
+// Subscribe возвращает канал сигналов о новых событиях пользователя и функцию отписки.
+// Сигналы не копятся: пока поток не прочитал предыдущий, новые сливаются с ним,
+// поэтому медленный поток не задерживает остальных.
+func (h *Hub) Subscribe(userID int) (<-chan struct{}, func()) {
+	h.once.Do(func() {
+		// первая подписка открывается сразу, чтобы поток не пропустил уведомлений после подключения
+		ctx := context.Background()
+		users, err := h.repo.ListenEvents(ctx)
+		go h.run(ctx, users, err)
+	})
+	ch := make(chan struct{}, 1)
+	h.mu.Lock()
+	if h.subs[userID] == nil {
+		h.subs[userID] = make(map[chan struct{}]struct{})
+	}
+	h.subs[userID][ch] = struct{}{}
+	h.mu.Unlock()
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subs[userID], ch)
+		if len(h.subs[userID]) == 0 {
+			delete(h.subs, userID)
+		}
+		h.mu.Unlock()
+	}
+}
+
+func (h *Hub) run(ctx context.Context, users <-chan int, err error) {
+	for {
+		if err != nil {
+			logger.WarnfCtx(ctx, "ListenEvents: "+err.Error())
+		} else {
+			for userID := range users {
+				h.signal(userID)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+		users, err = h.repo.ListenEvents(ctx)
+	}
+}
+
+func (h *Hub) signal(userID int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs[userID] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
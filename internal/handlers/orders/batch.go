@@ -0,0 +1,181 @@
+package orders
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/logger"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/ordersrepo"
+
+	"github.com/ShiraazMoollatjie/goluhn"
+)
+
+const (
+	// MaxBatchSize — наибольшее число номеров в одном запросе пакетной загрузки
+	MaxBatchSize = 1000
+	// maxBatchBody — наибольший размер тела запроса пакетной загрузки
+	maxBatchBody = 1 << 20
+)
+
+type batchStore interface {
+	AddOrders(ctx context.Context, userID int, numbers []string) (map[string]string, error)
+	Timeout() time.Duration
+}
+
+var errUnsupportedType = errors.New("unsupported Content-Type, expected application/json, text/plain or text/csv")
+
+// BatchItem — результат загрузки одного номера заказа
+type BatchItem struct {
+	Number string `json:"number"`
+	Result string `json:"result"`
+}
+
+// PostOrdersBatchHandler загружает несколько заказов одним запросом.
+// Тело запроса — JSON-массив номеров (application/json), номера по одному
+// на строке (text/plain) или CSV с номером в первом столбце (text/csv).
+// Ответ — результат по каждому номеру в порядке запроса: accepted, already_yours,
+// conflict или invalid. Повтор номера в запросе получает результат already_yours.
+func PostOrdersBatchHandler(repo batchStore) func(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+
+		userID, err := strconv.Atoi(w.Header().Get("UID"))
+		if err != nil {
+			logger.WarnfCtx(r.Context(), "UID validate error: "+err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBatchBody))
+		if err != nil {
+			http.Error(w, "request body is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		numbers, err := parseBatch(r.Header.Get("Content-Type"), body)
+		if errors.Is(err, errUnsupportedType) {
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(numbers) == 0 {
+			http.Error(w, "no order numbers", http.StatusBadRequest)
+			return
+		}
+		if len(numbers) > MaxBatchSize {
+			http.Error(w, "too many order numbers, at most "+strconv.Itoa(MaxBatchSize)+" allowed", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		items := make([]BatchItem, len(numbers))
+		seen := make(map[string]bool, len(numbers))
+		var valid []string
+		for i, number := range numbers {
+			items[i].Number = number
+			switch {
+			case goluhn.Validate(number) != nil:
+				items[i].Result = ordersrepo.BatchInvalid
+			case !seen[number]:
+				seen[number] = true
+				valid = append(valid, number)
+			}
+		}
+
+		results := map[string]string{}
+		if len(valid) > 0 {
+			ctx, cancel := context.WithTimeout(r.Context(), repo.Timeout())
+			defer cancel()
+			results, err = repo.AddOrders(ctx, userID, valid)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		reported := make(map[string]bool, len(valid))
+		for i := range items {
+			if items[i].Result != "" {
+				continue
+			}
+			number := items[i].Number
+			items[i].Result = results[number]
+			if reported[number] && items[i].Result != ordersrepo.BatchConflict {
+				// повтор номера в запросе: первый экземпляр уже загружен этим пользователем
+				items[i].Result = ordersrepo.BatchAlreadyYours
+			}
+			reported[number] = true
+		}
+
+		logger.InfofCtx(r.Context(), "orders batch: "+strconv.Itoa(len(items))+" numbers, "+strconv.Itoa(len(valid))+" valid")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(items); err != nil {
+			logger.WarnfCtx(r.Context(), "JSON encode error: "+err.Error())
+		}
+	}
+	return fn
+}
+
+// parseBatch разбирает номера заказов из тела запроса по его типу
+func parseBatch(contentType string, body []byte) ([]string, error) {
+	mediaType := "text/plain"
+	if contentType != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(contentType); err != nil {
+			return nil, errors.New("malformed Content-Type")
+		}
+	}
+
+	var numbers []string
+	switch mediaType {
+	case "application/json":
+		if err := json.Unmarshal(body, &numbers); err != nil {
+			return nil, errors.New("body must be a JSON array of order numbers")
+		}
+		for i := range numbers {
+			numbers[i] = strings.TrimSpace(numbers[i])
+		}
+	case "text/plain":
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				numbers = append(numbers, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	case "text/csv":
+		reader := csv.NewReader(bytes.NewReader(body))
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		records, err := reader.ReadAll()
+		if err != nil {
+			return nil, errors.New("malformed CSV: " + err.Error())
+		}
+		for i, record := range records {
+			number := strings.TrimSpace(record[0])
+			// первая строка без цифр — заголовок
+			if i == 0 && strings.IndexFunc(number, func(r rune) bool { return r >= '0' && r <= '9' }) < 0 {
+				continue
+			}
+			if number != "" {
+				numbers = append(numbers, number)
+			}
+		}
+	default:
+		return nil, errUnsupportedType
+	}
+	return numbers, nil
+}
@@ -0,0 +1,142 @@
+package orders
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/accrual"
+	"github.com/beliaevke/go-musthave-diploma/internal/logger"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/ordersrepo"
+)
+
+// Заголовки уведомления системы начислений
+const (
+	TimestampHeader = "X-Accrual-Timestamp"
+	SignatureHeader = "X-Accrual-Signature"
+)
+
+type callbacks interface {
+	RememberCallback(ctx context.Context, signature string, receivedAt time.Time, expireBefore time.Time) (bool, error)
+	ForgetCallback(ctx context.Context, signature string) error
+	Timeout() time.Duration
+}
+
+// Sign возвращает подпись уведомления: HMAC-SHA256 от "<timestamp>.<тело запроса>"
+// в виде "sha256=<hex>"
+func Sign(secret []byte, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10))) //nolint
+	mac.Write([]byte("."))                              //nolint
+	mac.Write(body)                                     //nolint
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// AccrualCallbackHandler принимает уведомления системы начислений об изменении статуса заказа.
+// Уведомление должно быть подписано общим секретом (заголовки X-Accrual-Timestamp
+// и X-Accrual-Signature) и отправлено не раньше чем tolerance назад; повторно
+// присланное уведомление с той же подписью отклоняется. Если уведомление не удалось
+// применить (500), его подпись забывается, и повтор уведомления будет принят.
+func AccrualCallbackHandler(repo worker, seen callbacks, secret []byte, tolerance time.Duration) func(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		// читаем тело запроса
+		n, err := buf.ReadFrom(http.MaxBytesReader(w, r.Body, 1<<16))
+		if err != nil || n == 0 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		timestamp, err := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid timestamp", http.StatusUnauthorized)
+			return
+		}
+		now := time.Now()
+		sentAt := time.Unix(timestamp, 0)
+		if sentAt.Before(now.Add(-tolerance)) || sentAt.After(now.Add(tolerance)) {
+			http.Error(w, "timestamp is outside of the allowed window", http.StatusUnauthorized)
+			return
+		}
+		signature := strings.TrimSpace(r.Header.Get(SignatureHeader))
+		if !hmac.Equal([]byte(signature), []byte(Sign(secret, timestamp, buf.Bytes()))) {
+			logger.WarnfCtx(r.Context(), "accrual callback: invalid signature")
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		var respBody struct {
+			Order   string  `json:"order"`
+			Status  string  `json:"status"`
+			Accrual float32 `json:"accrual"`
+		}
+		if err = json.Unmarshal(buf.Bytes(), &respBody); err != nil || respBody.Order == "" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		status, err := accrual.ParseStatus(respBody.Status)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(logger.WithOrderNumber(r.Context(), respBody.Order), seen.Timeout())
+		defer cancel()
+
+		key := strings.TrimPrefix(signature, "sha256=")
+		replayed, err := seen.RememberCallback(ctx, key, now, now.Add(-2*tolerance))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if replayed {
+			logger.WarnfCtx(ctx, "accrual callback: replayed notification")
+			http.Error(w, "notification has already been received", http.StatusConflict)
+			return
+		}
+
+		// ошибка применения не должна оставлять уведомление принятым
+		fail := func(err error) {
+			forgetCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), seen.Timeout())
+			defer cancel()
+			if forgetErr := seen.ForgetCallback(forgetCtx, key); forgetErr != nil {
+				logger.WarnfCtx(ctx, "accrual callback: "+forgetErr.Error())
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+
+		orderUID, err := repo.GetOrder(ctx, respBody.Order)
+		if err != nil {
+			fail(err)
+			return
+		}
+		if orderUID == -1 {
+			http.Error(w, "order not found", http.StatusNotFound)
+			return
+		}
+
+		result := accrual.Result{Order: respBody.Order, Status: status, Raw: buf.Bytes()}
+		if status == accrual.Processed {
+			result.Accrual = respBody.Accrual
+		}
+		err = applyAccrualResult(ctx, repo, orderUID, ordersrepo.Order{OrderNumber: respBody.Order}, result, ordersrepo.SourceWebhook)
+		if errors.Is(err, ordersrepo.ErrIllegalTransition) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			fail(err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	return fn
+}
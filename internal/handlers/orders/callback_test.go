@@ -0,0 +1,66 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/ordersrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/usersrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/storage/memory"
+)
+
+// flakyOrders отказывает в первом изменении заказа
+type flakyOrders struct {
+	worker
+	failed bool
+}
+
+func (f *flakyOrders) UpdateOrder(ctx context.Context, orderUID int, order ordersrepo.Order, source string, response string) error {
+	if !f.failed {
+		f.failed = true
+		return errors.New("connection reset")
+	}
+	return f.worker.UpdateOrder(ctx, orderUID, order, source, response)
+}
+
+func TestAccrualCallbackRetryAfterFailure(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New(time.Second)
+	userID, err := store.Users.CreateUser(ctx, usersrepo.UserInfo{UserLogin: "user", UserPassword: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Orders.AddOrder(ctx, userID, "12345678903"); err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("callback-secret")
+	handler := AccrualCallbackHandler(&flakyOrders{worker: store.Orders}, store.Callbacks, secret, time.Minute)
+
+	body := `{"order":"12345678903","status":"PROCESSED","accrual":100}`
+	timestamp := time.Now().Unix()
+	send := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/api/accrual/callback", strings.NewReader(body))
+		req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+		req.Header.Set(SignatureHeader, Sign(secret, timestamp, []byte(body)))
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w.Code
+	}
+
+	// уведомление, которое не удалось применить, принимается при повторе
+	for _, expected := range []int{http.StatusInternalServerError, http.StatusOK, http.StatusConflict} {
+		if got := send(); got != expected {
+			t.Fatalf("Expected status %d; got %d", expected, got)
+		}
+	}
+	balance, err := store.Balance.GetBalance(ctx, userID)
+	if err != nil || balance.PointsSum != 100 {
+		t.Errorf("Expected accrual of 100 applied once; got %+v, %v", balance, err)
+	}
+}
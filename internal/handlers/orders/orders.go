@@ -4,43 +4,63 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"fmt"
-	"io"
+	"errors"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
-	"github.com/beliaevke/go-musthave-diploma/internal/db/postgres"
+	"github.com/beliaevke/go-musthave-diploma/internal/accrual"
 	"github.com/beliaevke/go-musthave-diploma/internal/logger"
+	"github.com/beliaevke/go-musthave-diploma/internal/middleware/requestid"
+	"github.com/beliaevke/go-musthave-diploma/internal/pagination"
 	"github.com/beliaevke/go-musthave-diploma/internal/repository/ordersrepo"
 
 	"github.com/ShiraazMoollatjie/goluhn"
+	"github.com/go-chi/chi"
 )
 
 type database interface {
 	AddOrder(ctx context.Context, userID int, orderNumber string) error
 	GetOrder(ctx context.Context, orderNumber string) (int, error)
-	GetOrders(ctx context.Context, userID int) ([]ordersrepo.Order, error)
+	ListOrders(ctx context.Context, userID int, f ordersrepo.ListFilter) ([]ordersrepo.Order, *pagination.Cursor, error)
+	GetOrderDetails(ctx context.Context, orderNumber string) (int, ordersrepo.OrderDetails, error)
+	GetOrderHistory(ctx context.Context, orderNumber string) ([]ordersrepo.HistoryEntry, error)
 	Timeout() time.Duration
 }
 
-func NewRepo(db *postgres.DB) database {
-	return ordersrepo.NewOrder(db)
+// worker — хранилище заказов, используемое при расчёте начислений
+type worker interface {
+	GetOrder(ctx context.Context, orderNumber string) (int, error)
+	GetAwaitOrders(ctx context.Context) ([]ordersrepo.Order, error)
+	UpdateOrder(ctx context.Context, orderUID int, order ordersrepo.Order, source string, response string) error
+	RetryOrder(ctx context.Context, orderNumber string, attempts int, nextAttemptAt time.Time, lastError string) error
+	FailOrder(ctx context.Context, orderNumber string, attempts int, lastError string) error
+	ListenNewOrders(ctx context.Context) (<-chan string, error)
 }
 
+// GetOrdersHandler возвращает страницу заказов пользователя.
+// Параметры страницы описаны в pagination.Parse (поле сортировки uploaded_at),
+// status — список статусов через запятую.
 func GetOrdersHandler(repo database) func(w http.ResponseWriter, r *http.Request) {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 
 		userID, err := strconv.Atoi(w.Header().Get("UID"))
 		if err != nil {
-			logger.Warnf("UID validate error: " + err.Error())
+			logger.WarnfCtx(r.Context(), "UID validate error: "+err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter, err := parseListFilter(r.URL.Query())
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 		ctx, cancel := context.WithTimeout(r.Context(), repo.Timeout())
 		defer cancel()
 
-		orders, err := repo.GetOrders(ctx, userID)
+		orders, next, err := repo.ListOrders(ctx, userID, filter)
 		if err != nil {
 			//http.Error(w, err.Error(), http.StatusInternalServerError)
 			w.Header().Set("Content-Type", "application/json")
@@ -52,6 +72,10 @@ func GetOrdersHandler(repo database) func(w http.ResponseWriter, r *http.Request
 			http.Error(w, "orders not found", http.StatusNoContent) // w.WriteHeader(http.StatusNoContent)
 			return
 		}
+		for i := range orders {
+			orders[i].OrderStatus = orders[i].OrderStatus.UserVisible()
+		}
+		pagination.SetNext(w, r, next)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(orders)
@@ -59,12 +83,93 @@ func GetOrdersHandler(repo database) func(w http.ResponseWriter, r *http.Request
 	return fn
 }
 
+// parseListFilter разбирает параметры списка заказов.
+// Пользователь видит приостановленные заказы (FAILED) в статусе PROCESSING,
+// поэтому фильтр по PROCESSING включает и их.
+func parseListFilter(q url.Values) (ordersrepo.ListFilter, error) {
+	var (
+		f   ordersrepo.ListFilter
+		err error
+	)
+	f.Params, err = pagination.Parse(q, "uploaded_at")
+	if err != nil {
+		return f, err
+	}
+	if v := q.Get("status"); v != "" {
+		for _, s := range strings.Split(v, ",") {
+			status := ordersrepo.OrderStatus(strings.ToUpper(strings.TrimSpace(s)))
+			if !status.Valid() || status != status.UserVisible() {
+				return f, errors.New("unknown order status " + s)
+			}
+			f.Statuses = append(f.Statuses, status)
+			if status == ordersrepo.StatusProcessing {
+				f.Statuses = append(f.Statuses, ordersrepo.StatusFailed)
+			}
+		}
+	}
+	return f, nil
+}
+
+// orderTimeline — заказ со временем последней проверки и историей изменения статуса
+type orderTimeline struct {
+	ordersrepo.OrderDetails
+	History []ordersrepo.HistoryEntry `json:"history"`
+}
+
+// GetOrderHandler возвращает заказ пользователя с историей изменения статуса.
+// 404 — заказ не найден, 403 — заказ загружен другим пользователем.
+func GetOrderHandler(repo database) func(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+
+		userID, err := strconv.Atoi(w.Header().Get("UID"))
+		if err != nil {
+			logger.WarnfCtx(r.Context(), "UID validate error: "+err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		number := chi.URLParam(r, "number")
+		ctx, cancel := context.WithTimeout(logger.WithOrderNumber(r.Context(), number), repo.Timeout())
+		defer cancel()
+
+		orderUID, order, err := repo.GetOrderDetails(ctx, number)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if orderUID == -1 {
+			http.Error(w, "order not found", http.StatusNotFound)
+			return
+		}
+		if orderUID != userID {
+			http.Error(w, "order belongs to another user", http.StatusForbidden)
+			return
+		}
+		history, err := repo.GetOrderHistory(ctx, number)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		order.OrderStatus = order.OrderStatus.UserVisible()
+		for i := range history {
+			history[i].Status = history[i].Status.UserVisible()
+		}
+		if history == nil {
+			history = []ordersrepo.HistoryEntry{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(orderTimeline{OrderDetails: order, History: history})
+	}
+	return fn
+}
+
 func PostOrdersHandler(repo database) func(w http.ResponseWriter, r *http.Request) {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 
 		userID, err := strconv.Atoi(w.Header().Get("UID"))
 		if err != nil {
-			logger.Warnf("UID validate error: " + err.Error())
+			logger.WarnfCtx(r.Context(), "UID validate error: "+err.Error())
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
@@ -80,7 +185,7 @@ func PostOrdersHandler(repo database) func(w http.ResponseWriter, r *http.Reques
 		responseString := buf.String()
 		err = goluhn.Validate(responseString)
 		if err != nil {
-			logger.Infof("goluhn validate error: " + err.Error() + " - " + responseString)
+			logger.InfofCtx(r.Context(), "goluhn validate error: "+err.Error()+" - "+responseString)
 			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
 			return
 		}
@@ -111,94 +216,118 @@ func PostOrdersHandler(repo database) func(w http.ResponseWriter, r *http.Reques
 	return fn
 }
 
-func CheckOrders(FlagASAddr string, CheckOrdersTimeout time.Duration, db *postgres.DB) {
-
-	ctx, cancel := context.WithTimeout(context.Background(), CheckOrdersTimeout)
-	defer cancel()
+// CheckOrders опрашивает систему начислений по заказам, ожидающим расчёта, пока не отменён ctx.
+// Новые заказы обрабатываются сразу по уведомлению хранилища,
+// а периодический просмотр всех ожидающих заказов подбирает пропущенные уведомления и повторы.
+// pollTimeout ограничивает один просмотр или обработку одного уведомления.
+// Неудачные попытки по заказу повторяются по расписанию policy.
+func CheckOrders(ctx context.Context, client accrual.Client, pollTimeout time.Duration, repo worker, policy RetryPolicy) {
 
 	ticker := time.NewTicker(time.Second)
 	defer ticker.Stop()
 
+	newOrders, err := repo.ListenNewOrders(ctx)
+	if err != nil {
+		// без подписки новые заказы обработаются при очередном просмотре
+		logger.Warnf("ListenNewOrders: " + err.Error())
+	}
+
+	// до этого момента система начислений просила не присылать запросы
+	var pauseUntil time.Time
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-ticker.C:
-			AwaitOrders, err := ordersrepo.GetAwaitOrders(ctx, db)
-			if err != nil {
-				logger.Warnf(err.Error())
+		case number, ok := <-newOrders:
+			if !ok {
+				newOrders = nil
 				continue
 			}
-			if len(AwaitOrders) == 0 {
+			if time.Now().Before(pauseUntil) {
 				continue
 			}
-			for _, order := range AwaitOrders {
-				err = sendOrdersHandler(ctx, db, FlagASAddr, order)
-				if err != nil {
-					logger.Warnf(err.Error())
-				}
+			pollCtx, cancel := context.WithTimeout(ctx, pollTimeout)
+			checkOrder(pollCtx, repo, client, policy, ordersrepo.Order{OrderNumber: number, OrderStatus: ordersrepo.StatusNew}, &pauseUntil)
+			cancel()
+		case <-ticker.C:
+			if time.Now().Before(pauseUntil) {
+				continue
 			}
-			continue
+			pollCtx, cancel := context.WithTimeout(ctx, pollTimeout)
+			checkAwaitOrders(pollCtx, repo, client, policy, &pauseUntil)
+			cancel()
 		}
 	}
 }
 
-func sendOrdersHandler(ctx context.Context, db *postgres.DB, FlagASAddr string, o ordersrepo.Order) error {
-
-	client := &http.Client{}
-	url := fmt.Sprintf("%s/api/orders/%v", FlagASAddr, o.OrderNumber)
-
-	var body []byte
-	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, bytes.NewBuffer(body))
-	if err != nil {
-		return err
-	}
-	response, err := client.Do(request)
+// checkAwaitOrders запрашивает расчёт по всем ожидающим заказам
+func checkAwaitOrders(ctx context.Context, repo worker, client accrual.Client, policy RetryPolicy, pauseUntil *time.Time) {
+	AwaitOrders, err := repo.GetAwaitOrders(ctx)
 	if err != nil {
-		return err
+		logger.Warnf(err.Error())
+		return
 	}
-	defer response.Body.Close()
-	body, err = io.ReadAll(response.Body)
-	if err != nil {
-		return err
+	for _, order := range AwaitOrders {
+		if !checkOrder(ctx, repo, client, policy, order, pauseUntil) {
+			// система начислений недоступна, остальные заказы проверим позже
+			return
+		}
 	}
+}
 
-	orderUID, err := NewRepo(db).GetOrder(ctx, o.OrderNumber)
-	if err != nil {
-		return err
+// checkOrder запрашивает расчёт по заказу и сообщает, можно ли продолжать обращаться к системе начислений
+func checkOrder(ctx context.Context, repo worker, client accrual.Client, policy RetryPolicy, order ordersrepo.Order, pauseUntil *time.Time) bool {
+	// у каждого обращения к системе начислений свой идентификатор,
+	// а сообщения помечаются номером обрабатываемого заказа
+	orderCtx := requestid.NewContext(ctx, requestid.New())
+	orderCtx = logger.WithOrderNumber(orderCtx, order.OrderNumber)
+	err := sendOrdersHandler(orderCtx, repo, client, order)
+	if err == nil {
+		return true
 	}
-	if response.StatusCode == http.StatusNoContent {
-		o.OrderStatus = "INVALID"
-		ordersrepo.UpdateOrder(ctx, db, orderUID, o)
-		return nil
-	} else if response.StatusCode == http.StatusTooManyRequests {
-		logger.Warnf("number of requests to the service has been exceeded")
-		return err
-	} else if response.StatusCode != http.StatusOK {
-		logger.Warnf("send order for calculation error")
-		return err
+	logger.WarnfCtx(orderCtx, err.Error())
+	var rateLimit *accrual.RateLimitError
+	switch {
+	case errors.As(err, &rateLimit):
+		*pauseUntil = time.Now().Add(rateLimit.RetryAfter)
+		return false
+	case errors.Is(err, accrual.ErrCircuitOpen), errors.Is(err, accrual.ErrNotConfigured):
+		return false
+	case ctx.Err() != nil:
+		// время опроса истекло или обработчик останавливается, попытка не засчитывается
+		return false
+	case errors.Is(err, ordersrepo.ErrIllegalTransition):
+		// статус заказа уже изменён другим источником, например уведомлением
+		return true
 	}
+	scheduleRetry(orderCtx, repo, policy, order, err)
+	return true
+}
 
-	var respBody struct {
-		Order   string  `json:"order"`
-		Status  string  `json:"status"`
-		Accrual float32 `json:"accrual"`
+func sendOrdersHandler(ctx context.Context, repo worker, client accrual.Client, o ordersrepo.Order) error {
+
+	result, err := client.GetOrder(ctx, o.OrderNumber)
+	if err != nil {
+		return err
 	}
-	err = json.Unmarshal(body, &respBody)
 
+	orderUID, err := repo.GetOrder(ctx, o.OrderNumber)
 	if err != nil {
-		logger.Warnf("unmarshal response body error")
 		return err
 	}
-	if respBody.Status == "PROCESSED" {
-		o.OrderStatus = respBody.Status
-		o.Accrual = respBody.Accrual
-	} else if respBody.Status == "INVALID" {
-		o.OrderStatus = respBody.Status
-	} else {
-		o.OrderStatus = "PROCESSING"
+
+	return applyAccrualResult(ctx, repo, orderUID, o, result, ordersrepo.SourcePoller)
+}
+
+// applyAccrualResult переводит заказ в статус по результату расчёта начислений.
+// Используется и при опросе системы начислений, и при получении уведомления от неё (source).
+func applyAccrualResult(ctx context.Context, repo worker, orderUID int, o ordersrepo.Order, result accrual.Result, source string) error {
+	o.OrderStatus = ordersrepo.StatusFromAccrual(result.Status)
+	o.Accrual = 0
+	if o.OrderStatus == ordersrepo.StatusProcessed {
+		o.Accrual = result.Accrual
 	}
 
-	ordersrepo.UpdateOrder(ctx, db, orderUID, o)
-	return nil
+	return repo.UpdateOrder(ctx, orderUID, o, source, string(result.Raw))
 }
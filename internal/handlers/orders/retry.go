@@ -0,0 +1,60 @@
+package orders
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/logger"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/ordersrepo"
+)
+
+// RetryPolicy — расписание повторов расчёта по отдельному заказу.
+// После каждой неудачной попытки пауза удваивается от Base до Max;
+// после MaxAttempts попыток или по прошествии MaxAge с загрузки заказ получает статус FAILED.
+type RetryPolicy struct {
+	Base        time.Duration
+	Max         time.Duration
+	MaxAttempts int
+	// MaxAge — 0 не ограничивает возраст заказа
+	MaxAge time.Duration
+}
+
+// backoff возвращает паузу перед попыткой, следующей за attempts неудачными
+func (p RetryPolicy) backoff(attempts int) time.Duration {
+	d := p.Base
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d <= 0 || d >= p.Max {
+			return p.Max
+		}
+	}
+	if d > p.Max {
+		return p.Max
+	}
+	return d
+}
+
+// exhausted сообщает, что попытки расчёта по заказу пора прекратить
+func (p RetryPolicy) exhausted(attempts int, uploadedAt time.Time) bool {
+	if p.MaxAttempts > 0 && attempts >= p.MaxAttempts {
+		return true
+	}
+	return p.MaxAge > 0 && !uploadedAt.IsZero() && time.Since(uploadedAt) > p.MaxAge
+}
+
+// scheduleRetry учитывает неудачную попытку расчёта по заказу:
+// назначает время следующей попытки или переводит заказ в статус FAILED
+func scheduleRetry(ctx context.Context, repo worker, policy RetryPolicy, order ordersrepo.Order, cause error) {
+	attempts := order.Attempts + 1
+	if policy.exhausted(attempts, order.UploadedAt) {
+		logger.WarnfCtx(ctx, "order failed after "+strconv.Itoa(attempts)+" attempts: "+cause.Error())
+		if err := repo.FailOrder(ctx, order.OrderNumber, attempts, cause.Error()); err != nil {
+			logger.WarnfCtx(ctx, err.Error())
+		}
+		return
+	}
+	if err := repo.RetryOrder(ctx, order.OrderNumber, attempts, time.Now().Add(policy.backoff(attempts)), cause.Error()); err != nil {
+		logger.WarnfCtx(ctx, err.Error())
+	}
+}
@@ -6,13 +6,15 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/url"
+	"strconv"
 	"time"
 
-	"github.com/beliaevke/go-musthave-diploma/internal/db/postgres"
+	"github.com/beliaevke/go-musthave-diploma/internal/audit"
 	"github.com/beliaevke/go-musthave-diploma/internal/logger"
 	"github.com/beliaevke/go-musthave-diploma/internal/middleware/auth"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/auditrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/lockoutrepo"
 	"github.com/beliaevke/go-musthave-diploma/internal/repository/usersrepo"
-	"github.com/beliaevke/go-musthave-diploma/internal/service"
 
 	"github.com/avast/retry-go/v4"
 	"github.com/golang-jwt/jwt/v4"
@@ -22,50 +24,50 @@ type database interface {
 	CreateUser(ctx context.Context, u usersrepo.UserInfo) (int, error)
 	GetUser(ctx context.Context, u usersrepo.UserInfo) (int, error)
 	LoginUser(ctx context.Context, u usersrepo.UserInfo) (int, error)
+	GetAccount(ctx context.Context, userID int) (usersrepo.Account, bool, error)
 	Timeout() time.Duration
 }
 
+type lockouts interface {
+	Attempt(ctx context.Context, limits []lockoutrepo.Limit, check func(ctx context.Context) (bool, error)) (time.Time, error)
+}
+
+// LoginLimits — правила блокировки входа по логину и по адресу клиента
+type LoginLimits struct {
+	Login lockoutrepo.Policy
+	IP    lockoutrepo.Policy
+}
+
 const tokenExpiresAt = time.Second * 30 //time.Minute * 5 //
 
-func authenticateUser(w http.ResponseWriter, userID int) error {
-	// создаём случайный ключ
-	key, err := service.GenerateRandom(16)
-	if err != nil {
-		return err
-	}
+func authenticateUser(w http.ResponseWriter, secret []byte, userID int, role string) error {
 	// создаём новый токен с алгоритмом подписи HS256 и утверждениями — Claims
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, auth.Claims{
+	tokenString, err := auth.SignToken(secret, auth.Claims{
 		RegisteredClaims: jwt.RegisteredClaims{
 			// когда создан токен
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(tokenExpiresAt)),
 		},
-		// собственное утверждение
+		// собственные утверждения
 		UserID: userID,
+		Role:   role,
 	})
-	// создаём строку токена
-	tokenString, err := token.SignedString([]byte(key))
 	if err != nil {
 		return err
 	}
-	// устанавливаем куки
+	// устанавливаем куки; путь — корень, чтобы сессия действовала и в /api/admin
 	http.SetCookie(w, &http.Cookie{
 		Name:    "session_token",
 		Value:   url.QueryEscape(tokenString),
-		Expires: time.Now().Add(tokenExpiresAt),
-	})
-	http.SetCookie(w, &http.Cookie{
-		Name:    "session_key",
-		Value:   url.QueryEscape(string(key)),
+		Path:    "/",
 		Expires: time.Now().Add(tokenExpiresAt),
 	})
 	return nil
 }
 
-func NewRepo(db *postgres.DB) database {
-	return usersrepo.NewUser(db)
-}
-
-func UserRegisterHandler(repo database) func(w http.ResponseWriter, r *http.Request) {
+// UserRegisterHandler регистрирует пользователя с ролью user и открывает сессию,
+// подписанную ключом secret. Регистрация и попытка занять существующий логин
+// записываются в журнал аудита.
+func UserRegisterHandler(repo database, journal audit.Recorder, secret []byte) func(w http.ResponseWriter, r *http.Request) {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		var buf bytes.Buffer
@@ -76,6 +78,7 @@ func UserRegisterHandler(repo database) func(w http.ResponseWriter, r *http.Requ
 		}
 
 		if n == 0 {
+			http.Error(w, "bad request", http.StatusBadRequest)
 			return
 		}
 
@@ -90,10 +93,14 @@ func UserRegisterHandler(repo database) func(w http.ResponseWriter, r *http.Requ
 			retry.Delay(1000*time.Millisecond),
 		)
 		if err != nil {
-			logger.Warnf("JSON error: " + err.Error())
+			logger.WarnfCtx(r.Context(), "JSON error: "+err.Error())
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		if user.UserLogin == "" || user.UserPassword == "" {
+			http.Error(w, "login and password are required", http.StatusBadRequest)
+			return
+		}
 
 		ctx, cancel := context.WithTimeout(r.Context(), repo.Timeout())
 		defer cancel()
@@ -104,6 +111,12 @@ func UserRegisterHandler(repo database) func(w http.ResponseWriter, r *http.Requ
 			return
 		}
 		if userID != -1 {
+			audit.Log(r, journal, auditrepo.Entry{
+				Action:  auditrepo.ActionRegister,
+				Target:  auditrepo.TargetLogin(user.UserLogin),
+				Result:  auditrepo.ResultFailure,
+				Details: map[string]string{"error": "login is taken"},
+			})
 			http.Error(w, "user already exists with this login", http.StatusConflict)
 			return
 		}
@@ -112,7 +125,15 @@ func UserRegisterHandler(repo database) func(w http.ResponseWriter, r *http.Requ
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		err = authenticateUser(w, userID)
+		audit.Log(r, journal, auditrepo.Entry{
+			ActorID:   userID,
+			ActorRole: usersrepo.RoleUser,
+			Action:    auditrepo.ActionRegister,
+			Target:    auditrepo.TargetUser(userID),
+			Result:    auditrepo.ResultSuccess,
+			Details:   map[string]string{"login": user.UserLogin},
+		})
+		err = authenticateUser(w, secret, userID, usersrepo.RoleUser)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusUnauthorized)
 			return
@@ -122,7 +143,12 @@ func UserRegisterHandler(repo database) func(w http.ResponseWriter, r *http.Requ
 	return fn
 }
 
-func UserLoginHandler(repo database) func(w http.ResponseWriter, r *http.Request) {
+// UserLoginHandler открывает сессию пользователя, подписанную ключом secret;
+// роль пользователя записывается в токен. 403 — учётная запись заблокирована.
+// Неудачные входы учитываются по логину и по адресу клиента (правила limits):
+// пока вход приостановлен, пароль не проверяется, а ответ — 429 с заголовком Retry-After.
+// Удачные и неудачные попытки входа записываются в журнал аудита.
+func UserLoginHandler(repo database, guard lockouts, limits LoginLimits, journal audit.Recorder, secret []byte) func(w http.ResponseWriter, r *http.Request) {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		var buf bytes.Buffer
@@ -133,6 +159,7 @@ func UserLoginHandler(repo database) func(w http.ResponseWriter, r *http.Request
 		}
 
 		if n == 0 {
+			http.Error(w, "bad request", http.StatusBadRequest)
 			return
 		}
 
@@ -148,28 +175,84 @@ func UserLoginHandler(repo database) func(w http.ResponseWriter, r *http.Request
 			retry.Delay(1000*time.Millisecond),
 		)
 		if err != nil {
-			logger.Warnf("JSON error: " + err.Error())
+			logger.WarnfCtx(r.Context(), "JSON error: "+err.Error())
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		if user.UserLogin == "" || user.UserPassword == "" {
+			http.Error(w, "login and password are required", http.StatusBadRequest)
+			return
+		}
 
 		ctx, cancel := context.WithTimeout(r.Context(), repo.Timeout())
 		defer cancel()
 
-		userID, err := repo.LoginUser(ctx, user)
+		// пароль проверяется, пока счётчики логина и адреса заблокированы: параллельные
+		// попытки ждут её результата и не обходят паузу после неудачи
+		userID := -1
+		blockedUntil, err := guard.Attempt(ctx, []lockoutrepo.Limit{
+			{Key: lockoutrepo.KeyLogin(user.UserLogin), Policy: limits.Login, ResetOnSuccess: true},
+			{Key: lockoutrepo.KeyIP(audit.ClientIP(r)), Policy: limits.IP},
+		}, func(ctx context.Context) (bool, error) {
+			var err error
+			userID, err = repo.LoginUser(ctx, user)
+			return userID != -1, err
+		})
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if !blockedUntil.IsZero() {
+			audit.Log(r, journal, auditrepo.Entry{
+				Action:  auditrepo.ActionLogin,
+				Target:  auditrepo.TargetLogin(user.UserLogin),
+				Result:  auditrepo.ResultDenied,
+				Details: map[string]string{"error": "too many failed attempts"},
+			})
+			// Retry-After — целое число секунд, округлённое вверх
+			w.Header().Set("Retry-After", strconv.FormatInt(int64((time.Until(blockedUntil)+time.Second-1)/time.Second), 10))
+			http.Error(w, "too many failed login attempts", http.StatusTooManyRequests)
+			return
+		}
 		if userID == -1 {
+			audit.Log(r, journal, auditrepo.Entry{
+				Action:  auditrepo.ActionLogin,
+				Target:  auditrepo.TargetLogin(user.UserLogin),
+				Result:  auditrepo.ResultFailure,
+				Details: map[string]string{"error": "incorrect login or password"},
+			})
+			http.Error(w, "incorrect login or password", http.StatusUnauthorized)
+			return
+		}
+		account, found, err := repo.GetAccount(ctx, userID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !found {
 			http.Error(w, "incorrect login or password", http.StatusUnauthorized)
 			return
 		}
-		err = authenticateUser(w, userID)
+		entry := auditrepo.Entry{
+			ActorID:   userID,
+			ActorRole: account.Role,
+			Action:    auditrepo.ActionLogin,
+			Target:    auditrepo.TargetUser(userID),
+			Result:    auditrepo.ResultSuccess,
+		}
+		if account.DisabledAt != nil {
+			entry.Result = auditrepo.ResultDenied
+			entry.Details = map[string]string{"error": "account is disabled"}
+			audit.Log(r, journal, entry)
+			http.Error(w, "account is disabled", http.StatusForbidden)
+			return
+		}
+		err = authenticateUser(w, secret, userID, account.Role)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusUnauthorized)
 			return
 		}
+		audit.Log(r, journal, entry)
 		w.WriteHeader(http.StatusOK)
 	}
 	return fn
@@ -0,0 +1,217 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/logger"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/webhooksrepo"
+)
+
+// Заголовки уведомления
+const (
+	EventHeader     = "X-Gophermart-Event"
+	DeliveryHeader  = "X-Gophermart-Delivery"
+	TimestampHeader = "X-Gophermart-Timestamp"
+	SignatureHeader = "X-Gophermart-Signature"
+)
+
+const (
+	// claimLimit — число уведомлений, которые берутся в отправку за один просмотр очереди
+	claimLimit = 100
+	// senders — число одновременных запросов к адресам пользователей
+	senders = 8
+	// maxErrorLength — предельная длина сохраняемого текста ошибки
+	maxErrorLength = 500
+)
+
+type dispatcher interface {
+	ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]webhooksrepo.PendingDelivery, error)
+	MarkDelivered(ctx context.Context, deliveryID int64, attempts int, responseCode int) error
+	RetryDelivery(ctx context.Context, deliveryID int64, attempts int, nextAttemptAt time.Time, responseCode int, lastError string) error
+	FailDelivery(ctx context.Context, deliveryID int64, attempts int, responseCode int, lastError string) error
+	Timeout() time.Duration
+}
+
+// RetryPolicy — расписание повторов доставки уведомления.
+// После каждой неудачной попытки пауза удваивается от Base до Max;
+// после MaxAttempts попыток доставка получает статус FAILED.
+type RetryPolicy struct {
+	Base        time.Duration
+	Max         time.Duration
+	MaxAttempts int
+}
+
+// backoff возвращает паузу перед попыткой, следующей за attempts неудачными
+func (p RetryPolicy) backoff(attempts int) time.Duration {
+	d := p.Base
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d <= 0 || d >= p.Max {
+			return p.Max
+		}
+	}
+	if d > p.Max {
+		return p.Max
+	}
+	return d
+}
+
+// Sign возвращает подпись уведомления: HMAC-SHA256 от "<timestamp>.<тело запроса>"
+// в виде "sha256=<hex>"
+func Sign(secret []byte, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10))) //nolint
+	mac.Write([]byte("."))                              //nolint
+	mac.Write(body)                                     //nolint
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// ErrForbiddenAddress — адрес уведомлений указывает на локальную или частную сеть
+var ErrForbiddenAddress = errors.New("webhook address is not allowed")
+
+// NewClient возвращает HTTP-клиента для отправки уведомлений. Клиент не следует
+// перенаправлениям и, если не задан allowPrivate, не подключается к адресам
+// локальных и частных сетей: адрес проверяется после разрешения имени,
+// поэтому обойти запрет через DNS нельзя.
+func NewClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+				ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+				return ErrForbiddenAddress
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// DeliverWebhooks раз в секунду отправляет уведомления, время которых пришло, пока не
+// отменён ctx. Уведомление доставлено, если адрес ответил кодом 2xx; иначе попытка
+// повторяется по расписанию policy. Взятые в отправку уведомления не достаются другим
+// репликам сервиса, пока не истечёт срок отправки; если реплика остановилась, не отметив
+// результат, уведомление отправится повторно. Начатая пачка при отмене ctx отправляется
+// до конца: время отправки ограничено таймаутом client.
+func DeliverWebhooks(ctx context.Context, client *http.Client, repo dispatcher, policy RetryPolicy) {
+	// срок, на который уведомления закрепляются за репликой
+	lease := client.Timeout + repo.Timeout() + time.Minute
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		claimCtx, cancel := context.WithTimeout(ctx, repo.Timeout())
+		pending, err := repo.ClaimDeliveries(claimCtx, claimLimit, lease)
+		cancel()
+		if err != nil {
+			logger.Warnf("ClaimDeliveries: " + err.Error())
+			continue
+		}
+		sendAll(context.WithoutCancel(ctx), client, repo, policy, pending)
+	}
+}
+
+func sendAll(ctx context.Context, client *http.Client, repo dispatcher, policy RetryPolicy, pending []webhooksrepo.PendingDelivery) {
+	queue := make(chan webhooksrepo.PendingDelivery)
+	var wg sync.WaitGroup
+	for i := 0; i < min(senders, len(pending)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range queue {
+				deliver(ctx, client, repo, policy, d)
+			}
+		}()
+	}
+	for _, d := range pending {
+		queue <- d
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// deliver отправляет уведомление и записывает результат попытки
+func deliver(ctx context.Context, client *http.Client, repo dispatcher, policy RetryPolicy, d webhooksrepo.PendingDelivery) {
+	attempts := d.Attempts + 1
+	code, err := send(ctx, client, d)
+
+	ctx, cancel := context.WithTimeout(ctx, repo.Timeout())
+	defer cancel()
+
+	if err == nil {
+		if err = repo.MarkDelivered(ctx, d.ID, attempts, code); err != nil {
+			logger.WarnfCtx(ctx, err.Error())
+		}
+		return
+	}
+	lastError := err.Error()
+	if len(lastError) > maxErrorLength {
+		lastError = lastError[:maxErrorLength]
+	}
+	if policy.MaxAttempts > 0 && attempts >= policy.MaxAttempts {
+		logger.WarnfCtx(ctx, "webhook delivery "+strconv.FormatInt(d.ID, 10)+" failed after "+strconv.Itoa(attempts)+" attempts: "+lastError)
+		err = repo.FailDelivery(ctx, d.ID, attempts, code, lastError)
+	} else {
+		err = repo.RetryDelivery(ctx, d.ID, attempts, time.Now().Add(policy.backoff(attempts)), code, lastError)
+	}
+	if err != nil {
+		logger.WarnfCtx(ctx, err.Error())
+	}
+}
+
+// send выполняет запрос к адресу уведомлений и возвращает код ответа (0, если ответа нет)
+func send(ctx context.Context, client *http.Client, d webhooksrepo.PendingDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, d.EventType)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(d.ID, 10))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign([]byte(d.Secret), timestamp, d.Payload))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16)) //nolint
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, errors.New("unexpected response status " + resp.Status)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,51 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/storage/memory"
+)
+
+func TestClientRejectsPrivateAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	if _, err := NewClient(time.Second, false).Get(srv.URL); !errors.Is(err, ErrForbiddenAddress) {
+		t.Errorf("Expected loopback address to be rejected; got %v", err)
+	}
+	resp, err := NewClient(time.Second, true).Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Expected loopback address to be allowed; got %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{Base: time.Second, Max: 5 * time.Second}
+	for attempts, expected := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 100: 5 * time.Second} {
+		if got := policy.backoff(attempts); got != expected {
+			t.Errorf("backoff(%d): expected %v; got %v", attempts, expected, got)
+		}
+	}
+}
+
+func TestDeliverWebhooksStops(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		DeliverWebhooks(ctx, NewClient(time.Second, true), memory.New(time.Second).Webhooks, RetryPolicy{})
+	}()
+	time.Sleep(1500 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected DeliverWebhooks to return after ctx is cancelled")
+	}
+}
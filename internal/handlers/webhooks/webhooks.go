@@ -0,0 +1,253 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/logger"
+	"github.com/beliaevke/go-musthave-diploma/internal/pagination"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/webhooksrepo"
+
+	"github.com/go-chi/chi"
+)
+
+type database interface {
+	CreateWebhook(ctx context.Context, userID int, w webhooksrepo.Webhook) (webhooksrepo.Webhook, error)
+	ListWebhooks(ctx context.Context, userID int) ([]webhooksrepo.Webhook, error)
+	DeleteWebhook(ctx context.Context, userID int, webhookID int64) (bool, error)
+	GetWebhookOwner(ctx context.Context, webhookID int64) (int, error)
+	ListDeliveries(ctx context.Context, webhookID int64, p pagination.Params) ([]webhooksrepo.Delivery, *pagination.Cursor, error)
+	Timeout() time.Duration
+}
+
+// maxURLLength — предельная длина адреса уведомлений
+const maxURLLength = 2048
+
+// PostWebhookHandler добавляет адрес уведомлений пользователя.
+// Тело запроса: {"url": "https://...", "events": ["order.processed", ...]},
+// пустой список событий означает подписку на все события.
+// В ответе возвращается ключ подписи, позже получить его нельзя.
+func PostWebhookHandler(repo database) func(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(w.Header().Get("UID"))
+		if err != nil {
+			logger.WarnfCtx(r.Context(), "UID validate error: "+err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var req struct {
+			URL    string   `json:"url"`
+			Events []string `json:"events"`
+		}
+		if err = json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<14)).Decode(&req); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if err = validateURL(req.URL); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		events, err := normalizeEvents(req.Events)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		secret, err := newSecret()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), repo.Timeout())
+		defer cancel()
+
+		hook, err := repo.CreateWebhook(ctx, userID, webhooksrepo.Webhook{
+			URL:       req.URL,
+			Secret:    secret,
+			Events:    events,
+			CreatedAt: time.Now(),
+		})
+		if errors.Is(err, webhooksrepo.ErrTooManyWebhooks) {
+			http.Error(w, "no more than "+strconv.Itoa(webhooksrepo.MaxWebhooks)+" webhooks allowed", http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		if err = json.NewEncoder(w).Encode(&hook); err != nil {
+			logger.WarnfCtx(r.Context(), "JSON encode error: "+err.Error())
+		}
+	}
+	return fn
+}
+
+// GetWebhooksHandler возвращает адреса уведомлений пользователя без ключей подписи
+func GetWebhooksHandler(repo database) func(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		userID, err := strconv.Atoi(w.Header().Get("UID"))
+		if err != nil {
+			logger.WarnfCtx(r.Context(), "UID validate error: "+err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), repo.Timeout())
+		defer cancel()
+
+		hooks, err := repo.ListWebhooks(ctx, userID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(hooks) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		encoder := json.NewEncoder(w)
+		err = encoder.Encode(&hooks)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	return fn
+}
+
+// DeleteWebhookHandler удаляет адрес уведомлений пользователя вместе с журналом доставки
+func DeleteWebhookHandler(repo database) func(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(w.Header().Get("UID"))
+		if err != nil {
+			logger.WarnfCtx(r.Context(), "UID validate error: "+err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		webhookID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			http.Error(w, "webhook not found", http.StatusNotFound)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), repo.Timeout())
+		defer cancel()
+
+		deleted, err := repo.DeleteWebhook(ctx, userID, webhookID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			http.Error(w, "webhook not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+	return fn
+}
+
+// GetDeliveriesHandler возвращает страницу журнала доставки уведомлений на адрес пользователя.
+// Параметры страницы описаны в pagination.Parse (поле сортировки created_at).
+func GetDeliveriesHandler(repo database) func(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		userID, err := strconv.Atoi(w.Header().Get("UID"))
+		if err != nil {
+			logger.WarnfCtx(r.Context(), "UID validate error: "+err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		webhookID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			http.Error(w, "webhook not found", http.StatusNotFound)
+			return
+		}
+		page, err := pagination.Parse(r.URL.Query(), "created_at")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), repo.Timeout())
+		defer cancel()
+
+		ownerID, err := repo.GetWebhookOwner(ctx, webhookID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// чужой адрес не отличается от несуществующего
+		if ownerID != userID {
+			http.Error(w, "webhook not found", http.StatusNotFound)
+			return
+		}
+
+		deliveries, next, err := repo.ListDeliveries(ctx, webhookID, page)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(deliveries) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		pagination.SetNext(w, r, next)
+		encoder := json.NewEncoder(w)
+		err = encoder.Encode(&deliveries)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	return fn
+}
+
+func validateURL(raw string) error {
+	if raw == "" || len(raw) > maxURLLength {
+		return errors.New("url must be set and not longer than " + strconv.Itoa(maxURLLength) + " characters")
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.New("url must be an absolute http(s) URL")
+	}
+	if u.User != nil {
+		return errors.New("url must not contain credentials")
+	}
+	return nil
+}
+
+// normalizeEvents проверяет типы событий и убирает повторы; пустой список — все события
+func normalizeEvents(events []string) ([]string, error) {
+	if len(events) == 0 {
+		return slices.Clone(webhooksrepo.EventTypes), nil
+	}
+	val := make([]string, 0, len(events))
+	for _, e := range events {
+		if !slices.Contains(webhooksrepo.EventTypes, e) {
+			return nil, errors.New("unknown event type " + strconv.Quote(e))
+		}
+		if !slices.Contains(val, e) {
+			val = append(val, e)
+		}
+	}
+	return val, nil
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -1,13 +1,21 @@
 package logger
 
 import (
+	"bufio"
+	"context"
 	"log"
+	"net"
 	"net/http"
 	"time"
 
+	"github.com/beliaevke/go-musthave-diploma/internal/middleware/requestid"
+	"github.com/beliaevke/go-musthave-diploma/internal/redact"
+
 	"go.uber.org/zap"
 )
 
+type orderKey struct{}
+
 type (
 	// берём структуру для хранения сведений об ответе
 	responseData struct {
@@ -35,6 +43,20 @@ func (r *loggingResponseWriter) WriteHeader(statusCode int) {
 	r.responseData.status = statusCode // захватываем код статуса
 }
 
+// Unwrap даёт http.ResponseController доступ к исходному http.ResponseWriter (Flush для SSE)
+func (r *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Hijack передаёт соединение обработчику WebSocket, код ответа в логе — 101
+func (r *loggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(r.ResponseWriter).Hijack()
+	if err == nil {
+		r.responseData.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
 // WithLogging добавляет дополнительный код для регистрации сведений о запросе
 // и возвращает новый http.Handler.
 func WithLogging(h http.Handler) http.Handler {
@@ -67,6 +89,7 @@ func WithLogging(h http.Handler) http.Handler {
 
 		if r.Method == http.MethodGet {
 			sugar.Infoln(
+				"request_id", requestid.FromContext(r.Context()),
 				"uri", r.RequestURI,
 				"method", r.Method,
 				"duration", duration,
@@ -75,6 +98,7 @@ func WithLogging(h http.Handler) http.Handler {
 			)
 		} else {
 			sugar.Infoln(
+				"request_id", requestid.FromContext(r.Context()),
 				"uri", r.RequestURI,
 				"method", r.Method,
 				"status", responseData.status,
@@ -119,7 +143,7 @@ func Warnf(s string) {
 	sugar := logger.Sugar()
 
 	// выводим сообщение
-	sugar.Warnf("%s", s)
+	sugar.Warnf("%s", redact.String(s))
 }
 
 func Infof(s string) {
@@ -137,5 +161,55 @@ func Infof(s string) {
 	sugar := logger.Sugar()
 
 	// выводим сообщение
-	sugar.Infof("%s", s)
+	sugar.Infof("%s", redact.String(s))
+}
+
+// WithOrderNumber возвращает копию контекста с номером обрабатываемого заказа,
+// который будет добавлен к сообщениям WarnfCtx и InfofCtx
+func WithOrderNumber(ctx context.Context, orderNumber string) context.Context {
+	return context.WithValue(ctx, orderKey{}, orderNumber)
+}
+
+// contextFields собирает из контекста поля для корреляции сообщений
+func contextFields(ctx context.Context) []interface{} {
+	var fields []interface{}
+	if id := requestid.FromContext(ctx); id != "" {
+		fields = append(fields, "request_id", id)
+	}
+	if orderNumber, ok := ctx.Value(orderKey{}).(string); ok && orderNumber != "" {
+		fields = append(fields, "order", orderNumber)
+	}
+	return fields
+}
+
+func WarnfCtx(ctx context.Context, s string) {
+	// добавляем предустановленный логер NewDevelopment
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		// вызываем панику, если ошибка
+		log.Fatal(err)
+	}
+	defer logger.Sync() //nolint
+
+	// делаем логер SugaredLogger с полями из контекста
+	sugar := logger.Sugar().With(contextFields(ctx)...)
+
+	// выводим сообщение
+	sugar.Warnf("%s", redact.String(s))
+}
+
+func InfofCtx(ctx context.Context, s string) {
+	// добавляем предустановленный логер NewDevelopment
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		// вызываем панику, если ошибка
+		log.Fatal(err)
+	}
+	defer logger.Sync() //nolint
+
+	// делаем логер SugaredLogger с полями из контекста
+	sugar := logger.Sugar().With(contextFields(ctx)...)
+
+	// выводим сообщение
+	sugar.Infof("%s", redact.String(s))
 }
@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/usersrepo"
+)
+
+// WithAdminAuthentication проверяет запрос к административному API. Запрос с заголовком
+// Authorization: Bearer <token> выполняется от имени администратора без учётной записи
+// (UID 0, роль admin), если token задан и совпадает; остальные запросы проверяются
+// как в WithAuthentication, с текущей ролью учётной записи. Права проверяет WithRole.
+func WithAdminAuthentication(secret []byte, token string, accounts Accounts) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+				if token == "" || subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				serveAuthenticated(h, w, r, &Claims{Role: usersrepo.RoleAdmin})
+				return
+			}
+			claims, status, err := sessionClaims(r, secret, accounts)
+			if err != nil {
+				http.Error(w, err.Error(), status)
+				return
+			}
+			serveAuthenticated(h, w, r, claims)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// WithRole пропускает только запросы пользователей с одной из ролей roles (403 — иначе).
+// Используется после WithAuthentication или WithAdminAuthentication.
+func WithRole(roles ...string) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := ClaimsFromContext(r.Context())
+			if !ok {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if !slices.Contains(roles, claims.Role) {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			h.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
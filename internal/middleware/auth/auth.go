@@ -1,9 +1,16 @@
 package auth
 
 import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/usersrepo"
 
 	"github.com/golang-jwt/jwt/v4"
 )
@@ -11,6 +18,28 @@ import (
 type Claims struct {
 	jwt.RegisteredClaims
 	UserID int
+	// Role — роль пользователя (usersrepo.Roles). В токене — роль на момент входа,
+	// в контексте запроса — текущая роль учётной записи.
+	Role string `json:",omitempty"`
+}
+
+// Accounts — учётные записи, из которых берутся текущие роль и блокировка пользователя
+type Accounts interface {
+	GetAccount(ctx context.Context, userID int) (usersrepo.Account, bool, error)
+	Timeout() time.Duration
+}
+
+type claimsKey struct{}
+
+// ClaimsFromContext возвращает утверждения токена, проверенного WithAuthentication
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*Claims)
+	return claims, ok
+}
+
+// SignToken возвращает токен сессии с утверждениями claims, подписанный ключом secret (HS256)
+func SignToken(secret []byte, claims Claims) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
 }
 
 type authenticationResponseWriter struct {
@@ -18,60 +47,86 @@ type authenticationResponseWriter struct {
 	userID              int
 }
 
-func WithAuthentication(h http.Handler) http.Handler {
-	fn := func(w http.ResponseWriter, r *http.Request) {
-		st, err := r.Cookie("session_token")
-		if err != nil {
-			if err == http.ErrNoCookie {
-				w.WriteHeader(http.StatusUnauthorized)
-				return
-			}
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		sk, err := r.Cookie("session_key")
-		if err != nil {
-			if err == http.ErrNoCookie {
-				w.WriteHeader(http.StatusUnauthorized)
+// Unwrap даёт http.ResponseController доступ к исходному http.ResponseWriter (Flush для SSE)
+func (w *authenticationResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Hijack передаёт соединение обработчику WebSocket
+func (w *authenticationResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// WithAuthentication пропускает только запросы с токеном сессии (cookie session_token),
+// подписанным ключом secret, от незаблокированных пользователей (403 — учётная запись
+// заблокирована). Пользователь передаётся обработчику в заголовке UID, утверждения
+// токена с текущей ролью из accounts — в контексте запроса (ClaimsFromContext).
+func WithAuthentication(secret []byte, accounts Accounts) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			claims, status, err := sessionClaims(r, secret, accounts)
+			if err != nil {
+				http.Error(w, err.Error(), status)
 				return
 			}
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		sessionToken, err := url.QueryUnescape(st.Value)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusUnauthorized)
-			return
-		}
-		sessionKey, err := url.QueryUnescape(sk.Value)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusUnauthorized)
-			return
-		}
-
-		claims := &Claims{}
-		token, err := jwt.ParseWithClaims(sessionToken, claims,
-			func(t *jwt.Token) (interface{}, error) {
-				return []byte(sessionKey), nil
-			})
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusUnauthorized)
-			return
+			serveAuthenticated(h, w, r, claims)
 		}
+		return http.HandlerFunc(fn)
+	}
+}
 
-		if !token.Valid {
-			http.Error(w, "token is not valid", http.StatusUnauthorized)
-			return
+// sessionClaims проверяет токен сессии запроса и учётную запись его пользователя и возвращает
+// утверждения токена с текущей ролью или код ответа и ошибку. Роль и блокировка читаются
+// из accounts при каждом запросе: смена роли и блокировка действуют, не дожидаясь
+// истечения токена.
+func sessionClaims(r *http.Request, secret []byte, accounts Accounts) (*Claims, int, error) {
+	st, err := r.Cookie("session_token")
+	if err != nil {
+		if err == http.ErrNoCookie {
+			return nil, http.StatusUnauthorized, errors.New("session token is required")
 		}
+		return nil, http.StatusBadRequest, err
+	}
+	sessionToken, err := url.QueryUnescape(st.Value)
+	if err != nil {
+		return nil, http.StatusUnauthorized, err
+	}
 
-		aw := authenticationResponseWriter{
-			ResponseWriter: w, // встраиваем оригинальный http.ResponseWriter
-			userID:         claims.UserID,
-		}
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(sessionToken, claims,
+		func(t *jwt.Token) (interface{}, error) {
+			return secret, nil
+		}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return nil, http.StatusUnauthorized, err
+	}
+	if !token.Valid {
+		return nil, http.StatusUnauthorized, errors.New("token is not valid")
+	}
 
-		aw.Header().Set("UID", strconv.Itoa(aw.userID))
+	ctx, cancel := context.WithTimeout(r.Context(), accounts.Timeout())
+	defer cancel()
+	account, found, err := accounts.GetAccount(ctx, claims.UserID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if !found {
+		return nil, http.StatusUnauthorized, errors.New("account not found")
+	}
+	if account.DisabledAt != nil {
+		return nil, http.StatusForbidden, errors.New("account is disabled")
+	}
+	claims.Role = account.Role
+	return claims, http.StatusOK, nil
+}
 
-		h.ServeHTTP(&aw, r)
+func serveAuthenticated(h http.Handler, w http.ResponseWriter, r *http.Request, claims *Claims) {
+	aw := authenticationResponseWriter{
+		ResponseWriter: w, // встраиваем оригинальный http.ResponseWriter
+		userID:         claims.UserID,
 	}
-	return http.HandlerFunc(fn)
+
+	aw.Header().Set("UID", strconv.Itoa(aw.userID))
+
+	h.ServeHTTP(&aw, r.WithContext(context.WithValue(r.Context(), claimsKey{}, claims)))
 }
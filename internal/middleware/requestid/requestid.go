@@ -0,0 +1,67 @@
+package requestid
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/service"
+)
+
+// Header — заголовок, в котором передаётся идентификатор запроса
+const Header = "X-Request-ID"
+
+// максимальная длина принимаемого от клиента идентификатора
+const maxLength = 128
+
+type ctxKey struct{}
+
+// New генерирует новый идентификатор запроса
+func New() string {
+	b, err := service.GenerateRandom(16)
+	if err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// NewContext возвращает копию контекста с идентификатором запроса
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext возвращает идентификатор запроса из контекста или пустую строку
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
+
+// valid проверяет, что идентификатор от клиента безопасно писать в логи и заголовки
+func valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// WithRequestID принимает идентификатор из заголовка X-Request-ID
+// или генерирует новый, кладёт его в контекст запроса и в заголовок ответа
+func WithRequestID(h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(Header)
+		if !valid(id) {
+			id = New()
+		}
+		w.Header().Set(Header, id)
+		h.ServeHTTP(w, r.WithContext(NewContext(r.Context(), id)))
+	}
+	return http.HandlerFunc(fn)
+}
@@ -0,0 +1,99 @@
+// Package outbox передаёт доменные события из таблицы outbox внешним получателям:
+// в лог, по HTTP или в NATS. События передаются по порядку номеров не менее одного раза:
+// событие, переданное перед остановкой сервиса, но не отмеченное опубликованным,
+// будет передано повторно, поэтому получатели должны отсеивать повторы по номеру события.
+package outbox
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/logger"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/outboxrepo"
+)
+
+const (
+	// batchSize — число событий, передаваемых за одну транзакцию
+	batchSize = 100
+	// maxPause — наибольшая пауза после ошибки получателя
+	maxPause = time.Minute
+	// purgeInterval — период удаления опубликованных событий
+	purgeInterval = time.Hour
+)
+
+// Sink — получатель событий. Publish возвращает nil, только если получатель принял событие.
+type Sink interface {
+	Publish(ctx context.Context, e outboxrepo.Event) error
+}
+
+type relayer interface {
+	Relay(ctx context.Context, limit int, publish func(ctx context.Context, e outboxrepo.Event) error) (int, error)
+	Purge(ctx context.Context, before time.Time) (int64, error)
+	Timeout() time.Duration
+}
+
+// Relay раз в секунду передаёт новые события получателю sink, пока не отменён ctx.
+// Если получатель не принял событие, передача останавливается на нём и повторяется
+// после паузы, которая удваивается до минуты, поэтому порядок событий не нарушается.
+// Опубликованные события хранятся retention (0 — не удаляются).
+func Relay(ctx context.Context, repo relayer, sink Sink, retention time.Duration) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var (
+		pause    time.Duration
+		retryAt  time.Time
+		purgedAt time.Time
+	)
+	for {
+		var now time.Time
+		select {
+		case <-ctx.Done():
+			return
+		case now = <-ticker.C:
+		}
+		if now.Before(retryAt) {
+			continue
+		}
+		if err := relayPending(ctx, repo, sink); err != nil {
+			pause = min(max(2*pause, time.Second), maxPause)
+			retryAt = now.Add(pause)
+			logger.Warnf("outbox relay: " + err.Error() + ", next attempt in " + pause.String())
+		} else {
+			pause = 0
+		}
+		if retention > 0 && now.Sub(purgedAt) >= purgeInterval {
+			purgedAt = now
+			purge(ctx, repo, now.Add(-retention))
+		}
+	}
+}
+
+// relayPending передаёт события пачками, пока они не кончатся или не отменён ctx.
+// Начатая пачка передаётся до конца и при отмене ctx.
+func relayPending(ctx context.Context, repo relayer, sink Sink) error {
+	for ctx.Err() == nil {
+		// пачка передаётся в одной транзакции, поэтому время на неё ограничено
+		batchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), repo.Timeout())
+		n, err := repo.Relay(batchCtx, batchSize, sink.Publish)
+		cancel()
+		if err != nil || n < batchSize {
+			return err
+		}
+	}
+	return nil
+}
+
+func purge(ctx context.Context, repo relayer, before time.Time) {
+	ctx, cancel := context.WithTimeout(ctx, repo.Timeout())
+	defer cancel()
+	n, err := repo.Purge(ctx, before)
+	if err != nil {
+		logger.Warnf("outbox purge: " + err.Error())
+		return
+	}
+	if n > 0 {
+		logger.Infof("outbox purge: " + strconv.FormatInt(n, 10) + " published events removed")
+	}
+}
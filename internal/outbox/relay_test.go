@@ -0,0 +1,132 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/auditrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/balancerepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/ordersrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/outboxrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/usersrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/storage/memory"
+)
+
+// recordingSink запоминает принятые события и отказывает, пока задано fail
+type recordingSink struct {
+	events []outboxrepo.Event
+	fail   error
+}
+
+func (s *recordingSink) Publish(_ context.Context, e outboxrepo.Event) error {
+	if s.fail != nil {
+		return s.fail
+	}
+	s.events = append(s.events, e)
+	return nil
+}
+
+func TestRelayInOrder(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New(time.Second)
+	userID, err := store.Users.CreateUser(ctx, usersrepo.UserInfo{UserLogin: "user", UserPassword: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Orders.AddOrder(ctx, userID, "12345678903"); err != nil {
+		t.Fatal(err)
+	}
+
+	// событие, не принятое получателем, остаётся неопубликованным
+	sink := &recordingSink{fail: errors.New("unavailable")}
+	if err = relayPending(ctx, store.Outbox, sink); err == nil {
+		t.Fatal("Expected sink error")
+	}
+
+	sink.fail = nil
+	order := ordersrepo.Order{OrderNumber: "12345678903", OrderStatus: ordersrepo.StatusProcessed, Accrual: 100}
+	if err = store.Orders.UpdateOrder(ctx, userID, order, ordersrepo.SourcePoller, ""); err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Balance.BalanceWithdraw(ctx, userID, balancerepo.Withdraw{OrderNumber: "2377225624", Sum: 40}, auditrepo.Entry{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = relayPending(ctx, store.Outbox, sink); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		outboxrepo.TypeUserCreated,
+		outboxrepo.TypeOrderUploaded,
+		outboxrepo.TypeOrderStatusChanged,
+		outboxrepo.TypeBalanceChanged,
+		outboxrepo.TypeBalanceChanged,
+	}
+	if len(sink.events) != len(expected) {
+		t.Fatalf("Expected %d events; got %+v", len(expected), sink.events)
+	}
+	for i, e := range sink.events {
+		if e.Type != expected[i] || e.UserID != userID || (i > 0 && e.ID <= sink.events[i-1].ID) {
+			t.Errorf("Event %d: expected %s in order; got %+v", i, expected[i], e)
+		}
+	}
+	var withdrawal outboxrepo.BalanceChanged
+	if err = json.Unmarshal(sink.events[4].Payload, &withdrawal); err != nil || withdrawal.Sum != -40 || withdrawal.Current != 60 {
+		t.Errorf("Unexpected withdrawal payload %s", sink.events[4].Payload)
+	}
+
+	// опубликованные события повторно не передаются
+	if err = relayPending(ctx, store.Outbox, sink); err != nil || len(sink.events) != len(expected) {
+		t.Errorf("Expected no new events; got %d, %v", len(sink.events), err)
+	}
+	if n, err := store.Outbox.Purge(ctx, time.Now().Add(time.Second)); err != nil || n != int64(len(expected)) {
+		t.Errorf("Expected %d purged events; got %d, %v", len(expected), n, err)
+	}
+}
+
+func TestHTTPSink(t *testing.T) {
+	var got outboxrepo.Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(EventIDHeader) == "2" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got) //nolint
+	}))
+	defer srv.Close()
+
+	sink := NewHTTPSink(srv.URL, time.Second)
+	event := outboxrepo.Event{ID: 1, Type: outboxrepo.TypeUserCreated, UserID: 7, Payload: json.RawMessage(`{"login":"user"}`)}
+	if err := sink.Publish(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != 1 || got.UserID != 7 || string(got.Payload) != `{"login":"user"}` {
+		t.Errorf("Unexpected event received %+v", got)
+	}
+	event.ID = 2
+	if err := sink.Publish(context.Background(), event); err == nil {
+		t.Error("Expected error on 5xx response")
+	}
+}
+
+func TestRelayStops(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Relay(ctx, memory.New(time.Second).Outbox, &recordingSink{}, time.Hour)
+	}()
+	time.Sleep(1500 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected Relay to return after ctx is cancelled")
+	}
+}
@@ -0,0 +1,104 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/logger"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/outboxrepo"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Заголовки запроса HTTP-получателю
+const (
+	EventIDHeader   = "X-Outbox-Event-ID"
+	EventTypeHeader = "X-Outbox-Event-Type"
+)
+
+// LogSink записывает события в лог сервиса
+type LogSink struct{}
+
+func (LogSink) Publish(ctx context.Context, e outboxrepo.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	logger.InfofCtx(ctx, "outbox event: "+string(data))
+	return nil
+}
+
+// HTTPSink отправляет каждое событие запросом POST с JSON-телом события.
+// Событие принято, если получатель ответил кодом 2xx.
+type HTTPSink struct {
+	url    string
+	client *http.Client
+}
+
+func NewHTTPSink(url string, timeout time.Duration) *HTTPSink {
+	return &HTTPSink{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+func (s *HTTPSink) Publish(ctx context.Context, e outboxrepo.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventIDHeader, strconv.FormatInt(e.ID, 10))
+	req.Header.Set(EventTypeHeader, e.Type)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16)) //nolint
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("outbox sink responded " + resp.Status)
+	}
+	return nil
+}
+
+// NATSSink публикует события в NATS в тему "<subject>.<тип события>".
+// Номер события передаётся в заголовке Nats-Msg-Id, по которому JetStream отсеивает повторы.
+type NATSSink struct {
+	conn    *nats.Conn
+	subject string
+}
+
+func NewNATSSink(url, subject string, timeout time.Duration) (*NATSSink, error) {
+	conn, err := nats.Connect(url,
+		nats.Name("gophermart outbox"),
+		nats.Timeout(timeout),
+		nats.MaxReconnects(-1),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &NATSSink{conn: conn, subject: subject}, nil
+}
+
+func (s *NATSSink) Publish(ctx context.Context, e outboxrepo.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	msg := nats.NewMsg(s.subject + "." + e.Type)
+	msg.Data = data
+	msg.Header.Set(nats.MsgIdHdr, strconv.FormatInt(e.ID, 10))
+	if err = s.conn.PublishMsg(msg); err != nil {
+		return err
+	}
+	// сообщение считается принятым, когда сервер подтвердил получение всех отправленных
+	return s.conn.FlushWithContext(ctx)
+}
@@ -0,0 +1,208 @@
+// Package pagination реализует постраничную выдачу списков по курсору.
+//
+// Элементы списка упорядочены по времени (Position.At), а при совпадении
+// времени — по идентификатору (Position.ID) и ключу (Position.Key).
+// Курсор указывает на последний выданный элемент, поэтому вставка новых
+// элементов не сдвигает уже выданные страницы.
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultLimit — размер страницы, если limit не задан
+	DefaultLimit = 100
+	// MaxLimit — наибольший допустимый размер страницы
+	MaxLimit = 1000
+	// NextCursorHeader — заголовок ответа с курсором следующей страницы
+	NextCursorHeader = "X-Next-Cursor"
+)
+
+// Position — положение элемента в упорядоченном списке
+type Position struct {
+	At  time.Time `json:"t"`
+	ID  int64     `json:"i,omitempty"`
+	Key string    `json:"k,omitempty"`
+}
+
+// Compare сравнивает положения: -1, если p раньше q, 1 — если позже, 0 — если совпадают
+func (p Position) Compare(q Position) int {
+	if c := p.At.Compare(q.At); c != 0 {
+		return c
+	}
+	switch {
+	case p.ID < q.ID:
+		return -1
+	case p.ID > q.ID:
+		return 1
+	}
+	return strings.Compare(p.Key, q.Key)
+}
+
+// Cursor — последний выданный элемент и направление сортировки, в котором он выдан
+type Cursor struct {
+	Position
+	Desc bool `json:"d,omitempty"`
+}
+
+// Encode возвращает курсор в виде непрозрачной строки для клиента
+func (c Cursor) Encode() string {
+	b, _ := json.Marshal(c) //nolint
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor разбирает строку, полученную от Encode
+func DecodeCursor(s string) (Cursor, error) {
+	var c Cursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, errors.New("malformed cursor")
+	}
+	if err = json.Unmarshal(b, &c); err != nil {
+		return c, errors.New("malformed cursor")
+	}
+	return c, nil
+}
+
+// Params — параметры запроса страницы
+type Params struct {
+	Limit int
+	// After — курсор предыдущей страницы, nil для первой
+	After *Cursor
+	// Desc — сначала новые элементы
+	Desc bool
+	// From и To ограничивают время элементов: From включительно, To не включительно.
+	// Нулевое значение не ограничивает.
+	From time.Time
+	To   time.Time
+}
+
+// Parse разбирает параметры страницы из строки запроса:
+//   - limit — размер страницы, от 1 до MaxLimit;
+//   - cursor — курсор из заголовка X-Next-Cursor предыдущего ответа;
+//   - sort — field (сначала старые) или -field (сначала новые, по умолчанию);
+//   - from, to — границы времени в формате RFC 3339.
+func Parse(q url.Values, field string) (Params, error) {
+	p := Params{Limit: DefaultLimit, Desc: true}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > MaxLimit {
+			return p, fmt.Errorf("limit must be between 1 and %d", MaxLimit)
+		}
+		p.Limit = limit
+	}
+	switch q.Get("sort") {
+	case "", "-" + field:
+	case field:
+		p.Desc = false
+	default:
+		return p, fmt.Errorf("sort must be %s or -%s", field, field)
+	}
+	for name, t := range map[string]*time.Time{"from": &p.From, "to": &p.To} {
+		if v := q.Get(name); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return p, fmt.Errorf("%s must be an RFC 3339 timestamp", name)
+			}
+			*t = parsed
+		}
+	}
+	if v := q.Get("cursor"); v != "" {
+		c, err := DecodeCursor(v)
+		if err != nil {
+			return p, err
+		}
+		if c.Desc != p.Desc {
+			return p, errors.New("cursor was issued for another sort order")
+		}
+		p.After = &c
+	}
+	return p, nil
+}
+
+// Includes сообщает, попадает ли элемент в диапазон времени и идёт ли он после курсора
+func (p Params) Includes(pos Position) bool {
+	if !p.From.IsZero() && pos.At.Before(p.From) {
+		return false
+	}
+	if !p.To.IsZero() && !pos.At.Before(p.To) {
+		return false
+	}
+	if p.After == nil {
+		return true
+	}
+	c := pos.Compare(p.After.Position)
+	if p.Desc {
+		return c < 0
+	}
+	return c > 0
+}
+
+// Less сообщает, что элемент a выдаётся раньше b
+func (p Params) Less(a, b Position) bool {
+	if p.Desc {
+		return a.Compare(b) > 0
+	}
+	return a.Compare(b) < 0
+}
+
+// Trim оставляет первые Limit элементов уже упорядоченного списка и возвращает
+// курсор следующей страницы или nil, если элементов больше нет.
+// Чтобы узнать о следующей странице, хранилищу достаточно выбрать Limit+1 элемент.
+func Trim[T any](items []T, p Params, pos func(T) Position) ([]T, *Cursor) {
+	if len(items) <= p.Limit {
+		return items, nil
+	}
+	items = items[:p.Limit]
+	return items, &Cursor{Position: pos(items[len(items)-1]), Desc: p.Desc}
+}
+
+// SQL возвращает условия и сортировку для выборки страницы из Postgres:
+// where начинается с AND и дополняет условия запроса, order — ORDER BY и LIMIT.
+// timeCol — столбец времени, tieCol — столбец, различающий элементы с одинаковым временем,
+// tie — значение tieCol в курсоре. Значения параметров добавляются к args.
+func (p Params) SQL(args []any, timeCol, tieCol string, tie func(Position) any) (where string, order string, _ []any) {
+	param := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	if !p.From.IsZero() {
+		where += " AND " + timeCol + " >= " + param(p.From)
+	}
+	if !p.To.IsZero() {
+		where += " AND " + timeCol + " < " + param(p.To)
+	}
+	direction, compare := "ASC", ">"
+	if p.Desc {
+		direction, compare = "DESC", "<"
+	}
+	if p.After != nil {
+		where += " AND (" + timeCol + ", " + tieCol + ") " + compare +
+			" (" + param(p.After.At) + ", " + param(tie(p.After.Position)) + ")"
+	}
+	order = " ORDER BY " + timeCol + " " + direction + ", " + tieCol + " " + direction +
+		" LIMIT " + param(p.Limit+1)
+	return where, order, args
+}
+
+// SetNext сообщает клиенту о следующей странице: курсор в заголовке X-Next-Cursor
+// и ссылку в заголовке Link с теми же параметрами запроса
+func SetNext(w http.ResponseWriter, r *http.Request, next *Cursor) {
+	if next == nil {
+		return
+	}
+	cursor := next.Encode()
+	q := r.URL.Query()
+	q.Set("cursor", cursor)
+	w.Header().Set(NextCursorHeader, cursor)
+	w.Header().Set("Link", "<"+r.URL.Path+"?"+q.Encode()+`>; rel="next"`)
+}
@@ -0,0 +1,114 @@
+package pagination
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	cursor := Cursor{Position: Position{At: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), Key: "12345678903"}, Desc: true}
+	tests := []struct {
+		name    string
+		query   string
+		wantErr bool
+		check   func(p Params) bool
+	}{
+		{"defaults", "", false, func(p Params) bool { return p.Limit == DefaultLimit && p.Desc && p.After == nil }},
+		{"limit", "limit=5", false, func(p Params) bool { return p.Limit == 5 }},
+		{"zero limit", "limit=0", true, nil},
+		{"limit too big", "limit=1001", true, nil},
+		{"ascending", "sort=uploaded_at", false, func(p Params) bool { return !p.Desc }},
+		{"descending", "sort=-uploaded_at", false, func(p Params) bool { return p.Desc }},
+		{"unknown sort", "sort=number", true, nil},
+		{"range", "from=2024-05-01T00:00:00Z&to=2024-06-01T00:00:00%2B03:00", false, func(p Params) bool {
+			return p.From.Equal(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)) && p.To.Equal(time.Date(2024, 5, 31, 21, 0, 0, 0, time.UTC))
+		}},
+		{"bad from", "from=yesterday", true, nil},
+		{"cursor", "cursor=" + cursor.Encode(), false, func(p Params) bool { return p.After != nil && *p.After == cursor }},
+		{"cursor for another sort", "sort=uploaded_at&cursor=" + cursor.Encode(), true, nil},
+		{"malformed cursor", "cursor=!!!", true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err := Parse(q, "uploaded_at")
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !tt.check(p) {
+				t.Errorf("Unexpected params %+v", p)
+			}
+		})
+	}
+}
+
+func TestPages(t *testing.T) {
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	// два элемента с одинаковым временем различаются ключом
+	items := []Position{
+		{At: start, Key: "1"},
+		{At: start.Add(time.Minute), Key: "2"},
+		{At: start.Add(time.Minute), Key: "3"},
+		{At: start.Add(2 * time.Minute), Key: "4"},
+		{At: start.Add(3 * time.Minute), Key: "5"},
+	}
+	for _, desc := range []bool{true, false} {
+		p := Params{Limit: 2, Desc: desc, To: start.Add(3 * time.Minute)}
+		var got []string
+		for page := 0; page < 10; page++ {
+			var matched []Position
+			for _, item := range items {
+				if p.Includes(item) {
+					matched = append(matched, item)
+				}
+			}
+			sort.Slice(matched, func(i, j int) bool { return p.Less(matched[i], matched[j]) })
+			matched, next := Trim(matched, p, func(pos Position) Position { return pos })
+			for _, item := range matched {
+				got = append(got, item.Key)
+			}
+			if next == nil {
+				break
+			}
+			p.After = next
+		}
+		expected := "1234"
+		if desc {
+			expected = "4321"
+		}
+		if joined := strings.Join(got, ""); joined != expected {
+			t.Errorf("desc=%v: expected %s; got %s", desc, expected, joined)
+		}
+	}
+}
+
+func TestSQL(t *testing.T) {
+	p := Params{
+		Limit: 10,
+		Desc:  true,
+		From:  time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		After: &Cursor{Position: Position{At: time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC), ID: 7}, Desc: true},
+	}
+	where, order, args := p.SQL([]any{1}, "t", "id", func(pos Position) any { return pos.ID })
+	if where != " AND t >= $2 AND (t, id) < ($3, $4)" {
+		t.Errorf("Unexpected where %q", where)
+	}
+	if order != " ORDER BY t DESC, id DESC LIMIT $5" {
+		t.Errorf("Unexpected order %q", order)
+	}
+	if len(args) != 5 || args[3] != int64(7) || args[4] != 11 {
+		t.Errorf("Unexpected args %v", args)
+	}
+}
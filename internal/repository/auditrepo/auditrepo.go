@@ -0,0 +1,279 @@
+// Package auditrepo хранит журнал аудита: кто, когда, откуда и с каким результатом
+// входил в сервис, регистрировался, списывал баллы и выполнял действия администратора.
+//
+// Журнал только дополняется. Каждая запись содержит хеш предыдущей записи (PrevHash)
+// и собственный хеш (Hash), вычисленный от PrevHash и содержимого записи, поэтому
+// изменение, удаление или вставка записи задним числом обнаруживаются проверкой
+// цепочки (Verify).
+package auditrepo
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/db/postgres"
+	"github.com/beliaevke/go-musthave-diploma/internal/logger"
+	"github.com/beliaevke/go-musthave-diploma/internal/pagination"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/queries"
+
+	"github.com/jackc/pgx/v5"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// Действия, записываемые в журнал
+const (
+	ActionRegister          = "user.register"
+	ActionLogin             = "user.login"
+	ActionWithdraw          = "balance.withdraw"
+	ActionSetRole           = "admin.user.role"
+	ActionDisable           = "admin.user.disable"
+	ActionEnable            = "admin.user.enable"
+	ActionRequestAdjustment = "admin.adjustment.request"
+	ActionApproveAdjustment = "admin.adjustment.approve"
+	ActionRejectAdjustment  = "admin.adjustment.reject"
+	ActionRequeueOrder      = "admin.order.requeue"
+	ActionClearLockout      = "admin.lockout.clear"
+)
+
+// Результаты действий: выполнено, не выполнено (ошибка данных или состояния),
+// запрещено (не хватает прав или учётная запись заблокирована)
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+	ResultDenied  = "denied"
+)
+
+// Results — все результаты действий
+var Results = []string{ResultSuccess, ResultFailure, ResultDenied}
+
+// GenesisHash — значение PrevHash первой записи журнала
+var GenesisHash = strings.Repeat("0", sha256.Size*2)
+
+// auditLockID — ключ advisory lock Postgres, под которым дописывается цепочка записей
+const auditLockID int64 = 0x6175646974
+
+// Entry — запись журнала аудита. ActorID 0 — действие без учётной записи: неудачный вход
+// или регистрация (ActorRole пуста) либо запрос с токеном ADMIN_TOKEN (ActorRole admin).
+// Target — объект действия: user:<номер>, login:<логин>, ip:<адрес>, order:<номер>, adjustment:<номер>.
+type Entry struct {
+	ID         int64             `db:"auditid" json:"id"`
+	OccurredAt time.Time         `db:"occurredat" json:"occurred_at"`
+	ActorID    int               `db:"actorid" json:"actor_id"`
+	ActorRole  string            `db:"actorrole" json:"actor_role,omitempty"`
+	IP         string            `db:"ip" json:"ip"`
+	UserAgent  string            `db:"useragent" json:"user_agent"`
+	Action     string            `db:"action" json:"action"`
+	Target     string            `db:"target" json:"target,omitempty"`
+	Result     string            `db:"result" json:"result"`
+	Details    map[string]string `db:"details" json:"details,omitempty"`
+	PrevHash   string            `db:"prevhash" json:"prev_hash"`
+	Hash       string            `db:"hash" json:"hash"`
+}
+
+// Position возвращает положение записи в журнале для курсора
+func (e Entry) Position() pagination.Position {
+	return pagination.Position{At: e.OccurredAt, ID: e.ID}
+}
+
+// ComputeHash возвращает хеш записи: SHA-256 (hex) от PrevHash и JSON-представления
+// записи без номера и собственного хеша. Время берётся в UTC, чтобы хеш не зависел
+// от часового пояса, в котором запись прочитана из базы.
+func (e Entry) ComputeHash() string {
+	e.ID = 0
+	e.Hash = ""
+	e.OccurredAt = e.OccurredAt.UTC()
+	payload, _ := json.Marshal(e) //nolint // запись из строк, чисел и времени кодируется без ошибок
+	h := sha256.New()
+	h.Write([]byte(e.PrevHash)) //nolint
+	h.Write(payload)            //nolint
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Chain связывает запись e с предыдущей записью журнала, хеш которой prevHash
+func (e Entry) Chain(prevHash string) Entry {
+	// Postgres хранит время с точностью до микросекунд: хеш вычисляется от сохраняемого значения
+	e.OccurredAt = e.OccurredAt.UTC().Truncate(time.Microsecond)
+	e.PrevHash = prevHash
+	e.Hash = e.ComputeHash()
+	return e
+}
+
+// Filter — условия выборки записей. ActorID nil не ограничивает автора,
+// пустые Action, Target и Result — действие, объект и результат.
+type Filter struct {
+	ActorID *int
+	Action  string
+	Target  string
+	Result  string
+	pagination.Params
+}
+
+// Matches сообщает, подходит ли запись под условия фильтра (без учёта страницы)
+func (f Filter) Matches(e Entry) bool {
+	return (f.ActorID == nil || e.ActorID == *f.ActorID) &&
+		(f.Action == "" || e.Action == f.Action) &&
+		(f.Target == "" || e.Target == f.Target) &&
+		(f.Result == "" || e.Result == f.Result)
+}
+
+// Verification — результат проверки цепочки записей. BrokenID — первая запись,
+// хеш которой не сходится с содержимым или с предыдущей записью (0, если таких нет).
+// LastHash — хеш последней записи: сохранённый вне сервиса, он позволяет
+// обнаружить и удаление записей из конца журнала.
+type Verification struct {
+	Valid    bool   `json:"valid"`
+	Checked  int64  `json:"checked"`
+	BrokenID int64  `json:"broken_id,omitempty"`
+	LastHash string `json:"last_hash"`
+}
+
+// Verifier проверяет цепочку записей, переданных по порядку номеров
+type Verifier struct {
+	v Verification
+}
+
+// NewVerifier возвращает проверку цепочки, начинающейся с первой записи журнала
+func NewVerifier() *Verifier {
+	return &Verifier{v: Verification{Valid: true, LastHash: GenesisHash}}
+}
+
+// Add проверяет следующую запись; false — цепочка нарушена и дальше не проверяется
+func (vr *Verifier) Add(e Entry) bool {
+	if !vr.v.Valid {
+		return false
+	}
+	vr.v.Checked++
+	if e.PrevHash != vr.v.LastHash || e.ComputeHash() != e.Hash {
+		vr.v.Valid = false
+		vr.v.BrokenID = e.ID
+		return false
+	}
+	vr.v.LastHash = e.Hash
+	return true
+}
+
+// Result возвращает результат проверки
+func (vr *Verifier) Result() Verification {
+	return vr.v
+}
+
+// TargetUser, TargetLogin, TargetOrder и TargetAdjustment возвращают обозначения объектов действий
+func TargetUser(userID int) string {
+	return "user:" + strconv.Itoa(userID)
+}
+
+func TargetLogin(login string) string {
+	return "login:" + login
+}
+
+func TargetOrder(number string) string {
+	return "order:" + number
+}
+
+func TargetAdjustment(adjustmentID int64) string {
+	return "adjustment:" + strconv.FormatInt(adjustmentID, 10)
+}
+
+type Audit struct {
+	db *postgres.DB
+}
+
+func NewAudit(db *postgres.DB) *Audit {
+	return &Audit{db: db}
+}
+
+func (a *Audit) Timeout() time.Duration {
+	return a.db.DefaultTimeout
+}
+
+// Record дописывает запись в конец журнала. Записи разных экземпляров сервиса
+// выстраиваются в одну цепочку под advisory lock.
+func (a *Audit) Record(ctx context.Context, e Entry) error {
+	tx, err := a.db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx) //nolint
+	if err = Add(ctx, tx, e); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// Add дописывает запись в конец журнала в транзакции tx: запись о действии фиксируется
+// вместе с самим действием. Advisory lock цепочки удерживается до конца tx.
+func Add(ctx context.Context, tx pgx.Tx, e Entry) error {
+	if _, err := tx.Exec(ctx, queries.AuditLockQuery, auditLockID); err != nil {
+		logger.WarnfCtx(ctx, "Query audit lock: "+err.Error())
+		return err
+	}
+	prevHash := GenesisHash
+	switch err := tx.QueryRow(ctx, queries.LastAuditHashQueryRow).Scan(&prevHash); err {
+	case nil, pgx.ErrNoRows:
+	default:
+		logger.WarnfCtx(ctx, "Query LastAuditHash: "+err.Error())
+		return err
+	}
+	e = e.Chain(prevHash)
+	var details any
+	if len(e.Details) > 0 {
+		details = e.Details
+	}
+	err := tx.QueryRow(ctx, queries.AddAuditInsert, e.OccurredAt, e.ActorID, e.ActorRole, e.IP, e.UserAgent,
+		e.Action, e.Target, e.Result, details, e.PrevHash, e.Hash).Scan(&e.ID)
+	if err != nil {
+		logger.WarnfCtx(ctx, "INSERT INTO audit_log: "+err.Error())
+	}
+	return err
+}
+
+// ListEntries возвращает страницу записей журнала и курсор следующей страницы (nil, если это последняя)
+func (a *Audit) ListEntries(ctx context.Context, f Filter) ([]Entry, *pagination.Cursor, error) {
+	where, order, args := f.SQL([]any{f.ActorID, f.Action, f.Target, f.Result}, "audit_log.occurredat", "audit_log.auditid",
+		func(p pagination.Position) any {
+			return p.ID
+		})
+	rows, err := a.db.Pool.Query(ctx, queries.ListAuditQuery+where+order, args...)
+	if err != nil {
+		logger.WarnfCtx(ctx, "Query ListAudit: "+err.Error())
+		return nil, nil, err
+	}
+	val, err := pgx.CollectRows(rows, pgx.RowToStructByName[Entry])
+	if err != nil {
+		logger.WarnfCtx(ctx, "CollectRows ListAudit: "+err.Error())
+		return nil, nil, err
+	}
+	val, next := pagination.Trim(val, f.Params, Entry.Position)
+	return val, next, nil
+}
+
+// Verify проверяет цепочку записей журнала от первой до последней
+func (a *Audit) Verify(ctx context.Context) (Verification, error) {
+	rows, err := a.db.Pool.Query(ctx, queries.AuditChainQuery)
+	if err != nil {
+		logger.WarnfCtx(ctx, "Query AuditChain: "+err.Error())
+		return Verification{}, err
+	}
+	defer rows.Close()
+	vr := NewVerifier()
+	for rows.Next() {
+		e, err := pgx.RowToStructByName[Entry](rows)
+		if err != nil {
+			logger.WarnfCtx(ctx, "Scan AuditChain: "+err.Error())
+			return Verification{}, err
+		}
+		if !vr.Add(e) {
+			break
+		}
+	}
+	if err = rows.Err(); err != nil {
+		logger.WarnfCtx(ctx, "Query AuditChain: "+err.Error())
+		return Verification{}, err
+	}
+	return vr.Result(), nil
+}
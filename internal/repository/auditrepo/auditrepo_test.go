@@ -0,0 +1,104 @@
+package auditrepo
+
+import (
+	"testing"
+	"time"
+)
+
+func testChain(t *testing.T) []Entry {
+	t.Helper()
+	at := time.Date(2024, 5, 1, 12, 0, 0, 123456789, time.FixedZone("MSK", 3*60*60))
+	entries := []Entry{
+		{ActorID: 1, ActorRole: "user", Action: ActionLogin, Target: TargetUser(1), Result: ResultSuccess},
+		{Action: ActionLogin, Target: TargetLogin("bob"), Result: ResultFailure, Details: map[string]string{"error": "incorrect login or password"}},
+		{ActorID: 1, ActorRole: "user", Action: ActionWithdraw, Target: TargetOrder("2377225624"), Result: ResultSuccess,
+			Details: map[string]string{"sum": "100"}},
+	}
+	prevHash := GenesisHash
+	for i := range entries {
+		entries[i].ID = int64(i) + 1
+		entries[i].OccurredAt = at.Add(time.Duration(i) * time.Second)
+		entries[i] = entries[i].Chain(prevHash)
+		prevHash = entries[i].Hash
+	}
+	return entries
+}
+
+func verify(entries []Entry) Verification {
+	vr := NewVerifier()
+	for _, e := range entries {
+		if !vr.Add(e) {
+			break
+		}
+	}
+	return vr.Result()
+}
+
+func TestVerify(t *testing.T) {
+	testCases := []struct {
+		name     string
+		tamper   func(entries []Entry) []Entry
+		valid    bool
+		brokenID int64
+	}{
+		{
+			name:   "intact chain",
+			tamper: func(entries []Entry) []Entry { return entries },
+			valid:  true,
+		},
+		{
+			name: "time read in another zone",
+			tamper: func(entries []Entry) []Entry {
+				for i := range entries {
+					entries[i].OccurredAt = entries[i].OccurredAt.In(time.FixedZone("UTC-5", -5*60*60))
+				}
+				return entries
+			},
+			valid: true,
+		},
+		{
+			name: "changed result",
+			tamper: func(entries []Entry) []Entry {
+				entries[1].Result = ResultSuccess
+				return entries
+			},
+			brokenID: 2,
+		},
+		{
+			name: "changed details",
+			tamper: func(entries []Entry) []Entry {
+				entries[2].Details["sum"] = "1"
+				return entries
+			},
+			brokenID: 3,
+		},
+		{
+			name: "deleted entry",
+			tamper: func(entries []Entry) []Entry {
+				return append(entries[:1], entries[2:]...)
+			},
+			brokenID: 3,
+		},
+		{
+			name: "rehashed entry",
+			tamper: func(entries []Entry) []Entry {
+				entries[0].ActorID = 2
+				entries[0] = entries[0].Chain(GenesisHash)
+				return entries
+			},
+			brokenID: 2,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			entries := tc.tamper(testChain(t))
+			got := verify(entries)
+			if got.Valid != tc.valid || got.BrokenID != tc.brokenID {
+				t.Fatalf("Verify = %+v, expected valid %v, broken %d", got, tc.valid, tc.brokenID)
+			}
+			if tc.valid && got.LastHash != entries[len(entries)-1].Hash {
+				t.Errorf("LastHash = %s, expected %s", got.LastHash, entries[len(entries)-1].Hash)
+			}
+		})
+	}
+}
@@ -2,10 +2,14 @@ package balancerepo
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/beliaevke/go-musthave-diploma/internal/db/postgres"
 	"github.com/beliaevke/go-musthave-diploma/internal/logger"
+	"github.com/beliaevke/go-musthave-diploma/internal/pagination"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/auditrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/changesrepo"
 	"github.com/beliaevke/go-musthave-diploma/internal/repository/queries"
 
 	"github.com/jackc/pgx/v5"
@@ -27,12 +31,81 @@ type Withdraw struct {
 	Sum         float32 `json:"sum"`
 }
 
+// Operation — запись журнала движения баллов: начисление (PointsQuantity > 0) или списание (< 0).
+// У проведённой корректировки баланса номер заказа пуст, а AdjustmentID — номер корректировки.
+type Operation struct {
+	OrderNumber    string    `db:"ordernumber" json:"order"`
+	PointsQuantity float32   `db:"pointsquantity" json:"sum"`
+	ProcessedAt    time.Time `db:"processedat" json:"processed_at"`
+	AdjustmentID   int64     `db:"adjustmentid" json:"adjustment_id,omitempty"`
+}
+
 type Withdrawals struct {
+	OperationID    int64     `db:"operationid" json:"-"`
 	OrderNumber    string    `db:"ordernumber" json:"order"`
 	PointsQuantity float32   `db:"pointsquantity" json:"sum"`
 	ProcessedAt    time.Time `db:"processedat" json:"processed_at"`
 }
 
+// Статусы запроса на корректировку баланса
+const (
+	AdjustmentPending  = "PENDING"
+	AdjustmentApproved = "APPROVED"
+	AdjustmentRejected = "REJECTED"
+)
+
+// Adjustment — запрос на ручную корректировку баланса: зачисление (Amount > 0)
+// или списание (< 0). Баланс изменяется, когда запрос согласует второй администратор.
+type Adjustment struct {
+	ID     int64   `db:"adjustmentid" json:"id"`
+	UserID int     `db:"userid" json:"user_id"`
+	Amount float32 `db:"amount" json:"amount"`
+	Reason string  `db:"reason" json:"reason"`
+	Status string  `db:"status" json:"status"`
+	// RequestedBy — автор запроса, 0 — запрос с токеном ADMIN_TOKEN
+	RequestedBy int       `db:"requestedby" json:"requested_by"`
+	CreatedAt   time.Time `db:"createdat" json:"created_at"`
+	// ReviewedBy — администратор, согласовавший или отклонивший запрос
+	ReviewedBy    int        `db:"reviewedby" json:"reviewed_by,omitempty"`
+	ReviewedAt    *time.Time `db:"reviewedat" json:"reviewed_at,omitempty"`
+	ReviewComment string     `db:"reviewcomment" json:"review_comment,omitempty"`
+}
+
+// Position возвращает положение запроса на корректировку в списке для курсора
+func (a Adjustment) Position() pagination.Position {
+	return pagination.Position{At: a.CreatedAt, ID: a.ID}
+}
+
+// AdjustmentFilter — отбор запросов на корректировку: пустые поля не ограничивают выборку
+type AdjustmentFilter struct {
+	Status string
+	UserID int
+	pagination.Params
+}
+
+// Review — решение по запросу на корректировку
+type Review struct {
+	Approve    bool
+	ReviewedBy int
+	Comment    string
+	ReviewedAt time.Time
+}
+
+// AuditEntry возвращает запись журнала аудита о запросе на корректировку a; запись
+// добавляется в транзакции, изменившей запрос
+type AuditEntry func(a Adjustment) auditrepo.Entry
+
+var (
+	// ErrInsufficientFunds — после списания или корректировки баланс стал бы отрицательным
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	// ErrAdjustmentNotFound — запрос на корректировку не найден
+	ErrAdjustmentNotFound = errors.New("adjustment not found")
+	// ErrAdjustmentReviewed — по запросу на корректировку уже принято решение
+	ErrAdjustmentReviewed = errors.New("adjustment is already reviewed")
+	// ErrSelfReview — решение по запросу принимает его автор
+	ErrSelfReview = errors.New("adjustment must be reviewed by another admin")
+)
+
 func (b *Balance) Timeout() time.Duration {
 	return b.db.DefaultTimeout
 }
@@ -46,42 +119,225 @@ func (b *Balance) GetBalance(ctx context.Context, userID int) (Balance, error) {
 	case nil:
 		return val, nil
 	case err:
-		logger.Warnf("Query GetBalance: " + err.Error())
+		logger.WarnfCtx(ctx, "Query GetBalance: "+err.Error())
 		return val, err
 	}
 	return val, nil
 }
 
-func (b *Balance) BalanceWithdraw(ctx context.Context, userID int, userBalance Balance, withdraw Withdraw) error {
+// BalanceWithdraw списывает баллы в счёт заказа и возвращает баланс после списания.
+// Запись entry добавляется в журнал аудита в той же транзакции.
+// ErrInsufficientFunds — баллов не хватает, баланс не изменяется.
+func (b *Balance) BalanceWithdraw(ctx context.Context, userID int, withdraw Withdraw, entry auditrepo.Entry) (Balance, error) {
+	var val Balance
 	tx, err := b.db.Pool.Begin(ctx)
 	if err != nil {
-		return err
+		return val, err
 	}
 	defer tx.Rollback(ctx) //nolint
-	_, err = b.db.Pool.Exec(ctx, queries.BalanceWithdrawInsert, userID, withdraw.OrderNumber, -withdraw.Sum, time.Now())
+	// баланс изменяется относительно текущего значения в базе: списание не затирает
+	// начисления и корректировки, проведённые после чтения баланса
+	switch err = tx.QueryRow(ctx, queries.BalanceWithdrawUpdate, withdraw.Sum, userID).Scan(&val.PointsSum, &val.PointsLoss); err {
+	case nil:
+	case pgx.ErrNoRows:
+		return val, ErrInsufficientFunds
+	default:
+		logger.WarnfCtx(ctx, "UPDATE usersbalance--: "+err.Error())
+		return val, err
+	}
+	now := time.Now()
+	_, err = tx.Exec(ctx, queries.BalanceWithdrawInsert, userID, withdraw.OrderNumber, -withdraw.Sum, now)
 	if err != nil {
-		logger.Warnf("INSERT INTO OrdersOperations: " + err.Error())
-		return err
+		logger.WarnfCtx(ctx, "INSERT INTO OrdersOperations: "+err.Error())
+		return val, err
+	}
+	var change changesrepo.Change
+	err = change.BalanceChanged(changesrepo.Balance{
+		UserID:    userID,
+		Order:     withdraw.OrderNumber,
+		Sum:       -withdraw.Sum,
+		Current:   val.PointsSum,
+		Withdrawn: val.PointsLoss,
+		At:        now,
+	})
+	if err != nil {
+		return val, err
+	}
+	if err = change.Write(ctx, tx); err != nil {
+		return val, err
+	}
+	if err = auditrepo.Add(ctx, tx, entry); err != nil {
+		return val, err
 	}
+	return val, tx.Commit(ctx)
+}
 
-	_, err = b.db.Pool.Exec(ctx, queries.BalanceWithdrawUpdate, userBalance.PointsSum-withdraw.Sum, userBalance.PointsLoss+withdraw.Sum, userID)
+// RequestAdjustment сохраняет запрос на корректировку баланса со статусом PENDING
+// и запись entry о нём в журнале аудита
+func (b *Balance) RequestAdjustment(ctx context.Context, a Adjustment, entry AuditEntry) (Adjustment, error) {
+	a.Status = AdjustmentPending
+	tx, err := b.db.Pool.Begin(ctx)
+	if err != nil {
+		return a, err
+	}
+	defer tx.Rollback(ctx) //nolint
+	err = tx.QueryRow(ctx, queries.RequestAdjustmentInsert, a.UserID, a.Amount, a.Reason, a.RequestedBy, a.CreatedAt).Scan(&a.ID)
+	if err != nil {
+		logger.WarnfCtx(ctx, "INSERT INTO balance_adjustments: "+err.Error())
+		return a, err
+	}
+	if err = auditrepo.Add(ctx, tx, entry(a)); err != nil {
+		return a, err
+	}
+	return a, tx.Commit(ctx)
+}
+
+// GetAdjustment возвращает запрос на корректировку; ErrAdjustmentNotFound — запрос не найден
+func (b *Balance) GetAdjustment(ctx context.Context, adjustmentID int64) (Adjustment, error) {
+	return scanAdjustment(ctx, b.db.Pool.QueryRow(ctx, queries.GetAdjustmentQueryRow, adjustmentID))
+}
+
+func scanAdjustment(ctx context.Context, row pgx.Row) (Adjustment, error) {
+	var a Adjustment
+	err := row.Scan(&a.ID, &a.UserID, &a.Amount, &a.Reason, &a.Status, &a.RequestedBy, &a.CreatedAt,
+		&a.ReviewedBy, &a.ReviewedAt, &a.ReviewComment)
+	switch err {
+	case nil:
+		return a, nil
+	case pgx.ErrNoRows:
+		return a, ErrAdjustmentNotFound
+	default:
+		logger.WarnfCtx(ctx, "Query GetAdjustment: "+err.Error())
+		return a, err
+	}
+}
+
+// ListAdjustments возвращает страницу запросов на корректировку
+// и курсор следующей страницы (nil, если это последняя)
+func (b *Balance) ListAdjustments(ctx context.Context, f AdjustmentFilter) ([]Adjustment, *pagination.Cursor, error) {
+	where, order, args := f.SQL([]any{f.Status, f.UserID}, "balance_adjustments.createdat", "balance_adjustments.adjustmentid", func(p pagination.Position) any {
+		return p.ID
+	})
+	rows, err := b.db.Pool.Query(ctx, queries.ListAdjustmentsQuery+where+order, args...)
+	if err != nil {
+		logger.WarnfCtx(ctx, "Query ListAdjustments: "+err.Error())
+		return nil, nil, err
+	}
+	val, err := pgx.CollectRows(rows, pgx.RowToStructByName[Adjustment])
+	if err != nil {
+		logger.WarnfCtx(ctx, "CollectRows ListAdjustments: "+err.Error())
+		return nil, nil, err
+	}
+	val, next := pagination.Trim(val, f.Params, Adjustment.Position)
+	return val, next, nil
+}
+
+// ReviewAdjustment принимает решение по запросу на корректировку. Согласованная корректировка
+// изменяет баланс и записывается в журнал движения баллов. ErrAdjustmentNotFound — запрос
+// не найден, ErrAdjustmentReviewed — решение уже принято, ErrSelfReview — решение принимает
+// автор запроса, ErrInsufficientFunds — баланс стал бы отрицательным (запрос остаётся PENDING).
+// Запись entry о решении добавляется в журнал аудита в той же транзакции.
+func (b *Balance) ReviewAdjustment(ctx context.Context, adjustmentID int64, r Review, entry AuditEntry) (Adjustment, Balance, error) {
+	var val Balance
+	tx, err := b.db.Pool.Begin(ctx)
+	if err != nil {
+		return Adjustment{}, val, err
+	}
+	defer tx.Rollback(ctx) //nolint
+	a, err := scanAdjustment(ctx, tx.QueryRow(ctx, queries.LockAdjustmentQueryRow, adjustmentID))
+	if err != nil {
+		return a, val, err
+	}
+	if a.Status != AdjustmentPending {
+		return a, val, ErrAdjustmentReviewed
+	}
+	if a.RequestedBy == r.ReviewedBy {
+		return a, val, ErrSelfReview
+	}
+	a.Status = AdjustmentRejected
+	if r.Approve {
+		a.Status = AdjustmentApproved
+		switch err = tx.QueryRow(ctx, queries.AdjustBalanceQuery, a.Amount, a.UserID).Scan(&val.PointsSum, &val.PointsLoss); err {
+		case nil:
+		case pgx.ErrNoRows:
+			return a, val, ErrInsufficientFunds
+		default:
+			logger.WarnfCtx(ctx, "UPDATE usersbalance adjust: "+err.Error())
+			return a, val, err
+		}
+		if _, err = tx.Exec(ctx, queries.AdjustmentOperationInsert, a.UserID, a.Amount, r.ReviewedAt, a.ID); err != nil {
+			logger.WarnfCtx(ctx, "INSERT INTO OrdersOperations: "+err.Error())
+			return a, val, err
+		}
+		if err = addAdjustmentEvents(ctx, tx, a, val, r.ReviewedAt); err != nil {
+			return a, val, err
+		}
+	}
+	a.ReviewedBy, a.ReviewedAt, a.ReviewComment = r.ReviewedBy, &r.ReviewedAt, r.Comment
+	if _, err = tx.Exec(ctx, queries.ReviewAdjustmentQuery, a.ID, a.Status, a.ReviewedBy, r.ReviewedAt, a.ReviewComment); err != nil {
+		logger.WarnfCtx(ctx, "UPDATE balance_adjustments: "+err.Error())
+		return a, val, err
+	}
+	if err = auditrepo.Add(ctx, tx, entry(a)); err != nil {
+		return a, val, err
+	}
+	return a, val, tx.Commit(ctx)
+}
+
+// addAdjustmentEvents записывает события о проведённой корректировке для всех получателей
+func addAdjustmentEvents(ctx context.Context, tx pgx.Tx, a Adjustment, val Balance, at time.Time) error {
+	var change changesrepo.Change
+	err := change.BalanceChanged(changesrepo.Balance{
+		UserID:    a.UserID,
+		Sum:       a.Amount,
+		Reason:    a.Reason,
+		Current:   val.PointsSum,
+		Withdrawn: val.PointsLoss,
+		At:        at,
+	})
 	if err != nil {
-		logger.Warnf("UPDATE usersbalance--: " + err.Error())
 		return err
 	}
-	return tx.Commit(ctx)
+	return change.Write(ctx, tx)
 }
 
-func (b *Balance) GetWithdrawals(ctx context.Context, userID int) ([]Withdrawals, error) {
+// Position возвращает положение списания в списке для курсора
+func (w Withdrawals) Position() pagination.Position {
+	return pagination.Position{At: w.ProcessedAt, ID: w.OperationID}
+}
+
+// ListWithdrawals возвращает страницу списаний пользователя по времени
+// и курсор следующей страницы (nil, если это последняя)
+func (b *Balance) ListWithdrawals(ctx context.Context, userID int, p pagination.Params) ([]Withdrawals, *pagination.Cursor, error) {
 	var val []Withdrawals
-	result, err := b.db.Pool.Query(ctx, queries.GetWithdrawalsQuery, userID)
+	where, order, args := p.SQL([]any{userID}, "ordersoperations.processedat", "ordersoperations.operationid", func(p pagination.Position) any {
+		return p.ID
+	})
+	result, err := b.db.Pool.Query(ctx, queries.ListWithdrawalsQuery+where+order, args...)
 	if err != nil {
-		logger.Warnf("Query GetWithdrawals: " + err.Error())
-		return val, err
+		logger.WarnfCtx(ctx, "Query ListWithdrawals: "+err.Error())
+		return val, nil, err
 	}
 	val, err = pgx.CollectRows(result, pgx.RowToStructByName[Withdrawals])
 	if err != nil {
-		logger.Warnf("CollectRows GetWithdrawals: " + err.Error())
+		logger.WarnfCtx(ctx, "CollectRows ListWithdrawals: "+err.Error())
+		return val, nil, err
+	}
+	val, next := pagination.Trim(val, p, Withdrawals.Position)
+	return val, next, nil
+}
+
+// GetOperations возвращает журнал начислений и списаний пользователя
+func (b *Balance) GetOperations(ctx context.Context, userID int) ([]Operation, error) {
+	var val []Operation
+	result, err := b.db.Pool.Query(ctx, queries.GetOperationsQuery, userID)
+	if err != nil {
+		logger.WarnfCtx(ctx, "Query GetOperations: "+err.Error())
+		return val, err
+	}
+	val, err = pgx.CollectRows(result, pgx.RowToStructByName[Operation])
+	if err != nil {
+		logger.WarnfCtx(ctx, "CollectRows GetOperations: "+err.Error())
 		return val, err
 	}
 	return val, nil
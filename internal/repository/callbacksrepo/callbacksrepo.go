@@ -0,0 +1,55 @@
+package callbacksrepo
+
+import (
+	"context"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/db/postgres"
+	"github.com/beliaevke/go-musthave-diploma/internal/logger"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/queries"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+type Callback struct {
+	db *postgres.DB
+}
+
+func NewCallback(db *postgres.DB) *Callback {
+	return &Callback{db: db}
+}
+
+func (c *Callback) Timeout() time.Duration {
+	return c.db.DefaultTimeout
+}
+
+// RememberCallback запоминает подпись принятого уведомления и сообщает,
+// встречалась ли она раньше. Подписи, полученные до expireBefore, удаляются.
+func (c *Callback) RememberCallback(ctx context.Context, signature string, receivedAt time.Time, expireBefore time.Time) (bool, error) {
+	tx, err := c.db.Pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx) //nolint
+	_, err = tx.Exec(ctx, queries.DeleteExpiredCallbacks, expireBefore)
+	if err != nil {
+		logger.WarnfCtx(ctx, "DELETE FROM AccrualCallbacks: "+err.Error())
+		return false, err
+	}
+	tag, err := tx.Exec(ctx, queries.InsertCallback, signature, receivedAt)
+	if err != nil {
+		logger.WarnfCtx(ctx, "INSERT INTO AccrualCallbacks: "+err.Error())
+		return false, err
+	}
+	return tag.RowsAffected() == 0, tx.Commit(ctx)
+}
+
+// ForgetCallback удаляет подпись уведомления, которое не удалось применить,
+// чтобы повтор того же уведомления системой начислений был принят
+func (c *Callback) ForgetCallback(ctx context.Context, signature string) error {
+	_, err := c.db.Pool.Exec(ctx, queries.DeleteCallback, signature)
+	if err != nil {
+		logger.WarnfCtx(ctx, "DELETE FROM AccrualCallbacks: "+err.Error())
+	}
+	return err
+}
@@ -0,0 +1,195 @@
+// Package changesrepo описывает изменения пользователей, заказов и баланса сразу для всех
+// получателей: событий пользователя (поток GET /api/user/events), событий панели
+// администратора, уведомлений вебхуков и доменных событий outbox. Хранилище записывает
+// собранное изменение одним вызовом Write в транзакции самого изменения, поэтому ни один
+// получатель не может быть пропущен.
+package changesrepo
+
+import (
+	"context"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/eventsrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/outboxrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/webhooksrepo"
+
+	"github.com/jackc/pgx/v5"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// UserEvent — событие пользователя для потока GET /api/user/events
+type UserEvent struct {
+	UserID int
+	Type   string
+	Data   any
+	At     time.Time
+}
+
+// Webhook — уведомление, которое получат адреса пользователя, подписанные на его тип
+type Webhook struct {
+	UserID int
+	Event  webhooksrepo.Event
+}
+
+// Change — записи изменений для всех получателей. Записи добавляются методами
+// UserCreated, OrderUploaded, OrderStatusChanged и BalanceChanged.
+type Change struct {
+	UserEvents  []UserEvent
+	AdminEvents []eventsrepo.AdminEvent
+	Webhooks    []Webhook
+	Outbox      []outboxrepo.Event
+}
+
+// OrderStatus — смена статуса заказа
+type OrderStatus struct {
+	UserID int
+	Order  string
+	// From и To — внутренние статусы заказа до и после смены
+	From string
+	To   string
+	// Visible — статус, который видит пользователь; пусто, если для пользователя статус не изменился
+	Visible string
+	// Completed — тип события о завершении расчёта (eventsrepo.AdminOrderProcessed
+	// или eventsrepo.AdminOrderInvalid); пусто, если расчёт не завершён
+	Completed string
+	Accrual   float32
+	Source    string
+	At        time.Time
+}
+
+// Balance — изменение баланса: начисление по заказу (Sum > 0), списание (Sum < 0)
+// или корректировка администратором (Order пуст, Reason — причина)
+type Balance struct {
+	UserID int
+	Order  string
+	Sum    float32
+	Reason string
+	// Current и Withdrawn — баланс после изменения
+	Current   float32
+	Withdrawn float32
+	At        time.Time
+}
+
+// UserCreated добавляет событие о регистрации пользователя
+func (c *Change) UserCreated(userID int, login string, at time.Time) error {
+	event, err := outboxrepo.NewEvent(outboxrepo.TypeUserCreated, userID, outboxrepo.UserCreated{Login: login}, at)
+	if err != nil {
+		return err
+	}
+	c.Outbox = append(c.Outbox, event)
+	return nil
+}
+
+// OrderUploaded добавляет события о загрузке заказа
+func (c *Change) OrderUploaded(userID int, order string, at time.Time) error {
+	event, err := outboxrepo.NewEvent(outboxrepo.TypeOrderUploaded, userID, outboxrepo.OrderUploaded{Order: order}, at)
+	if err != nil {
+		return err
+	}
+	c.Outbox = append(c.Outbox, event)
+	c.AdminEvents = append(c.AdminEvents, eventsrepo.AdminEvent{
+		Type: eventsrepo.AdminOrderUploaded, UserID: userID, Order: order, At: at,
+	})
+	return nil
+}
+
+// OrderStatusChanged добавляет события о смене статуса заказа
+func (c *Change) OrderStatusChanged(s OrderStatus) error {
+	if s.Visible != "" {
+		c.UserEvents = append(c.UserEvents, UserEvent{UserID: s.UserID, Type: eventsrepo.TypeOrder, At: s.At, Data: eventsrepo.OrderData{
+			Number:    s.Order,
+			Status:    s.Visible,
+			Accrual:   s.Accrual,
+			ChangedAt: s.At,
+		}})
+	}
+	if s.From != s.To {
+		event, err := outboxrepo.NewEvent(outboxrepo.TypeOrderStatusChanged, s.UserID, outboxrepo.OrderStatusChanged{
+			Order:   s.Order,
+			From:    s.From,
+			To:      s.To,
+			Accrual: s.Accrual,
+			Source:  s.Source,
+		}, s.At)
+		if err != nil {
+			return err
+		}
+		c.Outbox = append(c.Outbox, event)
+	}
+	if s.Completed != "" {
+		c.AdminEvents = append(c.AdminEvents, eventsrepo.AdminEvent{
+			Type: s.Completed, UserID: s.UserID, Order: s.Order, Accrual: s.Accrual, At: s.At,
+		})
+		c.Webhooks = append(c.Webhooks, Webhook{UserID: s.UserID, Event: webhooksrepo.Event{
+			Type: s.Completed, Order: s.Order, Accrual: s.Accrual, At: s.At,
+		}})
+	}
+	return nil
+}
+
+// BalanceChanged добавляет события об изменении баланса. О начислении по заказу
+// администратор и вебхуки узнают из события о завершении расчёта (OrderStatusChanged).
+func (c *Change) BalanceChanged(b Balance) error {
+	c.UserEvents = append(c.UserEvents, UserEvent{UserID: b.UserID, Type: eventsrepo.TypeBalance, At: b.At, Data: eventsrepo.BalanceData{
+		Current:   b.Current,
+		Withdrawn: b.Withdrawn,
+		Order:     b.Order,
+		Sum:       b.Sum,
+		Reason:    b.Reason,
+		ChangedAt: b.At,
+	}})
+	event, err := outboxrepo.NewEvent(outboxrepo.TypeBalanceChanged, b.UserID, outboxrepo.BalanceChanged{
+		Order:     b.Order,
+		Sum:       b.Sum,
+		Current:   b.Current,
+		Withdrawn: b.Withdrawn,
+		Reason:    b.Reason,
+	}, b.At)
+	if err != nil {
+		return err
+	}
+	c.Outbox = append(c.Outbox, event)
+
+	balance := &webhooksrepo.Balance{Current: b.Current, Withdrawn: b.Withdrawn}
+	switch {
+	case b.Order == "":
+		c.AdminEvents = append(c.AdminEvents, eventsrepo.AdminEvent{
+			Type: eventsrepo.AdminBalanceAdjusted, UserID: b.UserID, Sum: b.Sum, At: b.At,
+		})
+		c.Webhooks = append(c.Webhooks, Webhook{UserID: b.UserID, Event: webhooksrepo.Event{
+			Type: webhooksrepo.EventBalanceAdjusted, Sum: b.Sum, Reason: b.Reason, Balance: balance, At: b.At,
+		}})
+	case b.Sum < 0:
+		c.AdminEvents = append(c.AdminEvents, eventsrepo.AdminEvent{
+			Type: eventsrepo.AdminWithdrawal, UserID: b.UserID, Order: b.Order, Sum: -b.Sum, At: b.At,
+		})
+		c.Webhooks = append(c.Webhooks, Webhook{UserID: b.UserID, Event: webhooksrepo.Event{
+			Type: webhooksrepo.EventWithdrawal, Order: b.Order, Sum: -b.Sum, Balance: balance, At: b.At,
+		}})
+	}
+	return nil
+}
+
+// Write записывает изменение c в транзакции tx. События пользователя одного изменения
+// записываются в порядке добавления, события панели администратора рассылаются после фиксации tx.
+func (c Change) Write(ctx context.Context, tx pgx.Tx) error {
+	for _, e := range c.UserEvents {
+		if err := eventsrepo.Add(ctx, tx, e.UserID, e.Type, e.Data, e.At); err != nil {
+			return err
+		}
+	}
+	if err := outboxrepo.Add(ctx, tx, c.Outbox...); err != nil {
+		return err
+	}
+	if len(c.AdminEvents) > 0 {
+		if err := eventsrepo.Publish(ctx, tx, c.AdminEvents...); err != nil {
+			return err
+		}
+	}
+	for _, w := range c.Webhooks {
+		if err := webhooksrepo.Enqueue(ctx, tx, w.UserID, w.Event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
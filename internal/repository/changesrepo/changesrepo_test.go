@@ -0,0 +1,87 @@
+package changesrepo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/eventsrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/outboxrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/webhooksrepo"
+)
+
+func TestBalanceChanged(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name    string
+		balance Balance
+		admin   string
+		webhook string
+	}{
+		{
+			name:    "accrual",
+			balance: Balance{UserID: 7, Order: "12345678903", Sum: 500, Current: 500, At: at},
+		},
+		{
+			name:    "withdrawal",
+			balance: Balance{UserID: 7, Order: "2377225624", Sum: -40, Current: 460, Withdrawn: 40, At: at},
+			admin:   eventsrepo.AdminWithdrawal,
+			webhook: webhooksrepo.EventWithdrawal,
+		},
+		{
+			name:    "adjustment",
+			balance: Balance{UserID: 7, Sum: -10, Reason: "chargeback", Current: 450, Withdrawn: 40, At: at},
+			admin:   eventsrepo.AdminBalanceAdjusted,
+			webhook: webhooksrepo.EventBalanceAdjusted,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var c Change
+			if err := c.BalanceChanged(tc.balance); err != nil {
+				t.Fatal(err)
+			}
+			if len(c.UserEvents) != 1 || c.UserEvents[0].Type != eventsrepo.TypeBalance {
+				t.Errorf("Expected balance event for the user; got %+v", c.UserEvents)
+			}
+			if len(c.Outbox) != 1 || c.Outbox[0].Type != outboxrepo.TypeBalanceChanged {
+				t.Errorf("Expected balance.changed in outbox; got %+v", c.Outbox)
+			}
+			if tc.admin == "" {
+				if len(c.AdminEvents) != 0 || len(c.Webhooks) != 0 {
+					t.Errorf("Expected accrual to be announced by the order status; got %+v, %+v", c.AdminEvents, c.Webhooks)
+				}
+				return
+			}
+			if len(c.AdminEvents) != 1 || c.AdminEvents[0].Type != tc.admin || c.AdminEvents[0].Sum == 0 {
+				t.Errorf("Expected admin event %s; got %+v", tc.admin, c.AdminEvents)
+			}
+			if len(c.Webhooks) != 1 || c.Webhooks[0].Event.Type != tc.webhook || c.Webhooks[0].Event.Balance == nil ||
+				c.Webhooks[0].Event.Balance.Current != tc.balance.Current {
+				t.Errorf("Expected webhook %s with balance; got %+v", tc.webhook, c.Webhooks)
+			}
+		})
+	}
+}
+
+func TestOrderStatusChanged(t *testing.T) {
+	var c Change
+	err := c.OrderStatusChanged(OrderStatus{
+		UserID: 7, Order: "12345678903", From: "PROCESSING", To: "PROCESSED", Visible: "PROCESSED",
+		Completed: eventsrepo.AdminOrderProcessed, Accrual: 500, Source: "poller", At: time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.UserEvents) != 1 || len(c.Outbox) != 1 || len(c.AdminEvents) != 1 || len(c.Webhooks) != 1 {
+		t.Errorf("Expected completed order in every sink; got %+v", c)
+	}
+
+	// внутренняя смена статуса попадает только в outbox
+	c = Change{}
+	if err = c.OrderStatusChanged(OrderStatus{UserID: 7, Order: "12345678903", From: "PROCESSING", To: "FAILED", At: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.UserEvents) != 0 || len(c.Outbox) != 1 || len(c.AdminEvents) != 0 || len(c.Webhooks) != 0 {
+		t.Errorf("Expected only outbox event; got %+v", c)
+	}
+}
@@ -0,0 +1,205 @@
+// Package eventsrepo хранит события пользователя для потока GET /api/user/events
+// и рассылает события панели администратора. События записываются и отправляются
+// в тех же транзакциях, что и изменения заказов и баланса, поэтому откат изменений
+// отменяет и событие.
+package eventsrepo
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/db/postgres"
+	"github.com/beliaevke/go-musthave-diploma/internal/logger"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/queries"
+
+	"github.com/jackc/pgx/v5"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// Channel — канал уведомлений Postgres, в который после COMMIT приходит номер пользователя с новыми событиями
+const Channel = "user_events"
+
+// Типы событий
+const (
+	TypeOrder   = "order"
+	TypeBalance = "balance"
+)
+
+// Event — событие пользователя; ID возрастает в порядке записи событий пользователя
+type Event struct {
+	ID        int64           `db:"eventid"`
+	UserID    int             `db:"userid"`
+	Type      string          `db:"eventtype"`
+	Data      json.RawMessage `db:"payload"`
+	CreatedAt time.Time       `db:"createdat"`
+}
+
+// OrderData — содержимое события о смене статуса заказа
+type OrderData struct {
+	Number    string    `json:"number"`
+	Status    string    `json:"status"`
+	Accrual   float32   `json:"accrual,omitempty"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// BalanceData — содержимое события об изменении баланса:
+// начисление по заказу (Sum > 0), списание (Sum < 0) или корректировка
+// администратором (Order пуст, Reason — причина)
+type BalanceData struct {
+	Current   float32   `json:"current"`
+	Withdrawn float32   `json:"withdrawn"`
+	Order     string    `json:"order"`
+	Sum       float32   `json:"sum"`
+	Reason    string    `json:"reason,omitempty"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// AdminChannel — канал уведомлений Postgres с событиями для панели администратора
+const AdminChannel = "admin_events"
+
+// Типы событий панели администратора
+const (
+	AdminOrderUploaded  = "order.uploaded"
+	AdminOrderProcessed = "order.processed"
+	AdminOrderInvalid   = "order.invalid"
+	AdminWithdrawal     = "withdrawal"
+	// AdminBalanceAdjusted — проведена корректировка баланса (Order пуст, Sum — сумма корректировки)
+	AdminBalanceAdjusted = "balance.adjusted"
+)
+
+// AdminTypes — все типы событий панели администратора
+var AdminTypes = []string{AdminOrderUploaded, AdminOrderProcessed, AdminOrderInvalid, AdminWithdrawal, AdminBalanceAdjusted}
+
+// AdminEvent — событие по всем пользователям для панели администратора.
+// Такие события не сохраняются: их получают только подключённые в этот момент панели.
+type AdminEvent struct {
+	Type    string    `json:"type"`
+	UserID  int       `json:"user_id"`
+	Order   string    `json:"order"`
+	Accrual float32   `json:"accrual,omitempty"`
+	Sum     float32   `json:"sum,omitempty"`
+	At      time.Time `json:"at"`
+}
+
+type Events struct {
+	db *postgres.DB
+}
+
+func NewEvents(db *postgres.DB) *Events {
+	return &Events{db: db}
+}
+
+func (e *Events) Timeout() time.Duration {
+	return e.db.DefaultTimeout
+}
+
+// Add записывает событие пользователя в транзакции tx.
+// Порядок событий одного пользователя совпадает с порядком фиксации, если tx
+// до вызова заблокировала строку баланса пользователя.
+func Add(ctx context.Context, tx pgx.Tx, userID int, eventType string, data any, createdAt time.Time) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, queries.AddEventInsert, userID, eventType, string(payload), createdAt, Channel)
+	if err != nil {
+		logger.WarnfCtx(ctx, "INSERT INTO user_events: "+err.Error())
+	}
+	return err
+}
+
+// Publish рассылает события панели администратора после фиксации транзакции tx
+func Publish(ctx context.Context, tx pgx.Tx, events ...AdminEvent) error {
+	payloads := make([]string, 0, len(events))
+	for _, e := range events {
+		payload, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		payloads = append(payloads, string(payload))
+	}
+	_, err := tx.Exec(ctx, queries.PublishAdminEventsQuery, AdminChannel, payloads)
+	if err != nil {
+		logger.WarnfCtx(ctx, "NOTIFY "+AdminChannel+": "+err.Error())
+	}
+	return err
+}
+
+// ListEvents возвращает до limit событий пользователя с номером больше afterID
+func (e *Events) ListEvents(ctx context.Context, userID int, afterID int64, limit int) ([]Event, error) {
+	result, err := e.db.Pool.Query(ctx, queries.ListEventsQuery, userID, afterID, limit)
+	if err != nil {
+		logger.WarnfCtx(ctx, "Query ListEvents: "+err.Error())
+		return nil, err
+	}
+	val, err := pgx.CollectRows(result, pgx.RowToStructByName[Event])
+	if err != nil {
+		logger.WarnfCtx(ctx, "CollectRows ListEvents: "+err.Error())
+		return nil, err
+	}
+	return val, nil
+}
+
+// LastEventID возвращает номер последнего события пользователя, 0 — событий не было
+func (e *Events) LastEventID(ctx context.Context, userID int) (int64, error) {
+	var id int64
+	if err := e.db.Pool.QueryRow(ctx, queries.LastEventQueryRow, userID).Scan(&id); err != nil {
+		logger.WarnfCtx(ctx, "Query LastEventID: "+err.Error())
+		return 0, err
+	}
+	return id, nil
+}
+
+// ListenEvents подписывается на номера пользователей с новыми событиями.
+// Уведомления, отправленные при обрыве соединения, теряются. Канал закрывается при отмене ctx.
+func (e *Events) ListenEvents(ctx context.Context) (<-chan int, error) {
+	payloads, err := e.db.Listen(ctx, Channel)
+	if err != nil {
+		return nil, err
+	}
+	users := make(chan int, cap(payloads))
+	go func() {
+		defer close(users)
+		for p := range payloads {
+			userID, err := strconv.Atoi(p)
+			if err != nil {
+				logger.WarnfCtx(ctx, "NOTIFY "+Channel+": "+err.Error())
+				continue
+			}
+			select {
+			case users <- userID:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return users, nil
+}
+
+// ListenAdminEvents подписывается на события панели администратора.
+// Уведомления, отправленные при обрыве соединения, теряются. Канал закрывается при отмене ctx.
+func (e *Events) ListenAdminEvents(ctx context.Context) (<-chan AdminEvent, error) {
+	payloads, err := e.db.Listen(ctx, AdminChannel)
+	if err != nil {
+		return nil, err
+	}
+	events := make(chan AdminEvent, cap(payloads))
+	go func() {
+		defer close(events)
+		for p := range payloads {
+			var event AdminEvent
+			if err := json.Unmarshal([]byte(p), &event); err != nil {
+				logger.WarnfCtx(ctx, "NOTIFY "+AdminChannel+": "+err.Error())
+				continue
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
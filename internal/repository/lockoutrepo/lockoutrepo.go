@@ -0,0 +1,209 @@
+// Package lockoutrepo хранит счётчики неудачных входов по логину и адресу клиента
+// (защита от подбора пароля). После каждой неудачи вход с этим логином или адреса
+// приостанавливается на паузу, растущую вдвое, а после Policy.MaxFailures неудач
+// подряд блокируется на Policy.Lockout. Счётчики хранятся в базе, поэтому
+// действуют на всех репликах сервиса.
+package lockoutrepo
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/db/postgres"
+	"github.com/beliaevke/go-musthave-diploma/internal/logger"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/queries"
+
+	"github.com/jackc/pgx/v5"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// KeyLogin и KeyIP возвращают ключи счётчиков логина и адреса клиента
+func KeyLogin(login string) string {
+	return "login:" + login
+}
+
+func KeyIP(ip string) string {
+	return "ip:" + ip
+}
+
+// Attempts — счётчик неудачных входов. До BlockedUntil вход отклоняется без проверки пароля.
+type Attempts struct {
+	Key           string    `db:"attemptkey" json:"key"`
+	Failures      int       `db:"failures" json:"failures"`
+	LastFailureAt time.Time `db:"lastfailureat" json:"last_failure_at"`
+	BlockedUntil  time.Time `db:"blockeduntil" json:"blocked_until"`
+}
+
+// Policy — правила блокировки. После n-й неудачи вход приостанавливается на DelayBase·2^(n-1),
+// но не больше DelayMax; после MaxFailures неудач — на Lockout. Счётчик обнуляется,
+// если блокировка истекла, а новых неудач не было дольше Lockout.
+type Policy struct {
+	MaxFailures int
+	Lockout     time.Duration
+	DelayBase   time.Duration
+	DelayMax    time.Duration
+}
+
+// Fail возвращает счётчик a после ещё одной неудачи в момент now
+func (p Policy) Fail(a Attempts, now time.Time) Attempts {
+	if !now.Before(a.LastFailureAt.Add(p.Lockout)) && !now.Before(a.BlockedUntil) {
+		a.Failures = 0
+	}
+	a.Failures++
+	a.LastFailureAt = now
+	if a.Failures >= p.MaxFailures {
+		a.BlockedUntil = now.Add(p.Lockout)
+	} else {
+		a.BlockedUntil = now.Add(p.delay(a.Failures))
+	}
+	return a
+}
+
+// delay возвращает паузу после failures неудач подряд
+func (p Policy) delay(failures int) time.Duration {
+	d := p.DelayBase
+	if d <= 0 {
+		// паузы отключены
+		return 0
+	}
+	for i := 1; i < failures; i++ {
+		d *= 2
+		if d <= 0 || d >= p.DelayMax {
+			return p.DelayMax
+		}
+	}
+	return min(d, p.DelayMax)
+}
+
+type Lockouts struct {
+	db *postgres.DB
+}
+
+func NewLockouts(db *postgres.DB) *Lockouts {
+	return &Lockouts{db: db}
+}
+
+func (l *Lockouts) Timeout() time.Duration {
+	return l.db.DefaultTimeout
+}
+
+// Limit — счётчик неудачных входов по ключу Key с правилами Policy
+type Limit struct {
+	Key    string
+	Policy Policy
+	// ResetOnSuccess — удачный вход обнуляет счётчик
+	ResetOnSuccess bool
+}
+
+// Attempt проводит попытку входа: блокирует счётчики limits до конца транзакции, и если
+// вход по ним не приостановлен, вызывает check (проверку пароля). Неудача (check вернул
+// false) учитывается в каждом счётчике, удачный вход обнуляет счётчики с ResetOnSuccess.
+// Параллельные попытки с теми же ключами, в том числе с разных реплик, ждут окончания
+// текущей и видят её результат, поэтому не могут проверить больше паролей, чем допускает
+// Policy. Возвращает время окончания блокировки; нулевое время — check был вызван.
+func (l *Lockouts) Attempt(ctx context.Context, limits []Limit, check func(ctx context.Context) (bool, error)) (time.Time, error) {
+	limits = sortLimits(limits)
+	tx, err := l.db.Pool.Begin(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer tx.Rollback(ctx) //nolint
+	attempts := make([]Attempts, len(limits))
+	for i, limit := range limits {
+		if _, err = tx.Exec(ctx, queries.AddAttemptsInsert, limit.Key, time.Now()); err != nil {
+			logger.WarnfCtx(ctx, "INSERT INTO login_attempts: "+err.Error())
+			return time.Time{}, err
+		}
+		a := &attempts[i]
+		err = tx.QueryRow(ctx, queries.LockAttemptsQueryRow, limit.Key).Scan(&a.Key, &a.Failures, &a.LastFailureAt, &a.BlockedUntil)
+		if err != nil {
+			logger.WarnfCtx(ctx, "Query LockAttempts: "+err.Error())
+			return time.Time{}, err
+		}
+	}
+	// время берётся после блокировки счётчиков: ожидание параллельной попытки не сокращает паузу
+	now := time.Now()
+	if until := BlockedUntil(attempts, now); !until.IsZero() {
+		return until, nil
+	}
+	ok, err := check(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var lockout time.Duration
+	for i, limit := range limits {
+		switch {
+		case !ok:
+			a := limit.Policy.Fail(attempts[i], now)
+			if _, err = tx.Exec(ctx, queries.UpdateAttemptsQuery, a.Key, a.Failures, a.LastFailureAt, a.BlockedUntil); err != nil {
+				logger.WarnfCtx(ctx, "UPDATE login_attempts: "+err.Error())
+				return time.Time{}, err
+			}
+			lockout = max(lockout, limit.Policy.Lockout)
+		case limit.ResetOnSuccess:
+			if _, err = tx.Exec(ctx, queries.DeleteAttemptsQuery, limit.Key); err != nil {
+				logger.WarnfCtx(ctx, "DELETE FROM login_attempts: "+err.Error())
+				return time.Time{}, err
+			}
+		}
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return time.Time{}, err
+	}
+	if lockout > 0 {
+		// устаревшие счётчики удаляются отдельно, чтобы не держать их блокировки в транзакции выше
+		if _, err = l.db.Pool.Exec(ctx, queries.PurgeAttemptsQuery, now.Add(-lockout), now); err != nil {
+			logger.WarnfCtx(ctx, "DELETE FROM login_attempts: "+err.Error())
+		}
+	}
+	return time.Time{}, nil
+}
+
+// sortLimits возвращает копию limits, упорядоченную по ключу: параллельные попытки
+// блокируют счётчики в одном порядке и не взаимоблокируются
+func sortLimits(limits []Limit) []Limit {
+	sorted := slices.Clone(limits)
+	slices.SortFunc(sorted, func(a, b Limit) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	return sorted
+}
+
+// BlockedUntil возвращает наиболее позднее время блокировки среди счётчиков attempts,
+// не истёкшее к now; нулевое время — вход не приостановлен
+func BlockedUntil(attempts []Attempts, now time.Time) time.Time {
+	var until time.Time
+	for _, a := range attempts {
+		if a.BlockedUntil.After(now) && a.BlockedUntil.After(until) {
+			until = a.BlockedUntil
+		}
+	}
+	return until
+}
+
+// ListLockouts возвращает счётчики, вход по которым заблокирован в момент now
+func (l *Lockouts) ListLockouts(ctx context.Context, now time.Time) ([]Attempts, error) {
+	rows, err := l.db.Pool.Query(ctx, queries.ListLockoutsQuery, now)
+	if err != nil {
+		logger.WarnfCtx(ctx, "Query ListLockouts: "+err.Error())
+		return nil, err
+	}
+	val, err := pgx.CollectRows(rows, pgx.RowToStructByName[Attempts])
+	if err != nil {
+		logger.WarnfCtx(ctx, "CollectRows ListLockouts: "+err.Error())
+		return nil, err
+	}
+	return val, nil
+}
+
+// ClearLockout снимает блокировку и обнуляет счётчик ключа key; false — счётчика нет
+func (l *Lockouts) ClearLockout(ctx context.Context, key string) (bool, error) {
+	tag, err := l.db.Pool.Exec(ctx, queries.DeleteAttemptsQuery, key)
+	if err != nil {
+		logger.WarnfCtx(ctx, "DELETE FROM login_attempts: "+err.Error())
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
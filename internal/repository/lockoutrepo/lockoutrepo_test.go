@@ -0,0 +1,39 @@
+package lockoutrepo
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPolicyFail(t *testing.T) {
+	p := Policy{MaxFailures: 4, Lockout: time.Hour, DelayBase: time.Second, DelayMax: 3 * time.Second}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	a := Attempts{Key: KeyLogin("alice")}
+	for i, expected := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, time.Hour} {
+		now = now.Add(time.Minute)
+		a = p.Fail(a, now)
+		if a.Failures != i+1 || a.BlockedUntil != now.Add(expected) {
+			t.Fatalf("failure %d: got %+v, expected blocked for %s", i+1, a, expected)
+		}
+	}
+
+	// новая неудача во время блокировки продлевает её, счётчик не обнуляется
+	now = now.Add(2 * time.Hour)
+	if b := p.Fail(a, a.BlockedUntil.Add(-time.Minute)); b.Failures != 5 {
+		t.Errorf("Expected counter to grow while locked; got %+v", b)
+	}
+	// после блокировки и Lockout без неудач счётчик начинается заново
+	if b := p.Fail(a, now); b.Failures != 1 || b.BlockedUntil != now.Add(time.Second) {
+		t.Errorf("Expected counter reset after lockout; got %+v", b)
+	}
+
+	// без пауз вход блокируется только после MaxFailures неудач
+	p.DelayBase = 0
+	a = Attempts{Key: KeyLogin("bob")}
+	for i := 1; i < p.MaxFailures; i++ {
+		if a = p.Fail(a, now); a.BlockedUntil != now {
+			t.Fatalf("failure %d: expected no pause; got %+v", i, a)
+		}
+	}
+}
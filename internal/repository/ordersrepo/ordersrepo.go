@@ -2,24 +2,68 @@ package ordersrepo
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"strconv"
 	"time"
 
 	"github.com/beliaevke/go-musthave-diploma/internal/db/postgres"
 	"github.com/beliaevke/go-musthave-diploma/internal/logger"
+	"github.com/beliaevke/go-musthave-diploma/internal/pagination"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/changesrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/eventsrepo"
 	"github.com/beliaevke/go-musthave-diploma/internal/repository/queries"
 
 	"github.com/jackc/pgx/v5"
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
+// NewOrdersChannel — канал LISTEN/NOTIFY, в который AddOrder отправляет номера новых заказов
+const NewOrdersChannel = "new_orders"
+
+// Источники изменения статуса заказа в истории
+const (
+	SourceUpload  = "upload"
+	SourcePoller  = "poller"
+	SourceWebhook = "webhook"
+	SourceAdmin   = "admin"
+)
+
+// HistoryEntry — переход заказа в статус Status
+type HistoryEntry struct {
+	Status OrderStatus `db:"status" json:"status"`
+	Source string      `db:"source" json:"source"`
+	// AccrualResponse — ответ системы начислений, на основании которого изменён статус
+	AccrualResponse json.RawMessage `db:"accrualresponse" json:"accrual_response,omitempty"`
+	ChangedAt       time.Time       `db:"changedat" json:"changed_at"`
+}
+
 type Order struct {
+	OrderNumber string      `db:"ordernumber" json:"number"`
+	OrderStatus OrderStatus `db:"orderstatus" json:"status"`
+	Accrual     float32     `db:"accrual" json:"accrual,omitempty"`
+	UploadedAt  time.Time   `db:"uploadedat" json:"uploaded_at"`
+	// Attempts — число неудачных попыток расчёта подряд
+	Attempts int `db:"attempts" json:"-"`
+	db       *postgres.DB
+}
+
+// OrderDetails — заказ со временем последних изменений
+type OrderDetails struct {
+	Order
+	// UpdatedAt — время последней смены статуса
+	UpdatedAt time.Time `json:"updated_at"`
+	// LastCheckedAt — время последнего результата от системы начислений, nil — ещё не было
+	LastCheckedAt *time.Time `json:"last_checked_at,omitempty"`
+}
+
+// FailedOrder — заказ, расчёт которого прекращён после неудачных попыток (статус FAILED)
+type FailedOrder struct {
+	UserID      int       `db:"userid" json:"user_id"`
 	OrderNumber string    `db:"ordernumber" json:"number"`
-	OrderStatus string    `db:"orderstatus" json:"status"`
-	Accrual     float32   `db:"accrual" json:"accrual,omitempty"`
+	Attempts    int       `db:"attempts" json:"attempts"`
+	LastError   string    `db:"lasterror" json:"last_error"`
 	UploadedAt  time.Time `db:"uploadedat" json:"uploaded_at"`
-	db          *postgres.DB
 }
 
 func NewOrder(db *postgres.DB) *Order {
@@ -36,28 +80,131 @@ func (o *Order) AddOrder(ctx context.Context, userID int, orderNumber string) er
 		return err
 	}
 	defer tx.Rollback(ctx) //nolint
-	result := o.db.Pool.QueryRow(ctx, queries.GetOrderQueryRow, orderNumber)
+	result := tx.QueryRow(ctx, queries.GetOrderQueryRow, orderNumber)
 	var val int
 	switch err := result.Scan(&val); err {
 	case pgx.ErrNoRows:
-		_, err = o.db.Pool.Exec(ctx, queries.AddOrderInsert, userID, orderNumber, "NEW", time.Now())
+		now := time.Now()
+		_, err = tx.Exec(ctx, queries.AddOrderInsert, userID, orderNumber, StatusNew, now)
 		if err != nil {
-			logger.Warnf("INSERT INTO Orders: " + err.Error())
+			logger.WarnfCtx(ctx, "INSERT INTO Orders: "+err.Error())
+			return err
+		}
+		if err = addHistory(ctx, tx, orderNumber, StatusNew, SourceUpload, "", now); err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, queries.NotifyNewOrderQuery, NewOrdersChannel, orderNumber)
+		if err != nil {
+			logger.WarnfCtx(ctx, "NOTIFY "+NewOrdersChannel+": "+err.Error())
+			return err
+		}
+		var change changesrepo.Change
+		if err = change.OrderUploaded(userID, orderNumber, now); err != nil {
+			return err
+		}
+		if err = change.Write(ctx, tx); err != nil {
 			return err
 		}
 	case nil:
 		err = errors.New("order already exists, uid: " + strconv.Itoa(val))
 		if err != nil {
-			logger.Warnf("INSERT INTO Orders: " + err.Error())
+			logger.WarnfCtx(ctx, "INSERT INTO Orders: "+err.Error())
 			return err
 		}
 	case err:
-		logger.Warnf("Query AddOrder: " + err.Error())
+		logger.WarnfCtx(ctx, "Query AddOrder: "+err.Error())
 		return err
 	}
 	return tx.Commit(ctx)
 }
 
+// Результаты добавления заказа при пакетной загрузке
+const (
+	// BatchAccepted — заказ принят в обработку
+	BatchAccepted = "accepted"
+	// BatchAlreadyYours — заказ уже загружен этим пользователем
+	BatchAlreadyYours = "already_yours"
+	// BatchConflict — заказ уже загружен другим пользователем
+	BatchConflict = "conflict"
+	// BatchInvalid — неверный номер заказа
+	BatchInvalid = "invalid"
+)
+
+// AddOrders добавляет заказы пользователя одной транзакцией и возвращает результат
+// по каждому номеру: BatchAccepted, BatchAlreadyYours или BatchConflict.
+// Номера должны быть проверены и не повторяться.
+func (o *Order) AddOrders(ctx context.Context, userID int, numbers []string) (map[string]string, error) {
+	tx, err := o.db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx) //nolint
+	now := time.Now()
+	rows, err := tx.Query(ctx, queries.AddOrdersInsert, userID, StatusNew, now, numbers)
+	if err != nil {
+		logger.WarnfCtx(ctx, "INSERT INTO Orders batch: "+err.Error())
+		return nil, err
+	}
+	inserted, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		logger.WarnfCtx(ctx, "INSERT INTO Orders batch: "+err.Error())
+		return nil, err
+	}
+
+	results := make(map[string]string, len(numbers))
+	for _, number := range inserted {
+		results[number] = BatchAccepted
+	}
+	if len(inserted) < len(numbers) {
+		rows, err = tx.Query(ctx, queries.GetOrdersOwnersQuery, numbers)
+		if err != nil {
+			logger.WarnfCtx(ctx, "Query GetOrdersOwners: "+err.Error())
+			return nil, err
+		}
+		var (
+			number string
+			owner  int
+		)
+		_, err = pgx.ForEachRow(rows, []any{&number, &owner}, func() error {
+			if _, ok := results[number]; ok {
+				return nil
+			}
+			results[number] = BatchConflict
+			if owner == userID {
+				results[number] = BatchAlreadyYours
+			}
+			return nil
+		})
+		if err != nil {
+			logger.WarnfCtx(ctx, "Query GetOrdersOwners: "+err.Error())
+			return nil, err
+		}
+	}
+
+	if len(inserted) > 0 {
+		_, err = tx.Exec(ctx, queries.AddHistoryBatchInsert, inserted, StatusNew, SourceUpload, now)
+		if err != nil {
+			logger.WarnfCtx(ctx, "INSERT INTO order_status_history batch: "+err.Error())
+			return nil, err
+		}
+		_, err = tx.Exec(ctx, queries.NotifyNewOrdersQuery, NewOrdersChannel, inserted)
+		if err != nil {
+			logger.WarnfCtx(ctx, "NOTIFY "+NewOrdersChannel+": "+err.Error())
+			return nil, err
+		}
+		var change changesrepo.Change
+		for _, number := range inserted {
+			if err = change.OrderUploaded(userID, number, now); err != nil {
+				return nil, err
+			}
+		}
+		if err = change.Write(ctx, tx); err != nil {
+			return nil, err
+		}
+	}
+	return results, tx.Commit(ctx)
+}
+
 func (o *Order) GetOrder(ctx context.Context, orderNumber string) (int, error) {
 	var val int
 	result := o.db.Pool.QueryRow(ctx, queries.GetOrderQueryRow, orderNumber)
@@ -67,62 +214,288 @@ func (o *Order) GetOrder(ctx context.Context, orderNumber string) (int, error) {
 	case nil:
 		return val, nil
 	case err:
-		logger.Warnf("Query GetOrder: " + err.Error())
+		logger.WarnfCtx(ctx, "Query GetOrder: "+err.Error())
 		return -1, err
 	}
 	return val, nil
 }
 
-func (o *Order) GetOrders(ctx context.Context, userID int) ([]Order, error) {
+// ListFilter — параметры выборки заказов пользователя
+type ListFilter struct {
+	pagination.Params
+	// Statuses — только заказы в этих статусах, пустой — в любых
+	Statuses []OrderStatus
+}
+
+// Position возвращает положение заказа в списке для курсора
+func (o Order) Position() pagination.Position {
+	return pagination.Position{At: o.UploadedAt, Key: o.OrderNumber}
+}
+
+// ListOrders возвращает страницу заказов пользователя по времени загрузки
+// и курсор следующей страницы (nil, если это последняя)
+func (o *Order) ListOrders(ctx context.Context, userID int, f ListFilter) ([]Order, *pagination.Cursor, error) {
 	var val []Order
-	result, err := o.db.Pool.Query(ctx, queries.GetOrdersQueryRow, userID)
+	query, args := queries.ListOrdersQuery, []any{userID}
+	if len(f.Statuses) > 0 {
+		statuses := make([]string, len(f.Statuses))
+		for i, status := range f.Statuses {
+			statuses[i] = string(status)
+		}
+		query += queries.ListOrdersStatusFilter
+		args = append(args, statuses)
+	}
+	where, order, args := f.SQL(args, "orders.uploadedat", "orders.ordernumber", func(p pagination.Position) any {
+		return p.Key
+	})
+	result, err := o.db.Pool.Query(ctx, query+where+order, args...)
 	if err != nil {
-		logger.Warnf("Query GetOrders: " + err.Error())
-		return val, err
+		logger.WarnfCtx(ctx, "Query ListOrders: "+err.Error())
+		return val, nil, err
 	}
 	val, err = pgx.CollectRows(result, pgx.RowToStructByName[Order])
 	if err != nil {
-		logger.Warnf("CollectRows GetOrders: " + err.Error())
-		return val, err
+		logger.WarnfCtx(ctx, "CollectRows ListOrders: "+err.Error())
+		return val, nil, err
 	}
-	return val, nil
+	val, next := pagination.Trim(val, f.Params, Order.Position)
+	return val, next, nil
 }
 
-func GetAwaitOrders(ctx context.Context, db *postgres.DB) ([]Order, error) {
+// GetAwaitOrders возвращает заказы, ожидающие расчёта, время очередной попытки для которых наступило
+func (o *Order) GetAwaitOrders(ctx context.Context) ([]Order, error) {
 	var val []Order
-	result, err := db.Pool.Query(ctx, queries.GetAwaitOrdersQueryRow)
+	result, err := o.db.Pool.Query(ctx, queries.GetAwaitOrdersQueryRow, time.Now())
 	if err != nil {
-		logger.Warnf("Query GetAwaitOrders: " + err.Error())
+		logger.WarnfCtx(ctx, "Query GetAwaitOrders: "+err.Error())
 		return val, err
 	}
 	val, err = pgx.CollectRows(result, pgx.RowToStructByName[Order])
 	if err != nil {
-		logger.Warnf("CollectRows GetAwaitOrders: " + err.Error())
+		logger.WarnfCtx(ctx, "CollectRows GetAwaitOrders: "+err.Error())
 		return val, err
 	}
 	return val, nil
 }
 
-func UpdateOrder(ctx context.Context, db *postgres.DB, orderUID int, o Order) error {
-	tx, err := db.Pool.Begin(ctx)
+// UpdateOrder сохраняет новый статус заказа и начисляет баллы на баланс пользователя.
+// Недопустимый переход (см. CheckTransition) отклоняется с ошибкой ErrIllegalTransition.
+// Повторное получение конечного статуса (опрос и уведомление одновременно) ничего
+// не меняет и не считается ошибкой, поэтому баллы не начисляются дважды.
+// Смена статуса записывается в историю заказа с источником source и ответом системы начислений response.
+func (o *Order) UpdateOrder(ctx context.Context, orderUID int, order Order, source string, response string) error {
+	tx, err := o.db.Pool.Begin(ctx)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback(ctx) //nolint
-	_, err = db.Pool.Exec(ctx, queries.UpdateOrderInsert, orderUID, o.OrderNumber, o.Accrual, time.Now())
+	var status OrderStatus
+	switch err := tx.QueryRow(ctx, queries.LockOrderQueryRow, orderUID, order.OrderNumber).Scan(&status); err {
+	case pgx.ErrNoRows:
+		return nil
+	case nil:
+	default:
+		logger.WarnfCtx(ctx, "SELECT orders FOR UPDATE: "+err.Error())
+		return err
+	}
+	if status.Final() && status == order.OrderStatus {
+		return nil
+	}
+	if err = CheckTransition(status, order.OrderStatus); err != nil {
+		logger.WarnfCtx(ctx, "UPDATE orders: "+err.Error())
+		return err
+	}
+	now := time.Now()
+	_, err = tx.Exec(ctx, queries.UpdateOrderQuery, order.OrderStatus, order.Accrual, orderUID, order.OrderNumber, now)
 	if err != nil {
-		logger.Warnf("INSERT INTO UpdateOrder: " + err.Error())
+		logger.WarnfCtx(ctx, "UPDATE orders: "+err.Error())
+		return err
+	}
+	if status != order.OrderStatus {
+		if err = addHistory(ctx, tx, order.OrderNumber, order.OrderStatus, source, response, now); err != nil {
+			return err
+		}
+	}
+	if order.Accrual != 0 {
+		_, err = tx.Exec(ctx, queries.UpdateOrderInsert, orderUID, order.OrderNumber, order.Accrual, now)
+		if err != nil {
+			logger.WarnfCtx(ctx, "INSERT INTO UpdateOrder: "+err.Error())
+			return err
+		}
+	}
+	// строка баланса остаётся заблокированной до конца транзакции,
+	// поэтому события пользователя записываются в порядке фиксации
+	var balance changesrepo.Balance
+	err = tx.QueryRow(ctx, queries.UpdateBalanceQuery, order.Accrual, orderUID).Scan(&balance.Current, &balance.Withdrawn)
+	hasBalance := err == nil
+	if err != nil && err != pgx.ErrNoRows {
+		logger.WarnfCtx(ctx, "UPDATE usersbalance++: "+err.Error())
+		return err
+	}
+	var change changesrepo.Change
+	if err = change.OrderStatusChanged(StatusChange(status, orderUID, order, source, now)); err != nil {
+		return err
+	}
+	if hasBalance && order.Accrual != 0 {
+		balance.UserID, balance.Order, balance.Sum, balance.At = orderUID, order.OrderNumber, order.Accrual, now
+		if err = change.BalanceChanged(balance); err != nil {
+			return err
+		}
+	}
+	if err = change.Write(ctx, tx); err != nil {
 		return err
 	}
-	_, err = db.Pool.Exec(ctx, queries.UpdateOrderQuery, o.OrderStatus, o.Accrual, time.Now(), orderUID, o.OrderNumber)
+	return tx.Commit(ctx)
+}
+
+// StatusChange возвращает описание смены статуса заказа с from на статус order
+// для событий пользователя, панели администратора, вебхуков и outbox
+func StatusChange(from OrderStatus, userID int, order Order, source string, at time.Time) changesrepo.OrderStatus {
+	change := changesrepo.OrderStatus{
+		UserID:  userID,
+		Order:   order.OrderNumber,
+		From:    string(from),
+		To:      string(order.OrderStatus),
+		Accrual: order.Accrual,
+		Source:  source,
+		At:      at,
+	}
+	if from.UserVisible() != order.OrderStatus.UserVisible() {
+		change.Visible = string(order.OrderStatus.UserVisible())
+	}
+	if from != order.OrderStatus {
+		switch order.OrderStatus {
+		case StatusProcessed:
+			change.Completed = eventsrepo.AdminOrderProcessed
+		case StatusInvalid:
+			change.Completed = eventsrepo.AdminOrderInvalid
+		}
+	}
+	return change
+}
+
+// RetryOrder откладывает следующую попытку расчёта по заказу до nextAttemptAt
+func (o *Order) RetryOrder(ctx context.Context, orderNumber string, attempts int, nextAttemptAt time.Time, lastError string) error {
+	_, err := o.db.Pool.Exec(ctx, queries.RetryOrderQuery, attempts, nextAttemptAt, lastError, orderNumber, time.Now())
+	if err != nil {
+		logger.WarnfCtx(ctx, "UPDATE orders retry: "+err.Error())
+	}
+	return err
+}
+
+// FailOrder прекращает попытки расчёта по заказу и переводит его в статус FAILED
+func (o *Order) FailOrder(ctx context.Context, orderNumber string, attempts int, lastError string) error {
+	tx, err := o.db.Pool.Begin(ctx)
 	if err != nil {
-		logger.Warnf("UPDATE orders: " + err.Error())
 		return err
 	}
-	_, err = db.Pool.Exec(ctx, queries.UpdateBalanceQuery, o.Accrual, orderUID)
+	defer tx.Rollback(ctx) //nolint
+	now := time.Now()
+	tag, err := tx.Exec(ctx, queries.FailOrderQuery, attempts, lastError, orderNumber, now)
 	if err != nil {
-		logger.Warnf("UPDATE usersbalance++: " + err.Error())
+		logger.WarnfCtx(ctx, "UPDATE orders failed: "+err.Error())
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil
+	}
+	if err = addHistory(ctx, tx, orderNumber, StatusFailed, SourcePoller, "", now); err != nil {
 		return err
 	}
 	return tx.Commit(ctx)
 }
+
+// GetFailedOrders возвращает заказы в статусе FAILED
+func (o *Order) GetFailedOrders(ctx context.Context) ([]FailedOrder, error) {
+	var val []FailedOrder
+	result, err := o.db.Pool.Query(ctx, queries.GetFailedOrdersQueryRow)
+	if err != nil {
+		logger.WarnfCtx(ctx, "Query GetFailedOrders: "+err.Error())
+		return val, err
+	}
+	val, err = pgx.CollectRows(result, pgx.RowToStructByName[FailedOrder])
+	if err != nil {
+		logger.WarnfCtx(ctx, "CollectRows GetFailedOrders: "+err.Error())
+		return val, err
+	}
+	return val, nil
+}
+
+// RequeueOrder возвращает заказ из статуса FAILED на расчёт со сброшенным счётчиком попыток.
+// Возвращает false, если заказ не найден или не находится в статусе FAILED.
+func (o *Order) RequeueOrder(ctx context.Context, orderNumber string) (bool, error) {
+	tx, err := o.db.Pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx) //nolint
+	tag, err := tx.Exec(ctx, queries.RequeueOrderQuery, orderNumber)
+	if err != nil {
+		logger.WarnfCtx(ctx, "UPDATE orders requeue: "+err.Error())
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+	if err = addHistory(ctx, tx, orderNumber, StatusNew, SourceAdmin, "", time.Now()); err != nil {
+		return false, err
+	}
+	_, err = tx.Exec(ctx, queries.NotifyNewOrderQuery, NewOrdersChannel, orderNumber)
+	if err != nil {
+		logger.WarnfCtx(ctx, "NOTIFY "+NewOrdersChannel+": "+err.Error())
+		return false, err
+	}
+	return true, tx.Commit(ctx)
+}
+
+// GetOrderDetails возвращает владельца заказа и его состояние со временем
+// последних изменений; -1, если заказ не найден
+func (o *Order) GetOrderDetails(ctx context.Context, orderNumber string) (int, OrderDetails, error) {
+	var (
+		userID int
+		val    OrderDetails
+	)
+	err := o.db.Pool.QueryRow(ctx, queries.GetOrderDetailsQueryRow, orderNumber).
+		Scan(&userID, &val.OrderNumber, &val.OrderStatus, &val.Accrual, &val.UploadedAt, &val.Attempts,
+			&val.LastCheckedAt, &val.UpdatedAt)
+	switch err {
+	case pgx.ErrNoRows:
+		return -1, val, nil
+	case nil:
+		return userID, val, nil
+	}
+	logger.WarnfCtx(ctx, "Query GetOrderDetails: "+err.Error())
+	return -1, val, err
+}
+
+// GetOrderHistory возвращает переходы заказа между статусами в хронологическом порядке
+func (o *Order) GetOrderHistory(ctx context.Context, orderNumber string) ([]HistoryEntry, error) {
+	var val []HistoryEntry
+	result, err := o.db.Pool.Query(ctx, queries.GetHistoryQueryRow, orderNumber)
+	if err != nil {
+		logger.WarnfCtx(ctx, "Query GetOrderHistory: "+err.Error())
+		return val, err
+	}
+	val, err = pgx.CollectRows(result, pgx.RowToStructByName[HistoryEntry])
+	if err != nil {
+		logger.WarnfCtx(ctx, "CollectRows GetOrderHistory: "+err.Error())
+		return val, err
+	}
+	return val, nil
+}
+
+// addHistory записывает переход заказа в статус status
+func addHistory(ctx context.Context, tx pgx.Tx, orderNumber string, status OrderStatus, source, response string, changedAt time.Time) error {
+	_, err := tx.Exec(ctx, queries.AddHistoryInsert, orderNumber, status, source, response, changedAt)
+	if err != nil {
+		logger.WarnfCtx(ctx, "INSERT INTO order_status_history: "+err.Error())
+	}
+	return err
+}
+
+// ListenNewOrders подписывается на номера новых заказов на отдельном соединении с базой.
+// При потере соединения подписка восстанавливается; уведомления, отправленные в это время,
+// теряются, такие заказы найдёт периодический опрос. Канал закрывается при отмене ctx.
+func (o *Order) ListenNewOrders(ctx context.Context) (<-chan string, error) {
+	return o.db.Listen(ctx, NewOrdersChannel)
+}
@@ -0,0 +1,93 @@
+package ordersrepo
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/accrual"
+)
+
+// OrderStatus — статус заказа в сервисе.
+// Допустимые значения также ограничены в базе (orders_orderstatus_check).
+type OrderStatus string
+
+const (
+	// StatusNew — заказ загружен, но ещё не попал в обработку
+	StatusNew OrderStatus = "NEW"
+	// StatusProcessing — вознаграждение за заказ рассчитывается
+	StatusProcessing OrderStatus = "PROCESSING"
+	// StatusInvalid — система расчёта вознаграждений отказала в расчёте
+	StatusInvalid OrderStatus = "INVALID"
+	// StatusProcessed — данные по заказу проверены и информация о расчёте успешно получена
+	StatusProcessed OrderStatus = "PROCESSED"
+	// StatusFailed — попытки расчёта прекращены после неудач, заказ ждёт решения администратора
+	StatusFailed OrderStatus = "FAILED"
+)
+
+// transitions — допустимые переходы между статусами.
+// Сохранение того же статуса переходом не считается и разрешено для незавершённых заказов.
+var transitions = map[OrderStatus][]OrderStatus{
+	StatusNew:        {StatusProcessing, StatusInvalid, StatusProcessed, StatusFailed},
+	StatusProcessing: {StatusInvalid, StatusProcessed, StatusFailed},
+	// результат расчёта, полученный после прекращения попыток, принимается
+	StatusFailed:    {StatusNew, StatusProcessing, StatusInvalid, StatusProcessed},
+	StatusInvalid:   {},
+	StatusProcessed: {},
+}
+
+// ErrIllegalTransition — переход между статусами заказа не разрешён
+var ErrIllegalTransition = errors.New("illegal order status transition")
+
+// Valid сообщает, является ли s известным статусом заказа
+func (s OrderStatus) Valid() bool {
+	_, ok := transitions[s]
+	return ok
+}
+
+// Final сообщает, что расчёт по заказу окончен и статус больше не меняется
+func (s OrderStatus) Final() bool {
+	return s == StatusInvalid || s == StatusProcessed
+}
+
+// Pending сообщает, что заказ ожидает очередной попытки расчёта
+func (s OrderStatus) Pending() bool {
+	return s == StatusNew || s == StatusProcessing
+}
+
+// UserVisible возвращает статус, который видит пользователь:
+// заказ, расчёт которого приостановлен (FAILED), для него остаётся в обработке
+func (s OrderStatus) UserVisible() OrderStatus {
+	if s == StatusFailed {
+		return StatusProcessing
+	}
+	return s
+}
+
+// CheckTransition проверяет, можно ли перевести заказ из статуса from в статус to.
+// Возвращает ошибку, совместимую с ErrIllegalTransition.
+func CheckTransition(from, to OrderStatus) error {
+	if !to.Valid() {
+		return fmt.Errorf("%w: unknown status %q", ErrIllegalTransition, to)
+	}
+	if from == to && !from.Final() {
+		return nil
+	}
+	for _, allowed := range transitions[from] {
+		if allowed == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s -> %s", ErrIllegalTransition, from, to)
+}
+
+// StatusFromAccrual возвращает статус заказа по результату системы расчёта.
+// Заказ, неизвестный системе расчёта, не будет рассчитан и считается недействительным.
+func StatusFromAccrual(s accrual.Status) OrderStatus {
+	switch s {
+	case accrual.Registered, accrual.Processing:
+		return StatusProcessing
+	case accrual.Processed:
+		return StatusProcessed
+	}
+	return StatusInvalid
+}
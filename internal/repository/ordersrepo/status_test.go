@@ -0,0 +1,52 @@
+package ordersrepo
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/accrual"
+)
+
+func TestCheckTransition(t *testing.T) {
+	tests := []struct {
+		from, to OrderStatus
+		allowed  bool
+	}{
+		{StatusNew, StatusProcessing, true},
+		{StatusNew, StatusProcessed, true},
+		{StatusNew, StatusFailed, true},
+		{StatusProcessing, StatusProcessing, true},
+		{StatusProcessing, StatusInvalid, true},
+		{StatusProcessing, StatusNew, false},
+		{StatusFailed, StatusNew, true},
+		{StatusFailed, StatusProcessed, true},
+		{StatusProcessed, StatusProcessing, false},
+		{StatusProcessed, StatusProcessed, false},
+		{StatusInvalid, StatusProcessed, false},
+		{StatusNew, OrderStatus("DONE"), false},
+	}
+	for _, tt := range tests {
+		err := CheckTransition(tt.from, tt.to)
+		if tt.allowed && err != nil {
+			t.Errorf("%s -> %s: unexpected error %v", tt.from, tt.to, err)
+		}
+		if !tt.allowed && !errors.Is(err, ErrIllegalTransition) {
+			t.Errorf("%s -> %s: expected ErrIllegalTransition; got %v", tt.from, tt.to, err)
+		}
+	}
+}
+
+func TestStatusFromAccrual(t *testing.T) {
+	tests := map[accrual.Status]OrderStatus{
+		accrual.NotRegistered: StatusInvalid,
+		accrual.Registered:    StatusProcessing,
+		accrual.Processing:    StatusProcessing,
+		accrual.Invalid:       StatusInvalid,
+		accrual.Processed:     StatusProcessed,
+	}
+	for in, expected := range tests {
+		if got := StatusFromAccrual(in); got != expected {
+			t.Errorf("%s: expected %s; got %s", in, expected, got)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// Package outboxrepo хранит исходящие доменные события (transactional outbox).
+// События записываются в тех же транзакциях, что и изменения пользователей,
+// заказов и баланса, поэтому откаченные изменения событий не порождают,
+// а зафиксированные не теряются: их передаёт получателям outbox.Relay.
+package outboxrepo
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/db/postgres"
+	"github.com/beliaevke/go-musthave-diploma/internal/logger"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/queries"
+
+	"github.com/jackc/pgx/v5"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// Типы доменных событий
+const (
+	TypeUserCreated        = "user.created"
+	TypeOrderUploaded      = "order.uploaded"
+	TypeOrderStatusChanged = "order.status_changed"
+	TypeBalanceChanged     = "balance.changed"
+)
+
+// relayLockID — ключ advisory lock Postgres, под которым события передаёт один экземпляр сервиса
+const relayLockID int64 = 0x6f7574626f78
+
+// Event — доменное событие. ID возрастает в порядке записи событий;
+// события одного пользователя записываются в порядке фиксации изменений.
+type Event struct {
+	ID        int64           `db:"eventid" json:"id"`
+	Type      string          `db:"eventtype" json:"type"`
+	UserID    int             `db:"userid" json:"user_id"`
+	Payload   json.RawMessage `db:"payload" json:"payload"`
+	CreatedAt time.Time       `db:"createdat" json:"created_at"`
+}
+
+// UserCreated — содержимое события о регистрации пользователя
+type UserCreated struct {
+	Login string `json:"login"`
+}
+
+// OrderUploaded — содержимое события о загрузке заказа
+type OrderUploaded struct {
+	Order string `json:"order"`
+}
+
+// OrderStatusChanged — содержимое события о смене статуса заказа (внутренние статусы, включая FAILED)
+type OrderStatusChanged struct {
+	Order   string  `json:"order"`
+	From    string  `json:"from"`
+	To      string  `json:"to"`
+	Accrual float32 `json:"accrual,omitempty"`
+	Source  string  `json:"source"`
+}
+
+// BalanceChanged — содержимое события об изменении баланса: начисление по заказу (Sum > 0),
+// списание (Sum < 0) или корректировка администратором (Order пуст, Reason — причина)
+type BalanceChanged struct {
+	Order     string  `json:"order"`
+	Sum       float32 `json:"sum"`
+	Current   float32 `json:"current"`
+	Withdrawn float32 `json:"withdrawn"`
+	Reason    string  `json:"reason,omitempty"`
+}
+
+// NewEvent возвращает событие с содержимым data
+func NewEvent(eventType string, userID int, data any, createdAt time.Time) (Event, error) {
+	payload, err := json.Marshal(data)
+	return Event{Type: eventType, UserID: userID, Payload: payload, CreatedAt: createdAt}, err
+}
+
+type Outbox struct {
+	db *postgres.DB
+}
+
+func NewOutbox(db *postgres.DB) *Outbox {
+	return &Outbox{db: db}
+}
+
+func (ob *Outbox) Timeout() time.Duration {
+	return ob.db.DefaultTimeout
+}
+
+// Add записывает события в транзакции tx в порядке перечисления
+func Add(ctx context.Context, tx pgx.Tx, events ...Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	var (
+		types     = make([]string, 0, len(events))
+		users     = make([]int, 0, len(events))
+		payloads  = make([]string, 0, len(events))
+		createdAt = make([]time.Time, 0, len(events))
+	)
+	for _, e := range events {
+		types = append(types, e.Type)
+		users = append(users, e.UserID)
+		payloads = append(payloads, string(e.Payload))
+		createdAt = append(createdAt, e.CreatedAt)
+	}
+	_, err := tx.Exec(ctx, queries.AddOutboxInsert, types, users, payloads, createdAt)
+	if err != nil {
+		logger.WarnfCtx(ctx, "INSERT INTO outbox: "+err.Error())
+	}
+	return err
+}
+
+// Relay передаёт publish до limit неопубликованных событий по порядку номеров и отмечает
+// переданные опубликованными. На первой ошибке publish передача останавливается,
+// а ошибка возвращается вместе с числом переданных событий. Если события уже передаёт
+// другой экземпляр сервиса, Relay ничего не делает.
+func (ob *Outbox) Relay(ctx context.Context, limit int, publish func(ctx context.Context, e Event) error) (int, error) {
+	tx, err := ob.db.Pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx) //nolint
+	var locked bool
+	if err = tx.QueryRow(ctx, queries.OutboxLockQueryRow, relayLockID).Scan(&locked); err != nil {
+		logger.WarnfCtx(ctx, "Query outbox lock: "+err.Error())
+		return 0, err
+	}
+	if !locked {
+		return 0, nil
+	}
+	rows, err := tx.Query(ctx, queries.UnpublishedEventsQuery, limit)
+	if err != nil {
+		logger.WarnfCtx(ctx, "Query UnpublishedEvents: "+err.Error())
+		return 0, err
+	}
+	events, err := pgx.CollectRows(rows, pgx.RowToStructByName[Event])
+	if err != nil {
+		logger.WarnfCtx(ctx, "CollectRows UnpublishedEvents: "+err.Error())
+		return 0, err
+	}
+
+	published := make([]int64, 0, len(events))
+	var publishErr error
+	for _, e := range events {
+		if publishErr = publish(ctx, e); publishErr != nil {
+			break
+		}
+		published = append(published, e.ID)
+	}
+	if len(published) > 0 {
+		if _, err = tx.Exec(ctx, queries.MarkPublishedQuery, published, time.Now()); err != nil {
+			logger.WarnfCtx(ctx, "UPDATE outbox published: "+err.Error())
+			return 0, err
+		}
+		if err = tx.Commit(ctx); err != nil {
+			// события уже переданы и будут переданы повторно
+			return 0, err
+		}
+	}
+	return len(published), publishErr
+}
+
+// Purge удаляет события, опубликованные раньше before
+func (ob *Outbox) Purge(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := ob.db.Pool.Exec(ctx, queries.PurgeOutboxQuery, before)
+	if err != nil {
+		logger.WarnfCtx(ctx, "DELETE FROM outbox: "+err.Error())
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -18,22 +18,95 @@ const BalanceWithdrawInsert = `
 		($1, $2, $3, $4)
 	`
 
+// BalanceWithdrawUpdate не изменяет баланс, если баллов не хватает
 const BalanceWithdrawUpdate = `
 		UPDATE public.usersbalance
-		SET pointssum=$1, pointsloss=$2
-		WHERE userID=$3;
+		SET pointssum=pointssum-$1, pointsloss=pointsloss+$1
+		WHERE userID=$2 AND pointssum>=$1
+		RETURNING pointssum, pointsloss
 	`
 
-const GetWithdrawalsQuery = `
-		SELECT orderNumber, -pointsQuantity as pointsQuantity, processedAt
+// ListWithdrawalsQuery дополняется условиями и сортировкой страницы (pagination.Params.SQL)
+const ListWithdrawalsQuery = `
+		SELECT operationID, orderNumber, -pointsQuantity as pointsQuantity, processedAt
 		FROM
 			public.ordersoperations
 		WHERE
 			ordersoperations.userID=$1 AND ordersoperations.pointsQuantity < 0
+			AND ordersoperations.adjustmentID IS NULL
+	`
+
+const GetOperationsQuery = `
+		SELECT orderNumber, pointsQuantity, processedAt, COALESCE(adjustmentID, 0) AS adjustmentid
+		FROM
+			public.ordersoperations
+		WHERE
+			ordersoperations.userID=$1
 		ORDER BY
 			ordersoperations.processedAt DESC
 	`
 
+// AdjustBalanceQuery не изменяет баланс, если он стал бы отрицательным
+const AdjustBalanceQuery = `
+		UPDATE public.usersbalance
+		SET pointssum = pointssum + $1
+		WHERE userID=$2 AND pointssum + $1 >= 0
+		RETURNING pointssum, pointsloss
+	`
+
+const RequestAdjustmentInsert = `
+		INSERT INTO public.balance_adjustments
+		(userID, amount, reason, requestedBy, createdAt, status)
+		VALUES
+		($1, $2, $3, NULLIF($4, 0), $5, 'PENDING')
+		RETURNING adjustmentID;
+	`
+
+const GetAdjustmentQueryRow = `
+		SELECT adjustmentID, userID, amount, reason, status, COALESCE(requestedBy, 0) AS requestedby, createdAt,
+			COALESCE(reviewedBy, 0) AS reviewedby, reviewedAt, reviewComment
+		FROM
+			public.balance_adjustments
+		WHERE
+			balance_adjustments.adjustmentID=$1
+	`
+
+// LockAdjustmentQueryRow блокирует запрос на корректировку до конца транзакции согласования
+const LockAdjustmentQueryRow = `
+		SELECT adjustmentID, userID, amount, reason, status, COALESCE(requestedBy, 0) AS requestedby, createdAt,
+			COALESCE(reviewedBy, 0) AS reviewedby, reviewedAt, reviewComment
+		FROM
+			public.balance_adjustments
+		WHERE
+			balance_adjustments.adjustmentID=$1
+		FOR UPDATE
+	`
+
+// ListAdjustmentsQuery дополняется условиями и сортировкой страницы (pagination.Params.SQL);
+// пустой статус ($1) и нулевой пользователь ($2) не ограничивают выборку
+const ListAdjustmentsQuery = `
+		SELECT adjustmentID, userID, amount, reason, status, COALESCE(requestedBy, 0) AS requestedby, createdAt,
+			COALESCE(reviewedBy, 0) AS reviewedby, reviewedAt, reviewComment
+		FROM
+			public.balance_adjustments
+		WHERE
+			($1 = '' OR balance_adjustments.status = $1)
+			AND ($2 = 0 OR balance_adjustments.userID = $2)
+	`
+
+const ReviewAdjustmentQuery = `
+		UPDATE public.balance_adjustments
+		SET status=$2, reviewedBy=NULLIF($3, 0), reviewedAt=$4, reviewComment=$5
+		WHERE adjustmentID=$1;
+	`
+
+const AdjustmentOperationInsert = `
+		INSERT INTO public.ordersoperations
+		(userID, orderNumber, pointsQuantity, processedAt, adjustmentID)
+		VALUES
+		($1, '', $2, $3, $4)
+	`
+
 ////////////////////////////////////////
 // ordersrepo
 
@@ -52,26 +125,92 @@ const AddOrderInsert = `
 		($1, $2, $3, $4);
 	`
 
-const GetOrdersQueryRow = `
-		SELECT ordernumber, orderstatus, accrual, uploadedat
+// AddOrdersInsert добавляет несколько заказов и возвращает номера добавленных;
+// номера, уже загруженные кем-либо, пропускаются
+const AddOrdersInsert = `
+		INSERT INTO public.orders
+		(userID, ordernumber, orderstatus, uploadedat)
+		SELECT $1, number, $2, $3 FROM unnest($4::text[]) AS number
+		ON CONFLICT (ordernumber) DO NOTHING
+		RETURNING ordernumber
+	`
+
+const GetOrdersOwnersQuery = `
+		SELECT orders.ordernumber, orders.userID
+		FROM
+			public.orders
+		WHERE
+			orders.ordernumber = ANY($1)
+	`
+
+const AddHistoryBatchInsert = `
+		INSERT INTO public.order_status_history
+		(orderNumber, status, source, changedAt)
+		SELECT number, $2, $3, $4 FROM unnest($1::text[]) AS number
+	`
+
+const NotifyNewOrdersQuery = `
+		SELECT pg_notify($1, number) FROM unnest($2::text[]) AS number
+	`
+
+// NotifyNewOrderQuery сообщает слушателям канала о новом заказе.
+// Внутри транзакции уведомление доставляется только после COMMIT.
+const NotifyNewOrderQuery = `
+		SELECT pg_notify($1, $2)
+	`
+
+// ListOrdersQuery дополняется фильтром по статусам и условиями страницы (pagination.Params.SQL)
+const ListOrdersQuery = `
+		SELECT ordernumber, orderstatus, accrual, uploadedat, attempts
 		FROM
 			public.orders
 		WHERE
 			orders.userID=$1
-		ORDER BY
-			orders.uploadedat DESC
 	`
 
+const ListOrdersStatusFilter = ` AND orders.orderstatus = ANY($2)`
+
 const GetAwaitOrdersQueryRow = `
-		SELECT ordernumber, orderstatus, accrual, uploadedat
+		SELECT ordernumber, orderstatus, accrual, uploadedat, attempts
 		FROM
 			public.orders
 		WHERE
-			orders.orderstatus != 'INVALID' AND orders.orderstatus != 'PROCESSED'
+			orders.orderstatus != 'INVALID' AND orders.orderstatus != 'PROCESSED' AND orders.orderstatus != 'FAILED'
+			AND (orders.nextattemptat IS NULL OR orders.nextattemptat <= $1)
 		ORDER BY
 			orders.uploadedat
 	`
 
+const RetryOrderQuery = `
+		UPDATE public.orders
+		SET attempts=$1, nextAttemptAt=$2, lastError=$3, lastCheckedAt=$5
+		WHERE orderNumber=$4
+			AND orderStatus != 'INVALID' AND orderStatus != 'PROCESSED' AND orderStatus != 'FAILED';
+	`
+
+const FailOrderQuery = `
+		UPDATE public.orders
+		SET orderStatus='FAILED', attempts=$1, nextAttemptAt=NULL, lastError=$2, lastCheckedAt=$4
+		WHERE orderNumber=$3
+			AND orderStatus != 'INVALID' AND orderStatus != 'PROCESSED' AND orderStatus != 'FAILED';
+	`
+
+const GetFailedOrdersQueryRow = `
+		SELECT userid, ordernumber, attempts, COALESCE(lasterror, '') AS lasterror, uploadedat
+		FROM
+			public.orders
+		WHERE
+			orders.orderstatus = 'FAILED'
+		ORDER BY
+			orders.uploadedat
+	`
+
+const RequeueOrderQuery = `
+		UPDATE public.orders
+		SET orderStatus='NEW', attempts=0, nextAttemptAt=NULL, lastError=NULL
+		WHERE orderNumber=$1 AND orderStatus = 'FAILED';
+	`
+
 const UpdateOrderInsert = `
 		INSERT INTO public.ordersoperations
 		(userID, orderNumber, pointsQuantity, processedAt)
@@ -79,16 +218,58 @@ const UpdateOrderInsert = `
 		($1, $2, $3, $4)
 		`
 
+const LockOrderQueryRow = `
+		SELECT orders.orderStatus
+		FROM
+			public.orders
+		WHERE
+			orders.userID=$1 AND orders.orderNumber=$2
+		FOR UPDATE
+	`
+
 const UpdateOrderQuery = `
 		UPDATE public.orders
-		SET orderStatus=$1, accrual=$2, uploadedAt=$3
-		WHERE userID=$4 AND orderNumber=$5;
+		SET orderStatus=$1, accrual=$2, attempts=0, nextAttemptAt=NULL, lastError=NULL, lastCheckedAt=$5
+		WHERE userID=$3 AND orderNumber=$4
+			AND orderStatus != 'INVALID' AND orderStatus != 'PROCESSED';
 	`
 
+const AddHistoryInsert = `
+		INSERT INTO public.order_status_history
+		(orderNumber, status, source, accrualResponse, changedAt)
+		VALUES
+		($1, $2, $3, NULLIF($4, '')::jsonb, $5)
+	`
+
+const GetHistoryQueryRow = `
+		SELECT status, source, accrualresponse, changedat
+		FROM
+			public.order_status_history
+		WHERE
+			order_status_history.ordernumber=$1
+		ORDER BY
+			order_status_history.changedat, order_status_history.historyid
+	`
+
+const GetOrderDetailsQueryRow = `
+		SELECT userid, ordernumber, orderstatus, accrual, uploadedat, attempts, lastcheckedat,
+			COALESCE((
+				SELECT max(order_status_history.changedat)
+				FROM public.order_status_history
+				WHERE order_status_history.ordernumber = orders.ordernumber
+			), uploadedat) AS updatedat
+		FROM
+			public.orders
+		WHERE
+			orders.ordernumber=$1
+	`
+
+// UpdateBalanceQuery возвращает баланс после начисления для события пользователя
 const UpdateBalanceQuery = `
 		UPDATE public.usersbalance
-		SET pointssum=$1
-		WHERE userID=$2;
+		SET pointssum=pointssum+$1
+		WHERE userID=$2
+		RETURNING pointssum, pointsloss;
 	`
 
 ////////////////////////////////////////
@@ -114,7 +295,45 @@ const CreateUserInsert = `
 		INSERT INTO public.users
 		(userLogin, userPassword)
 		VALUES
-		($1, $2);
+		($1, $2)
+		RETURNING userID;
+	`
+
+const GetAccountQueryRow = `
+		SELECT users.userID, users.userLogin, users.role, users.disabledAt,
+			COALESCE(usersbalance.pointsSum, 0), COALESCE(usersbalance.pointsLoss, 0)
+		FROM
+			public.users
+			LEFT JOIN public.usersbalance ON usersbalance.userID = users.userID
+		WHERE
+		users.userID=$1
+	`
+
+// SearchUsersQuery ищет пользователей по части логина без учёта регистра;
+// $1 — шаблон LIKE с экранированными % и _
+const SearchUsersQuery = `
+		SELECT users.userID, users.userLogin, users.role, users.disabledAt,
+			COALESCE(usersbalance.pointsSum, 0), COALESCE(usersbalance.pointsLoss, 0)
+		FROM
+			public.users
+			LEFT JOIN public.usersbalance ON usersbalance.userID = users.userID
+		WHERE
+		lower(users.userLogin) LIKE lower($1)
+		ORDER BY users.userID
+		LIMIT $2
+	`
+
+const SetRoleQuery = `
+		UPDATE public.users
+		SET role=$2
+		WHERE userID=$1;
+	`
+
+// SetDisabledQuery блокирует ($2 — время блокировки) или разблокирует ($2 — NULL) учётную запись
+const SetDisabledQuery = `
+		UPDATE public.users
+		SET disabledAt=$2
+		WHERE userID=$1;
 	`
 
 const CreateUserBalanceInsert = `
@@ -123,3 +342,271 @@ const CreateUserBalanceInsert = `
 		VALUES
 		($1, $2, $3);
 	`
+
+////////////////////////////////////////
+// callbacksrepo
+
+const DeleteExpiredCallbacks = `
+		DELETE FROM public.accrualcallbacks
+		WHERE receivedAt < $1;
+	`
+
+const InsertCallback = `
+		INSERT INTO public.accrualcallbacks
+		(signature, receivedAt)
+		VALUES
+		($1, $2)
+		ON CONFLICT (signature) DO NOTHING;
+	`
+
+const DeleteCallback = `
+		DELETE FROM public.accrualcallbacks
+		WHERE signature=$1;
+	`
+
+////////////////////////////////////////
+// eventsrepo
+
+// AddEventInsert сохраняет событие и сообщает слушателям канала номер пользователя.
+// Внутри транзакции уведомление доставляется только после COMMIT.
+const AddEventInsert = `
+		WITH inserted AS (
+			INSERT INTO public.user_events
+			(userID, eventType, payload, createdAt)
+			VALUES
+			($1, $2, $3::jsonb, $4)
+			RETURNING userID
+		)
+		SELECT pg_notify($5, inserted.userID::text) FROM inserted
+	`
+
+const ListEventsQuery = `
+		SELECT eventid, userid, eventtype, payload, createdat
+		FROM public.user_events
+		WHERE userid=$1 AND eventid > $2
+		ORDER BY eventid
+		LIMIT $3
+	`
+
+const LastEventQueryRow = `
+		SELECT COALESCE(max(eventid), 0)
+		FROM public.user_events
+		WHERE userid=$1
+	`
+
+// PublishAdminEventsQuery рассылает события для панели администратора.
+// Внутри транзакции уведомления доставляются только после COMMIT.
+const PublishAdminEventsQuery = `
+		SELECT pg_notify($1, payload) FROM unnest($2::text[]) AS payload
+	`
+
+////////////////////////////////////////
+// webhooksrepo
+
+// CreateWebhookInsert не добавляет адрес, если у пользователя их уже $6
+const CreateWebhookInsert = `
+		INSERT INTO public.webhooks
+		(userID, url, secret, events, createdAt)
+		SELECT $1, $2, $3, $4, $5
+		WHERE (SELECT count(*) FROM public.webhooks WHERE userID=$1) < $6
+		RETURNING webhookID;
+	`
+
+const ListWebhooksQuery = `
+		SELECT webhookid, url, events, createdat
+		FROM public.webhooks
+		WHERE userid=$1
+		ORDER BY webhookid
+	`
+
+const DeleteWebhookQuery = `
+		DELETE FROM public.webhooks
+		WHERE webhookID=$1 AND userID=$2;
+	`
+
+const GetWebhookOwnerQueryRow = `
+		SELECT userid FROM public.webhooks WHERE webhookid=$1
+	`
+
+// EnqueueDeliveriesInsert ставит событие в очередь доставки на все адреса пользователя,
+// подписанные на этот тип событий
+const EnqueueDeliveriesInsert = `
+		INSERT INTO public.webhook_deliveries
+		(webhookID, eventType, payload, nextAttemptAt, createdAt)
+		SELECT webhookID, $2, $3::jsonb, $4, $4
+		FROM public.webhooks
+		WHERE userID=$1 AND $2 = ANY(events);
+	`
+
+// ListDeliveriesQuery дополняется условиями и сортировкой страницы (pagination.Params.SQL)
+const ListDeliveriesQuery = `
+		SELECT deliveryid, eventtype, payload, status, attempts,
+			COALESCE(responsecode, 0) AS responsecode, COALESCE(lasterror, '') AS lasterror, createdat,
+			CASE WHEN status = 'PENDING' THEN nextattemptat END AS nextattemptat, deliveredat
+		FROM public.webhook_deliveries
+		WHERE webhook_deliveries.webhookid=$1
+	`
+
+// ClaimDeliveriesQuery выбирает доставки, время которых пришло, и откладывает их на $3:
+// другие экземпляры сервиса не возьмут их, пока идёт отправка,
+// а при остановке экземпляра доставка повторится после этого срока
+const ClaimDeliveriesQuery = `
+		UPDATE public.webhook_deliveries
+		SET nextAttemptAt=$3
+		FROM public.webhooks
+		WHERE webhooks.webhookID = webhook_deliveries.webhookID
+			AND webhook_deliveries.deliveryID IN (
+				SELECT deliveryID
+				FROM public.webhook_deliveries
+				WHERE status = 'PENDING' AND nextAttemptAt <= $1
+				ORDER BY nextAttemptAt, deliveryID
+				LIMIT $2
+				FOR UPDATE SKIP LOCKED
+			)
+		RETURNING webhook_deliveries.deliveryID, webhook_deliveries.eventType, webhook_deliveries.payload,
+			webhook_deliveries.attempts, webhooks.url, webhooks.secret
+	`
+
+const DeliveredQuery = `
+		UPDATE public.webhook_deliveries
+		SET status='DELIVERED', attempts=$2, responseCode=$3, lastError=NULL, deliveredAt=$4
+		WHERE deliveryID=$1;
+	`
+
+const RetryDeliveryQuery = `
+		UPDATE public.webhook_deliveries
+		SET attempts=$2, nextAttemptAt=$3, responseCode=NULLIF($4, 0), lastError=$5
+		WHERE deliveryID=$1;
+	`
+
+const FailDeliveryQuery = `
+		UPDATE public.webhook_deliveries
+		SET status='FAILED', attempts=$2, responseCode=NULLIF($3, 0), lastError=$4
+		WHERE deliveryID=$1;
+	`
+
+////////////////////////////////////////
+// outboxrepo
+
+// AddOutboxInsert сохраняет события в порядке элементов массивов
+const AddOutboxInsert = `
+		INSERT INTO public.outbox
+		(eventType, userID, payload, createdAt)
+		SELECT e.eventType, e.userID, e.payload, e.createdAt
+		FROM unnest($1::text[], $2::int[], $3::jsonb[], $4::timestamptz[])
+			WITH ORDINALITY AS e(eventType, userID, payload, createdAt, n)
+		ORDER BY e.n;
+	`
+
+// OutboxLockQueryRow не даёт двум экземплярам сервиса передавать события одновременно:
+// блокировка снимается при завершении транзакции
+const OutboxLockQueryRow = `
+		SELECT pg_try_advisory_xact_lock($1)
+	`
+
+const UnpublishedEventsQuery = `
+		SELECT eventid, eventtype, userid, payload, createdat
+		FROM public.outbox
+		WHERE publishedat IS NULL
+		ORDER BY eventid
+		LIMIT $1
+	`
+
+const MarkPublishedQuery = `
+		UPDATE public.outbox
+		SET publishedAt=$2
+		WHERE eventID = ANY($1);
+	`
+
+const PurgeOutboxQuery = `
+		DELETE FROM public.outbox
+		WHERE publishedAt < $1;
+	`
+
+////////////////////////////////////////
+// auditrepo
+
+// AuditLockQuery выстраивает записи журнала аудита в одну цепочку: пока транзакция
+// не завершена, другие экземпляры сервиса ждут, чтобы дописать свою запись
+const AuditLockQuery = `
+		SELECT pg_advisory_xact_lock($1)
+	`
+
+const LastAuditHashQueryRow = `
+		SELECT hash
+		FROM public.audit_log
+		ORDER BY auditID DESC
+		LIMIT 1
+	`
+
+const AddAuditInsert = `
+		INSERT INTO public.audit_log
+		(occurredAt, actorID, actorRole, ip, userAgent, action, target, result, details, prevHash, hash)
+		VALUES
+		($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING auditID;
+	`
+
+// ListAuditQuery дополняется условиями и сортировкой страницы (pagination.Params.SQL);
+// автор NULL ($1) и пустые действие ($2), объект ($3) и результат ($4) не ограничивают выборку
+const ListAuditQuery = `
+		SELECT auditid, occurredat, actorid, actorrole, ip, useragent, action, target, result,
+			details, prevhash, hash
+		FROM
+			public.audit_log
+		WHERE
+			($1::int IS NULL OR audit_log.actorID = $1)
+			AND ($2 = '' OR audit_log.action = $2)
+			AND ($3 = '' OR audit_log.target = $3)
+			AND ($4 = '' OR audit_log.result = $4)
+	`
+
+const AuditChainQuery = `
+		SELECT auditid, occurredat, actorid, actorrole, ip, useragent, action, target, result,
+			details, prevhash, hash
+		FROM
+			public.audit_log
+		ORDER BY auditID
+	`
+
+////////////////////////////////////////
+// lockoutrepo
+
+const AddAttemptsInsert = `
+		INSERT INTO public.login_attempts
+		(attemptKey, failures, lastFailureAt, blockedUntil)
+		VALUES
+		($1, 0, $2, $2)
+		ON CONFLICT (attemptKey) DO NOTHING;
+	`
+
+const LockAttemptsQueryRow = `
+		SELECT attemptkey, failures, lastfailureat, blockeduntil
+		FROM public.login_attempts
+		WHERE attemptKey=$1
+		FOR UPDATE
+	`
+
+const UpdateAttemptsQuery = `
+		UPDATE public.login_attempts
+		SET failures=$2, lastFailureAt=$3, blockedUntil=$4
+		WHERE attemptKey=$1;
+	`
+
+const DeleteAttemptsQuery = `
+		DELETE FROM public.login_attempts
+		WHERE attemptKey=$1;
+	`
+
+const ListLockoutsQuery = `
+		SELECT attemptkey, failures, lastfailureat, blockeduntil
+		FROM public.login_attempts
+		WHERE blockedUntil > $1
+		ORDER BY blockedUntil DESC, attemptKey
+	`
+
+// PurgeAttemptsQuery удаляет счётчики без блокировки, последняя неудача по которым раньше $1
+const PurgeAttemptsQuery = `
+		DELETE FROM public.login_attempts
+		WHERE lastFailureAt < $1 AND blockedUntil <= $2;
+	`
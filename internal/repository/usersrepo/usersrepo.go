@@ -6,26 +6,54 @@ import (
 	"encoding/hex"
 	"errors"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/beliaevke/go-musthave-diploma/internal/db/postgres"
 	"github.com/beliaevke/go-musthave-diploma/internal/logger"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/changesrepo"
 	"github.com/beliaevke/go-musthave-diploma/internal/repository/queries"
 
 	"github.com/jackc/pgx/v5"
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
+// Роли пользователей
+const (
+	RoleUser    = "user"
+	RoleSupport = "support"
+	RoleAdmin   = "admin"
+)
+
+// Roles — все роли пользователей
+var Roles = []string{RoleUser, RoleSupport, RoleAdmin}
+
 type User struct {
 	db *postgres.DB
 }
 
+// Account — учётная запись пользователя для административного API
+type Account struct {
+	ID         int        `json:"id"`
+	Login      string     `json:"login"`
+	Role       string     `json:"role"`
+	DisabledAt *time.Time `json:"disabled_at,omitempty"`
+	Current    float32    `json:"current"`
+	Withdrawn  float32    `json:"withdrawn"`
+}
+
 type UserInfo struct {
 	UserID       int    `json:"id,omitempty"`
 	UserLogin    string `json:"login"`
 	UserPassword string `json:"password"`
 }
 
+// HashPassword возвращает хеш пароля в том виде, в котором он хранится в базе
+func HashPassword(password string) string {
+	hash := md5.Sum([]byte(password))
+	return hex.EncodeToString(hash[:])
+}
+
 func NewUser(db *postgres.DB) *User {
 	return &User{
 		db: db,
@@ -42,35 +70,36 @@ func (ur *User) CreateUser(ctx context.Context, u UserInfo) (int, error) {
 		return -1, err
 	}
 	defer tx.Rollback(ctx) //nolint
-	result := ur.db.Pool.QueryRow(ctx, queries.SelectUser, u.UserLogin)
-	switch err := result.Scan(&u.UserLogin); err {
+	result := tx.QueryRow(ctx, queries.SelectUser, u.UserLogin)
+	switch err := result.Scan(&u.UserID); err {
 	case pgx.ErrNoRows:
-		hash := md5.Sum([]byte(u.UserPassword))
-		hashedPass := hex.EncodeToString(hash[:])
-		_, err = ur.db.Pool.Exec(ctx, queries.CreateUserInsert, u.UserLogin, hashedPass)
+		var userID int
+		err = tx.QueryRow(ctx, queries.CreateUserInsert, u.UserLogin, HashPassword(u.UserPassword)).Scan(&userID)
 		if err != nil {
-			logger.Warnf("INSERT INTO Users: " + err.Error())
+			logger.WarnfCtx(ctx, "INSERT INTO Users: "+err.Error())
 			return -1, err
 		}
-		userID, err := ur.GetUser(ctx, u)
+		_, err = tx.Exec(ctx, queries.CreateUserBalanceInsert, userID, 0, 0)
 		if err != nil {
-			logger.Warnf("CreateUser ID : " + err.Error())
+			logger.WarnfCtx(ctx, "INSERT INTO balance: "+err.Error())
 			return userID, err
 		}
-		_, err = ur.db.Pool.Exec(ctx, queries.CreateUserBalanceInsert, userID, 0, 0)
-		if err != nil {
-			logger.Warnf("INSERT INTO balance: " + err.Error())
+		var change changesrepo.Change
+		if err = change.UserCreated(userID, u.UserLogin, time.Now()); err != nil {
+			return userID, err
+		}
+		if err = change.Write(ctx, tx); err != nil {
 			return userID, err
 		}
 		return userID, tx.Commit(ctx)
 	case nil:
 		err = errors.New("user already exists with this login")
 		if err != nil {
-			logger.Warnf("INSERT INTO Users: " + err.Error())
+			logger.WarnfCtx(ctx, "INSERT INTO Users: "+err.Error())
 			return -1, err
 		}
 	case err:
-		logger.Warnf("Query CreateUser: " + err.Error())
+		logger.WarnfCtx(ctx, "Query CreateUser: "+err.Error())
 		return -1, err
 	}
 	return -1, tx.Commit(ctx)
@@ -80,7 +109,7 @@ func (ur *User) GetUser(ctx context.Context, u UserInfo) (int, error) {
 	if u.UserLogin == "" || u.UserPassword == "" {
 		err := errors.New("user or pass is empty")
 		if err != nil {
-			logger.Warnf("GetUser: " + err.Error())
+			logger.WarnfCtx(ctx, "GetUser: "+err.Error())
 			return -1, err
 		}
 	}
@@ -97,7 +126,7 @@ func (ur *User) GetUser(ctx context.Context, u UserInfo) (int, error) {
 		} else {
 			PassIsEmpty = " -- PASS is not empty"
 		}
-		logger.Warnf("Query GetUser: " + err.Error() + " ID: " + strconv.Itoa(u.UserID) + " USER: " + u.UserLogin + PassIsEmpty)
+		logger.WarnfCtx(ctx, "Query GetUser: "+err.Error()+" ID: "+strconv.Itoa(u.UserID)+" USER: "+u.UserLogin+PassIsEmpty)
 		return -1, nil
 	}
 	return u.UserID, nil
@@ -107,21 +136,83 @@ func (ur *User) LoginUser(ctx context.Context, u UserInfo) (int, error) {
 	if u.UserLogin == "" || u.UserPassword == "" {
 		err := errors.New("user or pass is empty")
 		if err != nil {
-			logger.Warnf("GetUser: " + err.Error())
+			logger.WarnfCtx(ctx, "GetUser: "+err.Error())
 			return -1, err
 		}
 	}
-	hash := md5.Sum([]byte(u.UserPassword))
-	hashedPass := hex.EncodeToString(hash[:])
-	result := ur.db.Pool.QueryRow(ctx, queries.SelectUserWithPass, u.UserLogin, hashedPass)
+	result := ur.db.Pool.QueryRow(ctx, queries.SelectUserWithPass, u.UserLogin, HashPassword(u.UserPassword))
 	switch err := result.Scan(&u.UserID); err {
 	case pgx.ErrNoRows:
 		return -1, nil
 	case nil:
 		return u.UserID, nil
 	case err:
-		logger.Warnf("Query LoginUser: " + err.Error())
+		logger.WarnfCtx(ctx, "Query LoginUser: "+err.Error())
 		return -1, nil
 	}
 	return u.UserID, nil
 }
+
+// GetAccount возвращает учётную запись пользователя; false — пользователь не найден
+func (ur *User) GetAccount(ctx context.Context, userID int) (Account, bool, error) {
+	var a Account
+	err := ur.db.Pool.QueryRow(ctx, queries.GetAccountQueryRow, userID).
+		Scan(&a.ID, &a.Login, &a.Role, &a.DisabledAt, &a.Current, &a.Withdrawn)
+	switch err {
+	case nil:
+		return a, true, nil
+	case pgx.ErrNoRows:
+		return a, false, nil
+	default:
+		logger.WarnfCtx(ctx, "Query GetAccount: "+err.Error())
+		return a, false, err
+	}
+}
+
+// SearchUsers возвращает до limit учётных записей, логин которых содержит login (без учёта регистра)
+func (ur *User) SearchUsers(ctx context.Context, login string, limit int) ([]Account, error) {
+	rows, err := ur.db.Pool.Query(ctx, queries.SearchUsersQuery, "%"+EscapeLike(login)+"%", limit)
+	if err != nil {
+		logger.WarnfCtx(ctx, "Query SearchUsers: "+err.Error())
+		return nil, err
+	}
+	var (
+		val []Account
+		a   Account
+	)
+	_, err = pgx.ForEachRow(rows, []any{&a.ID, &a.Login, &a.Role, &a.DisabledAt, &a.Current, &a.Withdrawn}, func() error {
+		val = append(val, a)
+		return nil
+	})
+	if err != nil {
+		logger.WarnfCtx(ctx, "Query SearchUsers: "+err.Error())
+		return nil, err
+	}
+	return val, nil
+}
+
+// SetRole назначает пользователю роль; false — пользователь не найден
+func (ur *User) SetRole(ctx context.Context, userID int, role string) (bool, error) {
+	tag, err := ur.db.Pool.Exec(ctx, queries.SetRoleQuery, userID, role)
+	if err != nil {
+		logger.WarnfCtx(ctx, "UPDATE Users role: "+err.Error())
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// SetDisabled блокирует учётную запись с момента disabledAt или разблокирует её (nil);
+// false — пользователь не найден
+func (ur *User) SetDisabled(ctx context.Context, userID int, disabledAt *time.Time) (bool, error) {
+	tag, err := ur.db.Pool.Exec(ctx, queries.SetDisabledQuery, userID, disabledAt)
+	if err != nil {
+		logger.WarnfCtx(ctx, "UPDATE Users disabled: "+err.Error())
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// EscapeLike экранирует символы шаблона LIKE (\, % и _)
+func EscapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
@@ -0,0 +1,260 @@
+// Package webhooksrepo хранит адреса пользователей для исходящих уведомлений
+// (вебхуков) и очередь их доставки. Доставки ставятся в очередь в той же
+// транзакции, что и событие, поэтому откат изменений отменяет и уведомление.
+package webhooksrepo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/db/postgres"
+	"github.com/beliaevke/go-musthave-diploma/internal/logger"
+	"github.com/beliaevke/go-musthave-diploma/internal/pagination"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/queries"
+
+	"github.com/jackc/pgx/v5"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// Типы событий, о которых можно получать уведомления
+const (
+	EventOrderProcessed = "order.processed"
+	EventOrderInvalid   = "order.invalid"
+	EventWithdrawal     = "withdrawal"
+	// EventBalanceAdjusted — администратор провёл корректировку баланса
+	EventBalanceAdjusted = "balance.adjusted"
+)
+
+// EventTypes — все типы событий уведомлений
+var EventTypes = []string{EventOrderProcessed, EventOrderInvalid, EventWithdrawal, EventBalanceAdjusted}
+
+// Статусы доставки уведомления
+const (
+	DeliveryPending   = "PENDING"
+	DeliveryDelivered = "DELIVERED"
+	DeliveryFailed    = "FAILED"
+)
+
+// MaxWebhooks — наибольшее число адресов у одного пользователя
+const MaxWebhooks = 10
+
+// ErrTooManyWebhooks — у пользователя уже MaxWebhooks адресов
+var ErrTooManyWebhooks = errors.New("too many webhooks")
+
+// Webhook — адрес пользователя для уведомлений. Secret — ключ подписи,
+// его получает только создавший адрес запрос.
+type Webhook struct {
+	ID        int64     `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Event — содержимое уведомления
+type Event struct {
+	Type    string  `json:"type"`
+	Order   string  `json:"order"`
+	Accrual float32 `json:"accrual,omitempty"`
+	Sum     float32 `json:"sum,omitempty"`
+	// Reason — причина корректировки баланса
+	Reason string `json:"reason,omitempty"`
+	// Balance — баланс после списания или корректировки
+	Balance *Balance  `json:"balance,omitempty"`
+	At      time.Time `json:"at"`
+}
+
+// Balance — баланс пользователя в уведомлении
+type Balance struct {
+	Current   float32 `json:"current"`
+	Withdrawn float32 `json:"withdrawn"`
+}
+
+// Delivery — запись журнала доставки уведомления
+type Delivery struct {
+	ID            int64           `db:"deliveryid" json:"id"`
+	EventType     string          `db:"eventtype" json:"event"`
+	Payload       json.RawMessage `db:"payload" json:"payload"`
+	Status        string          `db:"status" json:"status"`
+	Attempts      int             `db:"attempts" json:"attempts"`
+	ResponseCode  int             `db:"responsecode" json:"response_code,omitempty"`
+	LastError     string          `db:"lasterror" json:"last_error,omitempty"`
+	CreatedAt     time.Time       `db:"createdat" json:"created_at"`
+	NextAttemptAt *time.Time      `db:"nextattemptat" json:"next_attempt_at,omitempty"`
+	DeliveredAt   *time.Time      `db:"deliveredat" json:"delivered_at,omitempty"`
+}
+
+// Position возвращает положение доставки в журнале для курсора
+func (d Delivery) Position() pagination.Position {
+	return pagination.Position{At: d.CreatedAt, ID: d.ID}
+}
+
+// PendingDelivery — доставка, взятая в отправку
+type PendingDelivery struct {
+	ID        int64
+	EventType string
+	Payload   json.RawMessage
+	Attempts  int
+	URL       string
+	Secret    string
+}
+
+type Webhooks struct {
+	db *postgres.DB
+}
+
+func NewWebhooks(db *postgres.DB) *Webhooks {
+	return &Webhooks{db: db}
+}
+
+func (wh *Webhooks) Timeout() time.Duration {
+	return wh.db.DefaultTimeout
+}
+
+// Enqueue ставит уведомление о событии в очередь доставки на адреса пользователя,
+// подписанные на тип события, в транзакции tx
+func Enqueue(ctx context.Context, tx pgx.Tx, userID int, event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, queries.EnqueueDeliveriesInsert, userID, event.Type, string(payload), event.At)
+	if err != nil {
+		logger.WarnfCtx(ctx, "INSERT INTO webhook_deliveries: "+err.Error())
+	}
+	return err
+}
+
+// CreateWebhook добавляет адрес пользователя; ErrTooManyWebhooks — адресов уже MaxWebhooks
+func (wh *Webhooks) CreateWebhook(ctx context.Context, userID int, w Webhook) (Webhook, error) {
+	err := wh.db.Pool.QueryRow(ctx, queries.CreateWebhookInsert, userID, w.URL, w.Secret, w.Events, w.CreatedAt, MaxWebhooks).
+		Scan(&w.ID)
+	switch err {
+	case nil:
+		return w, nil
+	case pgx.ErrNoRows:
+		return w, ErrTooManyWebhooks
+	}
+	logger.WarnfCtx(ctx, "INSERT INTO webhooks: "+err.Error())
+	return w, err
+}
+
+// ListWebhooks возвращает адреса пользователя без ключей подписи
+func (wh *Webhooks) ListWebhooks(ctx context.Context, userID int) ([]Webhook, error) {
+	rows, err := wh.db.Pool.Query(ctx, queries.ListWebhooksQuery, userID)
+	if err != nil {
+		logger.WarnfCtx(ctx, "Query ListWebhooks: "+err.Error())
+		return nil, err
+	}
+	var (
+		val []Webhook
+		w   Webhook
+	)
+	_, err = pgx.ForEachRow(rows, []any{&w.ID, &w.URL, &w.Events, &w.CreatedAt}, func() error {
+		val = append(val, w)
+		return nil
+	})
+	if err != nil {
+		logger.WarnfCtx(ctx, "Query ListWebhooks: "+err.Error())
+		return nil, err
+	}
+	return val, nil
+}
+
+// DeleteWebhook удаляет адрес пользователя вместе с журналом доставки; false — адрес не найден
+func (wh *Webhooks) DeleteWebhook(ctx context.Context, userID int, webhookID int64) (bool, error) {
+	tag, err := wh.db.Pool.Exec(ctx, queries.DeleteWebhookQuery, webhookID, userID)
+	if err != nil {
+		logger.WarnfCtx(ctx, "DELETE FROM webhooks: "+err.Error())
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// GetWebhookOwner возвращает владельца адреса; -1, если адрес не найден
+func (wh *Webhooks) GetWebhookOwner(ctx context.Context, webhookID int64) (int, error) {
+	var userID int
+	switch err := wh.db.Pool.QueryRow(ctx, queries.GetWebhookOwnerQueryRow, webhookID).Scan(&userID); err {
+	case nil:
+		return userID, nil
+	case pgx.ErrNoRows:
+		return -1, nil
+	default:
+		logger.WarnfCtx(ctx, "Query GetWebhookOwner: "+err.Error())
+		return -1, err
+	}
+}
+
+// ListDeliveries возвращает страницу журнала доставки на адрес
+// и курсор следующей страницы (nil, если это последняя)
+func (wh *Webhooks) ListDeliveries(ctx context.Context, webhookID int64, p pagination.Params) ([]Delivery, *pagination.Cursor, error) {
+	where, order, args := p.SQL([]any{webhookID}, "webhook_deliveries.createdat", "webhook_deliveries.deliveryid", func(p pagination.Position) any {
+		return p.ID
+	})
+	rows, err := wh.db.Pool.Query(ctx, queries.ListDeliveriesQuery+where+order, args...)
+	if err != nil {
+		logger.WarnfCtx(ctx, "Query ListDeliveries: "+err.Error())
+		return nil, nil, err
+	}
+	val, err := pgx.CollectRows(rows, pgx.RowToStructByName[Delivery])
+	if err != nil {
+		logger.WarnfCtx(ctx, "CollectRows ListDeliveries: "+err.Error())
+		return nil, nil, err
+	}
+	val, next := pagination.Trim(val, p, Delivery.Position)
+	return val, next, nil
+}
+
+// ClaimDeliveries берёт в отправку до limit доставок, время которых пришло.
+// До конца срока lease их не возьмёт никто другой.
+func (wh *Webhooks) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]PendingDelivery, error) {
+	now := time.Now()
+	rows, err := wh.db.Pool.Query(ctx, queries.ClaimDeliveriesQuery, now, limit, now.Add(lease))
+	if err != nil {
+		logger.WarnfCtx(ctx, "Query ClaimDeliveries: "+err.Error())
+		return nil, err
+	}
+	var (
+		val []PendingDelivery
+		d   PendingDelivery
+	)
+	_, err = pgx.ForEachRow(rows, []any{&d.ID, &d.EventType, &d.Payload, &d.Attempts, &d.URL, &d.Secret}, func() error {
+		val = append(val, d)
+		return nil
+	})
+	if err != nil {
+		logger.WarnfCtx(ctx, "Query ClaimDeliveries: "+err.Error())
+		return nil, err
+	}
+	return val, nil
+}
+
+// MarkDelivered отмечает уведомление доставленным
+func (wh *Webhooks) MarkDelivered(ctx context.Context, deliveryID int64, attempts int, responseCode int) error {
+	_, err := wh.db.Pool.Exec(ctx, queries.DeliveredQuery, deliveryID, attempts, responseCode, time.Now())
+	if err != nil {
+		logger.WarnfCtx(ctx, "UPDATE webhook_deliveries delivered: "+err.Error())
+	}
+	return err
+}
+
+// RetryDelivery откладывает следующую попытку доставки до nextAttemptAt;
+// responseCode — 0, если ответ не получен
+func (wh *Webhooks) RetryDelivery(ctx context.Context, deliveryID int64, attempts int, nextAttemptAt time.Time, responseCode int, lastError string) error {
+	_, err := wh.db.Pool.Exec(ctx, queries.RetryDeliveryQuery, deliveryID, attempts, nextAttemptAt, responseCode, lastError)
+	if err != nil {
+		logger.WarnfCtx(ctx, "UPDATE webhook_deliveries retry: "+err.Error())
+	}
+	return err
+}
+
+// FailDelivery прекращает попытки доставки
+func (wh *Webhooks) FailDelivery(ctx context.Context, deliveryID int64, attempts int, responseCode int, lastError string) error {
+	_, err := wh.db.Pool.Exec(ctx, queries.FailDeliveryQuery, deliveryID, attempts, responseCode, lastError)
+	if err != nil {
+		logger.WarnfCtx(ctx, "UPDATE webhook_deliveries failed: "+err.Error())
+	}
+	return err
+}
@@ -1,12 +1,19 @@
 package router
 
 import (
-	"github.com/beliaevke/go-musthave-diploma/internal/db/postgres"
+	"github.com/beliaevke/go-musthave-diploma/internal/config"
+	"github.com/beliaevke/go-musthave-diploma/internal/handlers/admin"
 	"github.com/beliaevke/go-musthave-diploma/internal/handlers/balance"
+	"github.com/beliaevke/go-musthave-diploma/internal/handlers/events"
 	"github.com/beliaevke/go-musthave-diploma/internal/handlers/orders"
 	"github.com/beliaevke/go-musthave-diploma/internal/handlers/users"
+	"github.com/beliaevke/go-musthave-diploma/internal/handlers/webhooks"
 	"github.com/beliaevke/go-musthave-diploma/internal/logger"
 	"github.com/beliaevke/go-musthave-diploma/internal/middleware/auth"
+	"github.com/beliaevke/go-musthave-diploma/internal/middleware/requestid"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/lockoutrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/usersrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/storage"
 
 	"github.com/go-chi/chi"
 )
@@ -15,32 +22,101 @@ type Router struct {
 	R *chi.Mux
 }
 
-func NewRouter(db *postgres.DB) *Router {
+func NewRouter(store storage.Storage, cfg config.ServerFlags) *Router {
 
 	r := chi.NewRouter()
 
-	usersrepo := users.NewRepo(db)
-	ordersrepo := orders.NewRepo(db)
-	balancerepo := balance.NewRepo(db)
+	accounts := store.Users
+	ordersrepo := store.Orders
+	balancerepo := store.Balance
+	eventsrepo := store.Events
+	webhooksrepo := store.Webhooks
+	journal := store.Audit
+	loginLimits := users.LoginLimits{
+		Login: lockoutrepo.Policy{
+			MaxFailures: cfg.LoginMaxFailures,
+			Lockout:     cfg.LoginLockout,
+			DelayBase:   cfg.LoginDelayBase,
+			DelayMax:    cfg.LoginDelayMax,
+		},
+		IP: lockoutrepo.Policy{
+			MaxFailures: cfg.LoginIPMaxFailures,
+			Lockout:     cfg.LoginLockout,
+			DelayBase:   cfg.LoginDelayBase,
+			DelayMax:    cfg.LoginDelayMax,
+		},
+	}
+	eventsHub := events.NewHub(eventsrepo)
+	adminStream := admin.NewStream(eventsrepo)
+	secret := []byte(cfg.JWTSecret)
+	adjustmentLimits := admin.AdjustmentLimits{
+		usersrepo.RoleSupport: float32(cfg.AdjustmentLimitSupport),
+		usersrepo.RoleAdmin:   float32(cfg.AdjustmentLimitAdmin),
+	}
 
-	// Require Logging
+	// Require Request ID & Logging
+	r.Use(requestid.WithRequestID)
 	r.Use(logger.WithLogging)
 
 	// User Routes
 	r.Group(func(r chi.Router) {
-		r.Post("/api/user/register", users.UserRegisterHandler(usersrepo))
-		r.Post("/api/user/login", users.UserLoginHandler(usersrepo))
+		r.Post("/api/user/register", users.UserRegisterHandler(accounts, journal, secret))
+		r.Post("/api/user/login", users.UserLoginHandler(accounts, store.Lockouts, loginLimits, journal, secret))
+	})
+
+	// Accrual System Callbacks
+	// Require HMAC Signature
+	if cfg.AccrualCallbackSecret != "" {
+		r.Post("/api/internal/accrual/callback", orders.AccrualCallbackHandler(ordersrepo, store.Callbacks,
+			[]byte(cfg.AccrualCallbackSecret), cfg.AccrualCallbackTolerance))
+	}
+
+	// Admin Routes
+	// Require Admin Token or Session with Role
+	r.Route("/api/admin", func(r chi.Router) {
+		r.Use(auth.WithAdminAuthentication(secret, cfg.AdminToken, accounts))
+		r.Group(func(r chi.Router) {
+			r.Use(auth.WithRole(usersrepo.RoleSupport, usersrepo.RoleAdmin))
+			r.Get("/users", admin.SearchUsersHandler(accounts))
+			r.Get("/users/{id}", admin.GetUserHandler(accounts))
+			r.Post("/users/{id}/balance/adjustments", admin.RequestAdjustmentHandler(accounts, balancerepo, journal, adjustmentLimits))
+			r.Get("/balance/adjustments", admin.ListAdjustmentsHandler(balancerepo))
+			r.Get("/balance/adjustments/{id}", admin.GetAdjustmentHandler(balancerepo))
+			r.Get("/orders/failed", admin.GetFailedOrdersHandler(ordersrepo))
+			r.Get("/orders/{number}", admin.GetOrderHandler(ordersrepo))
+			r.Post("/orders/{number}/requeue", admin.RequeueOrderHandler(ordersrepo, journal))
+			r.Get("/events/ws", admin.EventsWebSocketHandler(adminStream))
+		})
+		r.Group(func(r chi.Router) {
+			r.Use(auth.WithRole(usersrepo.RoleAdmin))
+			r.Put("/users/{id}/role", admin.SetRoleHandler(accounts, journal))
+			r.Post("/users/{id}/disable", admin.SetDisabledHandler(accounts, journal, true))
+			r.Post("/users/{id}/enable", admin.SetDisabledHandler(accounts, journal, false))
+			r.Post("/balance/adjustments/{id}/approve", admin.ReviewAdjustmentHandler(balancerepo, journal, true))
+			r.Post("/balance/adjustments/{id}/reject", admin.ReviewAdjustmentHandler(balancerepo, journal, false))
+			r.Get("/audit", admin.ListAuditHandler(journal))
+			r.Get("/audit/verify", admin.VerifyAuditHandler(journal))
+			r.Get("/lockouts", admin.GetLockoutsHandler(store.Lockouts))
+			r.Delete("/lockouts/{kind}/{value}", admin.ClearLockoutHandler(store.Lockouts, journal))
+		})
 	})
 
 	// Orders & Balance Routes
 	// Require Authentication
 	r.Group(func(r chi.Router) {
-		r.Use(auth.WithAuthentication)
+		r.Use(auth.WithAuthentication(secret, accounts))
 		r.Get("/api/user/orders", orders.GetOrdersHandler(ordersrepo))
+		r.Get("/api/user/orders/{number}", orders.GetOrderHandler(ordersrepo))
 		r.Post("/api/user/orders", orders.PostOrdersHandler(ordersrepo))
+		r.Post("/api/user/orders/batch", orders.PostOrdersBatchHandler(ordersrepo))
 		r.Get("/api/user/balance", balance.GetBalanceHandler(balancerepo))
-		r.Post("/api/user/balance/withdraw", balance.PostBalanceWithdrawHandler(balancerepo))
+		r.Post("/api/user/balance/withdraw", balance.PostBalanceWithdrawHandler(balancerepo, journal))
 		r.Get("/api/user/withdrawals", balance.GetWithdrawalsHandler(balancerepo))
+		r.Get("/api/user/events", events.GetEventsHandler(eventsrepo, eventsHub))
+		r.Post("/api/user/webhooks", webhooks.PostWebhookHandler(webhooksrepo))
+		r.Get("/api/user/webhooks", webhooks.GetWebhooksHandler(webhooksrepo))
+		r.Delete("/api/user/webhooks/{id}", webhooks.DeleteWebhookHandler(webhooksrepo))
+		r.Get("/api/user/webhooks/{id}/deliveries", webhooks.GetDeliveriesHandler(webhooksrepo))
 	})
 
 	return &Router{R: r}
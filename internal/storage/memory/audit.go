@@ -0,0 +1,70 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/pagination"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/auditrepo"
+)
+
+// Audit — журнал аудита в памяти
+type Audit struct {
+	db *DB
+}
+
+func (a *Audit) Timeout() time.Duration {
+	return a.db.timeout
+}
+
+func (a *Audit) Record(ctx context.Context, e auditrepo.Entry) error {
+	return a.db.update(ctx, func(s *state) error {
+		s.addAudit(e)
+		return nil
+	})
+}
+
+// addAudit дописывает запись в конец журнала, аналог auditrepo.Add
+func (s *state) addAudit(e auditrepo.Entry) {
+	prevHash := auditrepo.GenesisHash
+	if len(s.audit) > 0 {
+		prevHash = s.audit[len(s.audit)-1].Hash
+	}
+	e = e.Chain(prevHash)
+	e.ID = int64(len(s.audit)) + 1
+	s.audit = append(s.audit, e)
+}
+
+func (a *Audit) ListEntries(ctx context.Context, f auditrepo.Filter) ([]auditrepo.Entry, *pagination.Cursor, error) {
+	var val []auditrepo.Entry
+	err := a.db.view(ctx, func(s *state) error {
+		for _, e := range s.audit {
+			if f.Matches(e) && f.Includes(e.Position()) {
+				val = append(val, e)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Slice(val, func(i, j int) bool {
+		return f.Less(val[i].Position(), val[j].Position())
+	})
+	val, next := pagination.Trim(val, f.Params, auditrepo.Entry.Position)
+	return val, next, nil
+}
+
+func (a *Audit) Verify(ctx context.Context) (auditrepo.Verification, error) {
+	vr := auditrepo.NewVerifier()
+	err := a.db.view(ctx, func(s *state) error {
+		for _, e := range s.audit {
+			if !vr.Add(e) {
+				break
+			}
+		}
+		return nil
+	})
+	return vr.Result(), err
+}
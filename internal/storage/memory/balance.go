@@ -0,0 +1,233 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/pagination"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/auditrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/balancerepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/changesrepo"
+)
+
+// Balance — хранилище балансов в памяти
+type Balance struct {
+	db *DB
+}
+
+func (b *Balance) Timeout() time.Duration {
+	return b.db.timeout
+}
+
+func (b *Balance) GetBalance(ctx context.Context, userID int) (balancerepo.Balance, error) {
+	var val balancerepo.Balance
+	err := b.db.view(ctx, func(s *state) error {
+		val = s.balances[userID]
+		return nil
+	})
+	return val, err
+}
+
+func (b *Balance) BalanceWithdraw(ctx context.Context, userID int, withdraw balancerepo.Withdraw, entry auditrepo.Entry) (balancerepo.Balance, error) {
+	var (
+		val    balancerepo.Balance
+		change changesrepo.Change
+	)
+	now := time.Now()
+	err := b.db.update(ctx, func(s *state) error {
+		current, ok := s.balances[userID]
+		if !ok || current.PointsSum < withdraw.Sum {
+			return balancerepo.ErrInsufficientFunds
+		}
+		val = balancerepo.Balance{
+			PointsSum:  current.PointsSum - withdraw.Sum,
+			PointsLoss: current.PointsLoss + withdraw.Sum,
+		}
+		s.balances[userID] = val
+		s.operations = append(s.operations, operation{
+			userID:         userID,
+			orderNumber:    withdraw.OrderNumber,
+			pointsQuantity: -withdraw.Sum,
+			processedAt:    now,
+		})
+		change = changesrepo.Change{}
+		err := change.BalanceChanged(changesrepo.Balance{
+			UserID:    userID,
+			Order:     withdraw.OrderNumber,
+			Sum:       -withdraw.Sum,
+			Current:   val.PointsSum,
+			Withdrawn: val.PointsLoss,
+			At:        now,
+		})
+		if err != nil {
+			return err
+		}
+		s.addChange(change)
+		s.addAudit(entry)
+		return nil
+	})
+	if err == nil {
+		b.db.publishChange(change)
+	}
+	return val, err
+}
+
+func (b *Balance) RequestAdjustment(ctx context.Context, a balancerepo.Adjustment, entry balancerepo.AuditEntry) (balancerepo.Adjustment, error) {
+	a.Status = balancerepo.AdjustmentPending
+	err := b.db.update(ctx, func(s *state) error {
+		// запросы не удаляются, поэтому номер запроса равен длине списка
+		a.ID = int64(len(s.adjustments) + 1)
+		s.adjustments = append(s.adjustments, a)
+		s.addAudit(entry(a))
+		return nil
+	})
+	return a, err
+}
+
+func (b *Balance) GetAdjustment(ctx context.Context, adjustmentID int64) (balancerepo.Adjustment, error) {
+	var val balancerepo.Adjustment
+	err := b.db.view(ctx, func(s *state) error {
+		if adjustmentID < 1 || adjustmentID > int64(len(s.adjustments)) {
+			return balancerepo.ErrAdjustmentNotFound
+		}
+		val = s.adjustments[adjustmentID-1]
+		return nil
+	})
+	return val, err
+}
+
+func (b *Balance) ListAdjustments(ctx context.Context, f balancerepo.AdjustmentFilter) ([]balancerepo.Adjustment, *pagination.Cursor, error) {
+	var val []balancerepo.Adjustment
+	err := b.db.view(ctx, func(s *state) error {
+		for _, a := range s.adjustments {
+			if (f.Status == "" || a.Status == f.Status) && (f.UserID == 0 || a.UserID == f.UserID) && f.Includes(a.Position()) {
+				val = append(val, a)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Slice(val, func(i, j int) bool {
+		return f.Less(val[i].Position(), val[j].Position())
+	})
+	val, next := pagination.Trim(val, f.Params, balancerepo.Adjustment.Position)
+	return val, next, nil
+}
+
+func (b *Balance) ReviewAdjustment(ctx context.Context, adjustmentID int64, r balancerepo.Review, entry balancerepo.AuditEntry) (balancerepo.Adjustment, balancerepo.Balance, error) {
+	var (
+		a      balancerepo.Adjustment
+		val    balancerepo.Balance
+		change changesrepo.Change
+	)
+	err := b.db.update(ctx, func(s *state) error {
+		change = changesrepo.Change{}
+		if adjustmentID < 1 || adjustmentID > int64(len(s.adjustments)) {
+			return balancerepo.ErrAdjustmentNotFound
+		}
+		a = s.adjustments[adjustmentID-1]
+		if a.Status != balancerepo.AdjustmentPending {
+			return balancerepo.ErrAdjustmentReviewed
+		}
+		if a.RequestedBy == r.ReviewedBy {
+			return balancerepo.ErrSelfReview
+		}
+		a.Status = balancerepo.AdjustmentRejected
+		if r.Approve {
+			a.Status = balancerepo.AdjustmentApproved
+			cur, ok := s.balances[a.UserID]
+			if !ok || cur.PointsSum+a.Amount < 0 {
+				return balancerepo.ErrInsufficientFunds
+			}
+			val = balancerepo.Balance{PointsSum: cur.PointsSum + a.Amount, PointsLoss: cur.PointsLoss}
+			s.balances[a.UserID] = val
+			s.operations = append(s.operations, operation{
+				userID:         a.UserID,
+				pointsQuantity: a.Amount,
+				processedAt:    r.ReviewedAt,
+				adjustmentID:   a.ID,
+			})
+			err := change.BalanceChanged(changesrepo.Balance{
+				UserID:    a.UserID,
+				Sum:       a.Amount,
+				Reason:    a.Reason,
+				Current:   val.PointsSum,
+				Withdrawn: val.PointsLoss,
+				At:        r.ReviewedAt,
+			})
+			if err != nil {
+				return err
+			}
+			s.addChange(change)
+		}
+		reviewedAt := r.ReviewedAt
+		a.ReviewedBy, a.ReviewedAt, a.ReviewComment = r.ReviewedBy, &reviewedAt, r.Comment
+		s.adjustments[adjustmentID-1] = a
+		s.addAudit(entry(a))
+		return nil
+	})
+	if err == nil {
+		b.db.publishChange(change)
+	}
+	return a, val, err
+}
+
+func (b *Balance) ListWithdrawals(ctx context.Context, userID int, p pagination.Params) ([]balancerepo.Withdrawals, *pagination.Cursor, error) {
+	var val []balancerepo.Withdrawals
+	err := b.db.view(ctx, func(s *state) error {
+		for i, op := range s.operations {
+			// журнал только дополняется, поэтому номер записи служит её идентификатором
+			w := balancerepo.Withdrawals{
+				OperationID:    int64(i + 1),
+				OrderNumber:    op.orderNumber,
+				PointsQuantity: -op.pointsQuantity,
+				ProcessedAt:    op.processedAt,
+			}
+			if op.userID == userID && op.pointsQuantity < 0 && op.adjustmentID == 0 && p.Includes(w.Position()) {
+				val = append(val, w)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Slice(val, func(i, j int) bool {
+		return p.Less(val[i].Position(), val[j].Position())
+	})
+	val, next := pagination.Trim(val, p, balancerepo.Withdrawals.Position)
+	return val, next, nil
+}
+
+// Ledger — журнал начислений и списаний в памяти
+type Ledger struct {
+	db *DB
+}
+
+func (l *Ledger) Timeout() time.Duration {
+	return l.db.timeout
+}
+
+func (l *Ledger) GetOperations(ctx context.Context, userID int) ([]balancerepo.Operation, error) {
+	var val []balancerepo.Operation
+	err := l.db.view(ctx, func(s *state) error {
+		for _, op := range s.operations {
+			if op.userID == userID {
+				val = append(val, balancerepo.Operation{
+					OrderNumber:    op.orderNumber,
+					PointsQuantity: op.pointsQuantity,
+					ProcessedAt:    op.processedAt,
+					AdjustmentID:   op.adjustmentID,
+				})
+			}
+		}
+		return nil
+	})
+	sort.SliceStable(val, func(i, j int) bool {
+		return val[i].ProcessedAt.After(val[j].ProcessedAt)
+	})
+	return val, err
+}
@@ -0,0 +1,38 @@
+package memory
+
+import (
+	"context"
+	"time"
+)
+
+// Callbacks — подписи принятых уведомлений в памяти
+type Callbacks struct {
+	db *DB
+}
+
+func (c *Callbacks) Timeout() time.Duration {
+	return c.db.timeout
+}
+
+func (c *Callbacks) RememberCallback(ctx context.Context, signature string, receivedAt time.Time, expireBefore time.Time) (bool, error) {
+	seen := false
+	err := c.db.update(ctx, func(s *state) error {
+		for k, at := range s.callbacks {
+			if at.Before(expireBefore) {
+				delete(s.callbacks, k)
+			}
+		}
+		if _, seen = s.callbacks[signature]; !seen {
+			s.callbacks[signature] = receivedAt
+		}
+		return nil
+	})
+	return seen, err
+}
+
+func (c *Callbacks) ForgetCallback(ctx context.Context, signature string) error {
+	return c.db.update(ctx, func(s *state) error {
+		delete(s.callbacks, signature)
+		return nil
+	})
+}
@@ -0,0 +1,135 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/changesrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/eventsrepo"
+)
+
+// Events — события пользователей в памяти
+type Events struct {
+	db *DB
+}
+
+func (e *Events) Timeout() time.Duration {
+	return e.db.timeout
+}
+
+func (e *Events) ListEvents(ctx context.Context, userID int, afterID int64, limit int) ([]eventsrepo.Event, error) {
+	var val []eventsrepo.Event
+	err := e.db.view(ctx, func(s *state) error {
+		// номер события — его позиция в журнале, поэтому более ранние можно пропустить
+		for _, ev := range s.events[min(afterID, int64(len(s.events))):] {
+			if ev.UserID == userID {
+				val = append(val, ev)
+				if len(val) == limit {
+					break
+				}
+			}
+		}
+		return nil
+	})
+	return val, err
+}
+
+func (e *Events) LastEventID(ctx context.Context, userID int) (int64, error) {
+	var id int64
+	err := e.db.view(ctx, func(s *state) error {
+		for i := len(s.events) - 1; i >= 0; i-- {
+			if s.events[i].UserID == userID {
+				id = s.events[i].ID
+				break
+			}
+		}
+		return nil
+	})
+	return id, err
+}
+
+func (e *Events) ListenEvents(ctx context.Context) (<-chan int, error) {
+	payloads := e.db.listen(ctx, eventsrepo.Channel)
+	users := make(chan int, cap(payloads))
+	go func() {
+		defer close(users)
+		for p := range payloads {
+			userID, err := strconv.Atoi(p)
+			if err != nil {
+				continue
+			}
+			select {
+			case users <- userID:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return users, nil
+}
+
+func (e *Events) ListenAdminEvents(ctx context.Context) (<-chan eventsrepo.AdminEvent, error) {
+	payloads := e.db.listen(ctx, eventsrepo.AdminChannel)
+	events := make(chan eventsrepo.AdminEvent, cap(payloads))
+	go func() {
+		defer close(events)
+		for p := range payloads {
+			var event eventsrepo.AdminEvent
+			if err := json.Unmarshal([]byte(p), &event); err != nil {
+				continue
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// publish рассылает события панели администратора; вызывается после фиксации изменений
+func (db *DB) publish(events ...eventsrepo.AdminEvent) {
+	for _, e := range events {
+		payload, _ := json.Marshal(e) //nolint
+		db.notify(eventsrepo.AdminChannel, string(payload))
+	}
+}
+
+// addEvent добавляет событие пользователя; подписчиков уведомляет вызывающий после фиксации изменений
+func (s *state) addEvent(userID int, eventType string, data any, createdAt time.Time) {
+	payload, _ := json.Marshal(data) //nolint
+	s.events = append(s.events, eventsrepo.Event{
+		ID:        int64(len(s.events) + 1),
+		UserID:    userID,
+		Type:      eventType,
+		Data:      payload,
+		CreatedAt: createdAt,
+	})
+}
+
+// addChange записывает изменение c для всех получателей; подписчиков уведомляет
+// вызывающий методом publishChange после фиксации изменений
+func (s *state) addChange(c changesrepo.Change) {
+	for _, e := range c.UserEvents {
+		s.addEvent(e.UserID, e.Type, e.Data, e.At)
+	}
+	s.addOutbox(c.Outbox...)
+	for _, w := range c.Webhooks {
+		s.enqueueWebhooks(w.UserID, w.Event)
+	}
+}
+
+// publishChange уведомляет подписчиков о зафиксированном изменении c
+func (db *DB) publishChange(c changesrepo.Change) {
+	notified := make(map[int]bool)
+	for _, e := range c.UserEvents {
+		if !notified[e.UserID] {
+			notified[e.UserID] = true
+			db.notify(eventsrepo.Channel, strconv.Itoa(e.UserID))
+		}
+	}
+	db.publish(c.AdminEvents...)
+}
@@ -0,0 +1,96 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/lockoutrepo"
+)
+
+// Lockouts — счётчики неудачных входов в памяти
+type Lockouts struct {
+	db *DB
+}
+
+func (l *Lockouts) Timeout() time.Duration {
+	return l.db.timeout
+}
+
+func (l *Lockouts) Attempt(ctx context.Context, limits []lockoutrepo.Limit, check func(ctx context.Context) (bool, error)) (time.Time, error) {
+	l.db.attemptsMu.Lock()
+	defer l.db.attemptsMu.Unlock()
+	attempts := make([]lockoutrepo.Attempts, len(limits))
+	err := l.db.view(ctx, func(s *state) error {
+		for i, limit := range limits {
+			attempts[i] = s.attempts[limit.Key]
+			attempts[i].Key = limit.Key
+		}
+		return nil
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	now := time.Now()
+	if until := lockoutrepo.BlockedUntil(attempts, now); !until.IsZero() {
+		return until, nil
+	}
+	ok, err := check(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Time{}, l.db.update(ctx, func(s *state) error {
+		var lockout time.Duration
+		for i, limit := range limits {
+			switch {
+			case !ok:
+				a := attempts[i]
+				if _, found := s.attempts[limit.Key]; !found {
+					a.LastFailureAt, a.BlockedUntil = now, now
+				}
+				s.attempts[limit.Key] = limit.Policy.Fail(a, now)
+				lockout = max(lockout, limit.Policy.Lockout)
+			case limit.ResetOnSuccess:
+				delete(s.attempts, limit.Key)
+			}
+		}
+		if lockout > 0 {
+			for k, v := range s.attempts {
+				if v.LastFailureAt.Before(now.Add(-lockout)) && !v.BlockedUntil.After(now) {
+					delete(s.attempts, k)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (l *Lockouts) ListLockouts(ctx context.Context, now time.Time) ([]lockoutrepo.Attempts, error) {
+	var val []lockoutrepo.Attempts
+	err := l.db.view(ctx, func(s *state) error {
+		for _, a := range s.attempts {
+			if a.BlockedUntil.After(now) {
+				val = append(val, a)
+			}
+		}
+		return nil
+	})
+	sort.Slice(val, func(i, j int) bool {
+		if !val[i].BlockedUntil.Equal(val[j].BlockedUntil) {
+			return val[i].BlockedUntil.After(val[j].BlockedUntil)
+		}
+		return val[i].Key < val[j].Key
+	})
+	return val, err
+}
+
+func (l *Lockouts) ClearLockout(ctx context.Context, key string) (bool, error) {
+	found := false
+	err := l.db.update(ctx, func(s *state) error {
+		if _, found = s.attempts[key]; found {
+			delete(s.attempts, key)
+		}
+		return nil
+	})
+	return found, err
+}
@@ -0,0 +1,248 @@
+// Package memory реализует хранилища сервиса в памяти процесса.
+// Используется в тестах и в режиме разработки -storage=memory.
+//
+// Каждая операция изменения выполняется как транзакция: изменения вносятся
+// в копию состояния, которая заменяет текущее только при успешном завершении.
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/auditrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/balancerepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/eventsrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/lockoutrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/ordersrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/outboxrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/webhooksrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/storage"
+)
+
+type user struct {
+	userID       int
+	userLogin    string
+	userPassword string
+	role         string
+	disabledAt   *time.Time
+}
+
+type order struct {
+	userID      int
+	orderNumber string
+	orderStatus ordersrepo.OrderStatus
+	accrual     float32
+	uploadedAt  time.Time
+
+	attempts      int
+	nextAttemptAt time.Time
+	lastError     string
+	lastCheckedAt time.Time
+}
+
+type operation struct {
+	userID         int
+	orderNumber    string
+	pointsQuantity float32
+	processedAt    time.Time
+	adjustmentID   int64
+}
+
+type webhook struct {
+	userID int
+	webhooksrepo.Webhook
+}
+
+type delivery struct {
+	webhookID     int64
+	nextAttemptAt time.Time
+	webhooksrepo.Delivery
+}
+
+type outboxEvent struct {
+	outboxrepo.Event
+	publishedAt time.Time
+}
+
+type state struct {
+	lastUserID int
+	users      map[string]user
+	orders     map[string]order
+	balances   map[int]balancerepo.Balance
+	operations []operation
+	callbacks  map[string]time.Time
+	history    map[string][]ordersrepo.HistoryEntry
+	events     []eventsrepo.Event
+	webhooks   map[int64]webhook
+	lastHookID int64
+	deliveries []delivery
+	// номер последней доставки: записи удаляются вместе с адресом, поэтому номер не равен длине журнала
+	lastDeliveryID int64
+	outbox         []outboxEvent
+	lastOutboxID   int64
+	adjustments    []balancerepo.Adjustment
+	audit          []auditrepo.Entry
+	attempts       map[string]lockoutrepo.Attempts
+}
+
+func (s *state) clone() *state {
+	c := &state{
+		lastUserID: s.lastUserID,
+		users:      make(map[string]user, len(s.users)),
+		orders:     make(map[string]order, len(s.orders)),
+		balances:   make(map[int]balancerepo.Balance, len(s.balances)),
+		operations: make([]operation, len(s.operations)),
+		callbacks:  make(map[string]time.Time, len(s.callbacks)),
+		history:    make(map[string][]ordersrepo.HistoryEntry, len(s.history)),
+	}
+	for k, v := range s.users {
+		c.users[k] = v
+	}
+	for k, v := range s.orders {
+		c.orders[k] = v
+	}
+	for k, v := range s.balances {
+		c.balances[k] = v
+	}
+	copy(c.operations, s.operations)
+	for k, v := range s.callbacks {
+		c.callbacks[k] = v
+	}
+	for k, v := range s.history {
+		// срез ограничен по ёмкости, чтобы append в копии не менял массив исходного состояния
+		c.history[k] = v[:len(v):len(v)]
+	}
+	c.events = s.events[:len(s.events):len(s.events)]
+	c.webhooks = make(map[int64]webhook, len(s.webhooks))
+	for k, v := range s.webhooks {
+		c.webhooks[k] = v
+	}
+	c.lastHookID = s.lastHookID
+	c.lastDeliveryID = s.lastDeliveryID
+	// доставки меняются на месте, поэтому копируются целиком
+	c.deliveries = append([]delivery(nil), s.deliveries...)
+	// события отмечаются опубликованными на месте, поэтому копируются целиком
+	c.outbox = append([]outboxEvent(nil), s.outbox...)
+	c.lastOutboxID = s.lastOutboxID
+	// решения по корректировкам записываются на месте, поэтому корректировки копируются целиком
+	c.adjustments = append([]balancerepo.Adjustment(nil), s.adjustments...)
+	// журнал аудита только дополняется
+	c.audit = s.audit[:len(s.audit):len(s.audit)]
+	c.attempts = make(map[string]lockoutrepo.Attempts, len(s.attempts))
+	for k, v := range s.attempts {
+		c.attempts[k] = v
+	}
+	return c
+}
+
+// DB — общее состояние хранилищ в памяти
+type DB struct {
+	mu      sync.RWMutex
+	state   *state
+	timeout time.Duration
+
+	// подписчики каналов уведомлений, аналог LISTEN/NOTIFY
+	listenersMu sync.Mutex
+	listeners   map[string]map[chan string]struct{}
+
+	relayMu sync.Mutex
+
+	// попытки входа проводятся по очереди, аналог блокировки строк login_attempts
+	attemptsMu sync.Mutex
+}
+
+// NewDB создаёт пустое хранилище
+func NewDB(timeout time.Duration) *DB {
+	return &DB{
+		state: &state{
+			users:     make(map[string]user),
+			orders:    make(map[string]order),
+			balances:  make(map[int]balancerepo.Balance),
+			callbacks: make(map[string]time.Time),
+			history:   make(map[string][]ordersrepo.HistoryEntry),
+			webhooks:  make(map[int64]webhook),
+			attempts:  make(map[string]lockoutrepo.Attempts),
+		},
+		timeout:   timeout,
+		listeners: make(map[string]map[chan string]struct{}),
+	}
+}
+
+// New возвращает хранилища сервиса, работающие с новым пустым состоянием в памяти
+func New(timeout time.Duration) storage.Storage {
+	return NewDB(timeout).Storage()
+}
+
+// Storage возвращает хранилища сервиса поверх db
+func (db *DB) Storage() storage.Storage {
+	return storage.Storage{
+		Users:     &Users{db: db},
+		Orders:    &Orders{db: db},
+		Balance:   &Balance{db: db},
+		Ledger:    &Ledger{db: db},
+		Callbacks: &Callbacks{db: db},
+		Events:    &Events{db: db},
+		Webhooks:  &Webhooks{db: db},
+		Outbox:    &Outbox{db: db},
+		Audit:     &Audit{db: db},
+		Lockouts:  &Lockouts{db: db},
+	}
+}
+
+// update выполняет fn над копией состояния и сохраняет её, если fn не вернула ошибку
+func (db *DB) update(ctx context.Context, fn func(s *state) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	tx := db.state.clone()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	db.state = tx
+	return nil
+}
+
+// view выполняет fn над текущим состоянием только для чтения
+func (db *DB) view(ctx context.Context, fn func(s *state) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return fn(db.state)
+}
+
+// listen подписывается на канал уведомлений до отмены ctx
+func (db *DB) listen(ctx context.Context, channel string) <-chan string {
+	ch := make(chan string, 64)
+	db.listenersMu.Lock()
+	if db.listeners[channel] == nil {
+		db.listeners[channel] = make(map[chan string]struct{})
+	}
+	db.listeners[channel][ch] = struct{}{}
+	db.listenersMu.Unlock()
+	go func() {
+		<-ctx.Done()
+		db.listenersMu.Lock()
+		delete(db.listeners[channel], ch)
+		close(ch)
+		db.listenersMu.Unlock()
+	}()
+	return ch
+}
+
+// notify рассылает уведомление подписчикам канала.
+// Если подписчик не успевает читать, уведомление теряется, как и при обрыве LISTEN.
+func (db *DB) notify(channel, payload string) {
+	db.listenersMu.Lock()
+	defer db.listenersMu.Unlock()
+	for ch := range db.listeners[channel] {
+		select {
+		case ch <- payload:
+		default:
+		}
+	}
+}
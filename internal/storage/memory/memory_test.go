@@ -0,0 +1,194 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/pagination"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/auditrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/balancerepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/ordersrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/usersrepo"
+)
+
+func TestStorage(t *testing.T) {
+	ctx := context.Background()
+	store := New(time.Second)
+
+	userID, err := store.Users.CreateUser(ctx, usersrepo.UserInfo{UserLogin: "user", UserPassword: "pass"})
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	if _, err = store.Users.CreateUser(ctx, usersrepo.UserInfo{UserLogin: "user", UserPassword: "other"}); err == nil {
+		t.Errorf("Expected error for duplicate login")
+	}
+	if got, _ := store.Users.LoginUser(ctx, usersrepo.UserInfo{UserLogin: "user", UserPassword: "wrong"}); got != -1 {
+		t.Errorf("Expected -1 for wrong password; got %d", got)
+	}
+	if got, _ := store.Users.LoginUser(ctx, usersrepo.UserInfo{UserLogin: "user", UserPassword: "pass"}); got != userID {
+		t.Errorf("Expected user %d; got %d", userID, got)
+	}
+
+	for _, number := range []string{"12345678903", "9278923470"} {
+		if err = store.Orders.AddOrder(ctx, userID, number); err != nil {
+			t.Fatalf("AddOrder failed: %v", err)
+		}
+		err = store.Orders.UpdateOrder(ctx, userID, ordersrepo.Order{OrderNumber: number, OrderStatus: "PROCESSED", Accrual: 100},
+			ordersrepo.SourcePoller, `{"order":"`+number+`","status":"PROCESSED","accrual":100}`)
+		if err != nil {
+			t.Fatalf("UpdateOrder failed: %v", err)
+		}
+	}
+	if err = store.Orders.AddOrder(ctx, userID+1, "12345678903"); err == nil {
+		t.Errorf("Expected error for duplicate order")
+	}
+
+	balance, _ := store.Balance.GetBalance(ctx, userID)
+	if balance.PointsSum != 200 {
+		t.Errorf("Expected balance 200; got %v", balance.PointsSum)
+	}
+	entry := auditrepo.Entry{Action: auditrepo.ActionWithdraw, Target: auditrepo.TargetOrder("2377225624"), Result: auditrepo.ResultSuccess}
+	balance, err = store.Balance.BalanceWithdraw(ctx, userID, balancerepo.Withdraw{OrderNumber: "2377225624", Sum: 50}, entry)
+	if err != nil {
+		t.Fatalf("BalanceWithdraw failed: %v", err)
+	}
+	if balance.PointsSum != 150 || balance.PointsLoss != 50 {
+		t.Errorf("Expected withdrawal to return balance 150/50; got %v/%v", balance.PointsSum, balance.PointsLoss)
+	}
+	if _, err = store.Balance.BalanceWithdraw(ctx, userID, balancerepo.Withdraw{OrderNumber: "2377225624", Sum: 151}, entry); !errors.Is(err, balancerepo.ErrInsufficientFunds) {
+		t.Errorf("Expected ErrInsufficientFunds; got %v", err)
+	}
+	balance, _ = store.Balance.GetBalance(ctx, userID)
+	if balance.PointsSum != 150 || balance.PointsLoss != 50 {
+		t.Errorf("Expected balance 150/50; got %v/%v", balance.PointsSum, balance.PointsLoss)
+	}
+	operations, _ := store.Ledger.GetOperations(ctx, userID)
+	if len(operations) != 3 {
+		t.Errorf("Expected 3 operations; got %d", len(operations))
+	}
+	// запись аудита добавляется только вместе с проведённым списанием
+	entries, _, _ := store.Audit.ListEntries(ctx, auditrepo.Filter{Action: auditrepo.ActionWithdraw, Params: pagination.Params{Limit: 10}})
+	if len(entries) != 1 {
+		t.Errorf("Expected 1 audit entry for the withdrawal; got %d", len(entries))
+	}
+}
+
+func TestUpdateRollback(t *testing.T) {
+	ctx := context.Background()
+	db := NewDB(time.Second)
+
+	err := db.update(ctx, func(s *state) error {
+		s.orders["12345678903"] = order{userID: 1, orderNumber: "12345678903"}
+		return errors.New("rollback")
+	})
+	if err == nil {
+		t.Fatalf("Expected error")
+	}
+	if orderUID, _ := db.Storage().Orders.GetOrder(ctx, "12345678903"); orderUID != -1 {
+		t.Errorf("Expected changes to be rolled back; got order of user %d", orderUID)
+	}
+}
+
+func TestListenNewOrders(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	store := New(time.Second)
+
+	numbers, err := store.Orders.ListenNewOrders(ctx)
+	if err != nil {
+		t.Fatalf("ListenNewOrders failed: %v", err)
+	}
+	if err = store.Orders.AddOrder(ctx, 1, "12345678903"); err != nil {
+		t.Fatalf("AddOrder failed: %v", err)
+	}
+	// повторная загрузка не создаёт заказ и не рассылает уведомление
+	if err = store.Orders.AddOrder(ctx, 1, "12345678903"); err == nil {
+		t.Errorf("Expected error for duplicate order")
+	}
+	select {
+	case number := <-numbers:
+		if number != "12345678903" {
+			t.Errorf("Expected 12345678903; got %s", number)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected notification about new order")
+	}
+	select {
+	case number := <-numbers:
+		t.Errorf("Unexpected notification %s", number)
+	default:
+	}
+
+	cancel()
+	if _, ok := <-numbers; ok {
+		t.Errorf("Expected channel to be closed after cancel")
+	}
+}
+
+func TestOrderHistory(t *testing.T) {
+	ctx := context.Background()
+	store := New(time.Second)
+
+	if err := store.Orders.AddOrder(ctx, 1, "12345678903"); err != nil {
+		t.Fatalf("AddOrder failed: %v", err)
+	}
+	_, uploaded, _ := store.Orders.GetOrderDetails(ctx, "12345678903")
+
+	updates := []struct {
+		status  ordersrepo.OrderStatus
+		source  string
+		illegal bool
+	}{
+		{ordersrepo.StatusProcessing, ordersrepo.SourcePoller, false},
+		// повтор того же статуса не является переходом
+		{ordersrepo.StatusProcessing, ordersrepo.SourcePoller, false},
+		{ordersrepo.StatusProcessed, ordersrepo.SourceWebhook, false},
+		// повтор конечного статуса ничего не меняет
+		{ordersrepo.StatusProcessed, ordersrepo.SourcePoller, false},
+		// заказ в конечном статусе не меняется
+		{ordersrepo.StatusProcessing, ordersrepo.SourcePoller, true},
+	}
+	for _, u := range updates {
+		err := store.Orders.UpdateOrder(ctx, 1, ordersrepo.Order{OrderNumber: "12345678903", OrderStatus: u.status},
+			u.source, `{"status":"`+string(u.status)+`"}`)
+		if u.illegal && !errors.Is(err, ordersrepo.ErrIllegalTransition) {
+			t.Errorf("%s: expected ErrIllegalTransition; got %v", u.status, err)
+		}
+		if !u.illegal && err != nil {
+			t.Fatalf("UpdateOrder failed: %v", err)
+		}
+	}
+
+	history, err := store.Orders.GetOrderHistory(ctx, "12345678903")
+	if err != nil {
+		t.Fatalf("GetOrderHistory failed: %v", err)
+	}
+	expected := []ordersrepo.HistoryEntry{
+		{Status: ordersrepo.StatusNew, Source: ordersrepo.SourceUpload},
+		{Status: ordersrepo.StatusProcessing, Source: ordersrepo.SourcePoller},
+		{Status: ordersrepo.StatusProcessed, Source: ordersrepo.SourceWebhook},
+	}
+	if len(history) != len(expected) {
+		t.Fatalf("Expected %d history entries; got %+v", len(expected), history)
+	}
+	for i, e := range expected {
+		if history[i].Status != e.Status || history[i].Source != e.Source {
+			t.Errorf("Entry %d: expected %s/%s; got %s/%s", i, e.Status, e.Source, history[i].Status, history[i].Source)
+		}
+	}
+	if string(history[2].AccrualResponse) != `{"status":"PROCESSED"}` {
+		t.Errorf("Expected accrual response to be kept; got %s", history[2].AccrualResponse)
+	}
+
+	_, order, _ := store.Orders.GetOrderDetails(ctx, "12345678903")
+	if !order.UploadedAt.Equal(uploaded.UploadedAt) {
+		t.Errorf("Expected uploaded_at to stay %v; got %v", uploaded.UploadedAt, order.UploadedAt)
+	}
+	if uploaded.LastCheckedAt != nil {
+		t.Errorf("Expected no accrual check for a new order; got %v", uploaded.LastCheckedAt)
+	}
+	if order.LastCheckedAt == nil || !order.UpdatedAt.Equal(history[2].ChangedAt) {
+		t.Errorf("Expected last check and update times to be set; got %v, %v", order.LastCheckedAt, order.UpdatedAt)
+	}
+}
@@ -0,0 +1,354 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/pagination"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/changesrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/ordersrepo"
+)
+
+// Orders — хранилище заказов в памяти
+type Orders struct {
+	db *DB
+}
+
+func (o *Orders) Timeout() time.Duration {
+	return o.db.timeout
+}
+
+func (o *Orders) AddOrder(ctx context.Context, userID int, orderNumber string) error {
+	var change changesrepo.Change
+	now := time.Now()
+	err := o.db.update(ctx, func(s *state) error {
+		if found, ok := s.orders[orderNumber]; ok {
+			return errors.New("order already exists, uid: " + strconv.Itoa(found.userID))
+		}
+		s.orders[orderNumber] = order{
+			userID:      userID,
+			orderNumber: orderNumber,
+			orderStatus: ordersrepo.StatusNew,
+			uploadedAt:  now,
+		}
+		s.addHistory(orderNumber, ordersrepo.StatusNew, ordersrepo.SourceUpload, "", now)
+		change = changesrepo.Change{}
+		if err := change.OrderUploaded(userID, orderNumber, now); err != nil {
+			return err
+		}
+		s.addChange(change)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	o.db.publishChange(change)
+	o.db.notify(ordersrepo.NewOrdersChannel, orderNumber)
+	return nil
+}
+
+// ListenNewOrders подписывается на номера новых заказов, канал закрывается при отмене ctx
+func (o *Orders) ListenNewOrders(ctx context.Context) (<-chan string, error) {
+	return o.db.listen(ctx, ordersrepo.NewOrdersChannel), nil
+}
+
+func (o *Orders) AddOrders(ctx context.Context, userID int, numbers []string) (map[string]string, error) {
+	var (
+		results  map[string]string
+		inserted []string
+		change   changesrepo.Change
+	)
+	now := time.Now()
+	err := o.db.update(ctx, func(s *state) error {
+		results = make(map[string]string, len(numbers))
+		inserted = inserted[:0]
+		change = changesrepo.Change{}
+		for _, number := range numbers {
+			if found, ok := s.orders[number]; ok {
+				results[number] = ordersrepo.BatchConflict
+				if found.userID == userID {
+					results[number] = ordersrepo.BatchAlreadyYours
+				}
+				continue
+			}
+			s.orders[number] = order{
+				userID:      userID,
+				orderNumber: number,
+				orderStatus: ordersrepo.StatusNew,
+				uploadedAt:  now,
+			}
+			s.addHistory(number, ordersrepo.StatusNew, ordersrepo.SourceUpload, "", now)
+			if err := change.OrderUploaded(userID, number, now); err != nil {
+				return err
+			}
+			results[number] = ordersrepo.BatchAccepted
+			inserted = append(inserted, number)
+		}
+		s.addChange(change)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	o.db.publishChange(change)
+	for _, number := range inserted {
+		o.db.notify(ordersrepo.NewOrdersChannel, number)
+	}
+	return results, nil
+}
+
+func (o *Orders) GetOrder(ctx context.Context, orderNumber string) (int, error) {
+	orderUID := -1
+	err := o.db.view(ctx, func(s *state) error {
+		if found, ok := s.orders[orderNumber]; ok {
+			orderUID = found.userID
+		}
+		return nil
+	})
+	return orderUID, err
+}
+
+func (o *Orders) ListOrders(ctx context.Context, userID int, f ordersrepo.ListFilter) ([]ordersrepo.Order, *pagination.Cursor, error) {
+	var val []ordersrepo.Order
+	err := o.db.view(ctx, func(s *state) error {
+		for _, found := range s.orders {
+			if found.userID == userID && found.hasStatus(f.Statuses) && f.Includes(found.toRepo().Position()) {
+				val = append(val, found.toRepo())
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Slice(val, func(i, j int) bool {
+		return f.Less(val[i].Position(), val[j].Position())
+	})
+	val, next := pagination.Trim(val, f.Params, ordersrepo.Order.Position)
+	return val, next, nil
+}
+
+func (o *Orders) GetAwaitOrders(ctx context.Context) ([]ordersrepo.Order, error) {
+	var val []ordersrepo.Order
+	now := time.Now()
+	err := o.db.view(ctx, func(s *state) error {
+		for _, found := range s.orders {
+			if found.orderStatus.Pending() && !found.nextAttemptAt.After(now) {
+				val = append(val, found.toRepo())
+			}
+		}
+		return nil
+	})
+	sort.Slice(val, func(i, j int) bool {
+		return val[i].UploadedAt.Before(val[j].UploadedAt)
+	})
+	return val, err
+}
+
+func (o *Orders) UpdateOrder(ctx context.Context, orderUID int, updated ordersrepo.Order, source string, response string) error {
+	var change changesrepo.Change
+	err := o.db.update(ctx, func(s *state) error {
+		change = changesrepo.Change{}
+		found, ok := s.orders[updated.OrderNumber]
+		if !ok || found.userID != orderUID {
+			return nil
+		}
+		if found.orderStatus.Final() && found.orderStatus == updated.OrderStatus {
+			return nil
+		}
+		if err := ordersrepo.CheckTransition(found.orderStatus, updated.OrderStatus); err != nil {
+			return err
+		}
+		now := time.Now()
+		if updated.Accrual != 0 {
+			s.operations = append(s.operations, operation{
+				userID:         orderUID,
+				orderNumber:    updated.OrderNumber,
+				pointsQuantity: updated.Accrual,
+				processedAt:    now,
+			})
+		}
+		if found.orderStatus != updated.OrderStatus {
+			s.addHistory(updated.OrderNumber, updated.OrderStatus, source, response, now)
+		}
+		if err := change.OrderStatusChanged(ordersrepo.StatusChange(found.orderStatus, orderUID, updated, source, now)); err != nil {
+			return err
+		}
+		found.orderStatus = updated.OrderStatus
+		found.accrual = updated.Accrual
+		found.attempts = 0
+		found.nextAttemptAt = time.Time{}
+		found.lastError = ""
+		found.lastCheckedAt = now
+		s.orders[updated.OrderNumber] = found
+
+		if balance, ok := s.balances[orderUID]; ok {
+			balance.PointsSum += updated.Accrual
+			s.balances[orderUID] = balance
+			if updated.Accrual != 0 {
+				err := change.BalanceChanged(changesrepo.Balance{
+					UserID:    orderUID,
+					Order:     updated.OrderNumber,
+					Sum:       updated.Accrual,
+					Current:   balance.PointsSum,
+					Withdrawn: balance.PointsLoss,
+					At:        now,
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+		s.addChange(change)
+		return nil
+	})
+	if err == nil {
+		o.db.publishChange(change)
+	}
+	return err
+}
+
+func (o *Orders) RetryOrder(ctx context.Context, orderNumber string, attempts int, nextAttemptAt time.Time, lastError string) error {
+	return o.db.update(ctx, func(s *state) error {
+		found, ok := s.orders[orderNumber]
+		if !ok || !found.orderStatus.Pending() {
+			return nil
+		}
+		found.attempts = attempts
+		found.nextAttemptAt = nextAttemptAt
+		found.lastError = lastError
+		found.lastCheckedAt = time.Now()
+		s.orders[orderNumber] = found
+		return nil
+	})
+}
+
+func (o *Orders) FailOrder(ctx context.Context, orderNumber string, attempts int, lastError string) error {
+	return o.db.update(ctx, func(s *state) error {
+		found, ok := s.orders[orderNumber]
+		if !ok || !found.orderStatus.Pending() {
+			return nil
+		}
+		now := time.Now()
+		found.orderStatus = ordersrepo.StatusFailed
+		found.attempts = attempts
+		found.nextAttemptAt = time.Time{}
+		found.lastError = lastError
+		found.lastCheckedAt = now
+		s.orders[orderNumber] = found
+		s.addHistory(orderNumber, ordersrepo.StatusFailed, ordersrepo.SourcePoller, "", now)
+		return nil
+	})
+}
+
+func (o *Orders) GetFailedOrders(ctx context.Context) ([]ordersrepo.FailedOrder, error) {
+	var val []ordersrepo.FailedOrder
+	err := o.db.view(ctx, func(s *state) error {
+		for _, found := range s.orders {
+			if found.orderStatus == ordersrepo.StatusFailed {
+				val = append(val, ordersrepo.FailedOrder{
+					UserID:      found.userID,
+					OrderNumber: found.orderNumber,
+					Attempts:    found.attempts,
+					LastError:   found.lastError,
+					UploadedAt:  found.uploadedAt,
+				})
+			}
+		}
+		return nil
+	})
+	sort.Slice(val, func(i, j int) bool {
+		return val[i].UploadedAt.Before(val[j].UploadedAt)
+	})
+	return val, err
+}
+
+func (o *Orders) RequeueOrder(ctx context.Context, orderNumber string) (bool, error) {
+	requeued := false
+	err := o.db.update(ctx, func(s *state) error {
+		found, ok := s.orders[orderNumber]
+		if !ok || found.orderStatus != ordersrepo.StatusFailed {
+			return nil
+		}
+		found.orderStatus = ordersrepo.StatusNew
+		found.attempts = 0
+		found.nextAttemptAt = time.Time{}
+		found.lastError = ""
+		s.orders[orderNumber] = found
+		s.addHistory(orderNumber, ordersrepo.StatusNew, ordersrepo.SourceAdmin, "", time.Now())
+		requeued = true
+		return nil
+	})
+	if err != nil || !requeued {
+		return false, err
+	}
+	o.db.notify(ordersrepo.NewOrdersChannel, orderNumber)
+	return true, nil
+}
+
+func (o *Orders) GetOrderDetails(ctx context.Context, orderNumber string) (int, ordersrepo.OrderDetails, error) {
+	orderUID := -1
+	var val ordersrepo.OrderDetails
+	err := o.db.view(ctx, func(s *state) error {
+		found, ok := s.orders[orderNumber]
+		if !ok {
+			return nil
+		}
+		orderUID = found.userID
+		val.Order = found.toRepo()
+		val.UpdatedAt = found.uploadedAt
+		if h := s.history[orderNumber]; len(h) > 0 {
+			val.UpdatedAt = h[len(h)-1].ChangedAt
+		}
+		if !found.lastCheckedAt.IsZero() {
+			checked := found.lastCheckedAt
+			val.LastCheckedAt = &checked
+		}
+		return nil
+	})
+	return orderUID, val, err
+}
+
+func (o *Orders) GetOrderHistory(ctx context.Context, orderNumber string) ([]ordersrepo.HistoryEntry, error) {
+	var val []ordersrepo.HistoryEntry
+	err := o.db.view(ctx, func(s *state) error {
+		val = append(val, s.history[orderNumber]...)
+		return nil
+	})
+	return val, err
+}
+
+func (s *state) addHistory(orderNumber string, status ordersrepo.OrderStatus, source, response string, changedAt time.Time) {
+	entry := ordersrepo.HistoryEntry{Status: status, Source: source, ChangedAt: changedAt}
+	if response != "" {
+		entry.AccrualResponse = json.RawMessage(response)
+	}
+	s.history[orderNumber] = append(s.history[orderNumber], entry)
+}
+
+// hasStatus сообщает, что заказ в одном из статусов statuses; пустой список подходит для любого
+func (found order) hasStatus(statuses []ordersrepo.OrderStatus) bool {
+	if len(statuses) == 0 {
+		return true
+	}
+	for _, status := range statuses {
+		if found.orderStatus == status {
+			return true
+		}
+	}
+	return false
+}
+
+func (found order) toRepo() ordersrepo.Order {
+	return ordersrepo.Order{
+		OrderNumber: found.orderNumber,
+		OrderStatus: found.orderStatus,
+		Accrual:     found.accrual,
+		UploadedAt:  found.uploadedAt,
+		Attempts:    found.attempts,
+	}
+}
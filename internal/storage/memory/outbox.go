@@ -0,0 +1,89 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/outboxrepo"
+)
+
+// Outbox — исходящие доменные события в памяти
+type Outbox struct {
+	db *DB
+}
+
+func (ob *Outbox) Timeout() time.Duration {
+	return ob.db.timeout
+}
+
+func (ob *Outbox) Relay(ctx context.Context, limit int, publish func(ctx context.Context, e outboxrepo.Event) error) (int, error) {
+	// аналог advisory lock: события передаёт один обработчик
+	ob.db.relayMu.Lock()
+	defer ob.db.relayMu.Unlock()
+
+	var events []outboxrepo.Event
+	err := ob.db.view(ctx, func(s *state) error {
+		for _, e := range s.outbox {
+			if e.publishedAt.IsZero() {
+				events = append(events, e.Event)
+				if len(events) == limit {
+					break
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	published := make([]int64, 0, len(events))
+	var publishErr error
+	for _, e := range events {
+		if publishErr = publish(ctx, e); publishErr != nil {
+			break
+		}
+		published = append(published, e.ID)
+	}
+	if len(published) > 0 {
+		now := time.Now()
+		err = ob.db.update(ctx, func(s *state) error {
+			for i := range s.outbox {
+				if slices.Contains(published, s.outbox[i].ID) {
+					s.outbox[i].publishedAt = now
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(published), publishErr
+}
+
+func (ob *Outbox) Purge(ctx context.Context, before time.Time) (int64, error) {
+	var purged int64
+	err := ob.db.update(ctx, func(s *state) error {
+		purged = 0
+		s.outbox = slices.DeleteFunc(s.outbox, func(e outboxEvent) bool {
+			if !e.publishedAt.IsZero() && e.publishedAt.Before(before) {
+				purged++
+				return true
+			}
+			return false
+		})
+		return nil
+	})
+	return purged, err
+}
+
+// addOutbox записывает доменные события в порядке перечисления
+func (s *state) addOutbox(events ...outboxrepo.Event) {
+	for _, e := range events {
+		s.lastOutboxID++
+		e.ID = s.lastOutboxID
+		s.outbox = append(s.outbox, outboxEvent{Event: e})
+	}
+}
@@ -0,0 +1,165 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/balancerepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/changesrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/usersrepo"
+)
+
+// Users — хранилище пользователей в памяти
+type Users struct {
+	db *DB
+}
+
+func (ur *Users) Timeout() time.Duration {
+	return ur.db.timeout
+}
+
+func (ur *Users) CreateUser(ctx context.Context, u usersrepo.UserInfo) (int, error) {
+	var change changesrepo.Change
+	userID := -1
+	err := ur.db.update(ctx, func(s *state) error {
+		if _, ok := s.users[u.UserLogin]; ok {
+			return errors.New("user already exists with this login")
+		}
+		s.lastUserID++
+		userID = s.lastUserID
+		s.users[u.UserLogin] = user{
+			userID:       userID,
+			userLogin:    u.UserLogin,
+			userPassword: usersrepo.HashPassword(u.UserPassword),
+			role:         usersrepo.RoleUser,
+		}
+		s.balances[userID] = balancerepo.Balance{}
+		change = changesrepo.Change{}
+		if err := change.UserCreated(userID, u.UserLogin, time.Now()); err != nil {
+			return err
+		}
+		s.addChange(change)
+		return nil
+	})
+	if err != nil {
+		return -1, err
+	}
+	ur.db.publishChange(change)
+	return userID, nil
+}
+
+func (ur *Users) GetUser(ctx context.Context, u usersrepo.UserInfo) (int, error) {
+	if u.UserLogin == "" || u.UserPassword == "" {
+		return -1, errors.New("user or pass is empty")
+	}
+	userID := -1
+	err := ur.db.view(ctx, func(s *state) error {
+		if found, ok := s.users[u.UserLogin]; ok {
+			userID = found.userID
+		}
+		return nil
+	})
+	return userID, err
+}
+
+func (ur *Users) LoginUser(ctx context.Context, u usersrepo.UserInfo) (int, error) {
+	if u.UserLogin == "" || u.UserPassword == "" {
+		return -1, errors.New("user or pass is empty")
+	}
+	userID := -1
+	err := ur.db.view(ctx, func(s *state) error {
+		found, ok := s.users[u.UserLogin]
+		if ok && found.userPassword == usersrepo.HashPassword(u.UserPassword) {
+			userID = found.userID
+		}
+		return nil
+	})
+	return userID, err
+}
+
+func (s *state) account(u user) usersrepo.Account {
+	b := s.balances[u.userID]
+	return usersrepo.Account{
+		ID:         u.userID,
+		Login:      u.userLogin,
+		Role:       u.role,
+		DisabledAt: u.disabledAt,
+		Current:    b.PointsSum,
+		Withdrawn:  b.PointsLoss,
+	}
+}
+
+// userByID возвращает логин пользователя; false — пользователь не найден
+func (s *state) userByID(userID int) (string, bool) {
+	for login, u := range s.users {
+		if u.userID == userID {
+			return login, true
+		}
+	}
+	return "", false
+}
+
+func (ur *Users) GetAccount(ctx context.Context, userID int) (usersrepo.Account, bool, error) {
+	var (
+		val   usersrepo.Account
+		found bool
+	)
+	err := ur.db.view(ctx, func(s *state) error {
+		var login string
+		if login, found = s.userByID(userID); found {
+			val = s.account(s.users[login])
+		}
+		return nil
+	})
+	return val, found, err
+}
+
+func (ur *Users) SearchUsers(ctx context.Context, login string, limit int) ([]usersrepo.Account, error) {
+	var val []usersrepo.Account
+	err := ur.db.view(ctx, func(s *state) error {
+		for _, u := range s.users {
+			if strings.Contains(strings.ToLower(u.userLogin), strings.ToLower(login)) {
+				val = append(val, s.account(u))
+			}
+		}
+		return nil
+	})
+	sort.Slice(val, func(i, j int) bool {
+		return val[i].ID < val[j].ID
+	})
+	if len(val) > limit {
+		val = val[:limit]
+	}
+	return val, err
+}
+
+func (ur *Users) SetRole(ctx context.Context, userID int, role string) (bool, error) {
+	var found bool
+	err := ur.db.update(ctx, func(s *state) error {
+		var login string
+		if login, found = s.userByID(userID); found {
+			u := s.users[login]
+			u.role = role
+			s.users[login] = u
+		}
+		return nil
+	})
+	return found, err
+}
+
+func (ur *Users) SetDisabled(ctx context.Context, userID int, disabledAt *time.Time) (bool, error) {
+	var found bool
+	err := ur.db.update(ctx, func(s *state) error {
+		var login string
+		if login, found = s.userByID(userID); found {
+			u := s.users[login]
+			u.disabledAt = disabledAt
+			s.users[login] = u
+		}
+		return nil
+	})
+	return found, err
+}
@@ -0,0 +1,210 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/pagination"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/webhooksrepo"
+)
+
+// Webhooks — адреса уведомлений и очередь их доставки в памяти
+type Webhooks struct {
+	db *DB
+}
+
+func (wh *Webhooks) Timeout() time.Duration {
+	return wh.db.timeout
+}
+
+func (wh *Webhooks) CreateWebhook(ctx context.Context, userID int, w webhooksrepo.Webhook) (webhooksrepo.Webhook, error) {
+	err := wh.db.update(ctx, func(s *state) error {
+		count := 0
+		for _, found := range s.webhooks {
+			if found.userID == userID {
+				count++
+			}
+		}
+		if count >= webhooksrepo.MaxWebhooks {
+			return webhooksrepo.ErrTooManyWebhooks
+		}
+		s.lastHookID++
+		w.ID = s.lastHookID
+		s.webhooks[w.ID] = webhook{userID: userID, Webhook: w}
+		return nil
+	})
+	return w, err
+}
+
+func (wh *Webhooks) ListWebhooks(ctx context.Context, userID int) ([]webhooksrepo.Webhook, error) {
+	var val []webhooksrepo.Webhook
+	err := wh.db.view(ctx, func(s *state) error {
+		for _, found := range s.webhooks {
+			if found.userID == userID {
+				w := found.Webhook
+				w.Secret = ""
+				val = append(val, w)
+			}
+		}
+		return nil
+	})
+	sort.Slice(val, func(i, j int) bool {
+		return val[i].ID < val[j].ID
+	})
+	return val, err
+}
+
+func (wh *Webhooks) DeleteWebhook(ctx context.Context, userID int, webhookID int64) (bool, error) {
+	deleted := false
+	err := wh.db.update(ctx, func(s *state) error {
+		if found, ok := s.webhooks[webhookID]; !ok || found.userID != userID {
+			return nil
+		}
+		delete(s.webhooks, webhookID)
+		s.deliveries = slices.DeleteFunc(s.deliveries, func(d delivery) bool {
+			return d.webhookID == webhookID
+		})
+		deleted = true
+		return nil
+	})
+	return deleted, err
+}
+
+func (wh *Webhooks) GetWebhookOwner(ctx context.Context, webhookID int64) (int, error) {
+	userID := -1
+	err := wh.db.view(ctx, func(s *state) error {
+		if found, ok := s.webhooks[webhookID]; ok {
+			userID = found.userID
+		}
+		return nil
+	})
+	return userID, err
+}
+
+func (wh *Webhooks) ListDeliveries(ctx context.Context, webhookID int64, p pagination.Params) ([]webhooksrepo.Delivery, *pagination.Cursor, error) {
+	var val []webhooksrepo.Delivery
+	err := wh.db.view(ctx, func(s *state) error {
+		for _, d := range s.deliveries {
+			if d.webhookID == webhookID && p.Includes(d.Position()) {
+				entry := d.Delivery
+				if entry.Status == webhooksrepo.DeliveryPending {
+					next := d.nextAttemptAt
+					entry.NextAttemptAt = &next
+				}
+				val = append(val, entry)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Slice(val, func(i, j int) bool {
+		return p.Less(val[i].Position(), val[j].Position())
+	})
+	val, next := pagination.Trim(val, p, webhooksrepo.Delivery.Position)
+	return val, next, nil
+}
+
+func (wh *Webhooks) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]webhooksrepo.PendingDelivery, error) {
+	var val []webhooksrepo.PendingDelivery
+	err := wh.db.update(ctx, func(s *state) error {
+		val = val[:0]
+		now := time.Now()
+		var due []int
+		for i, d := range s.deliveries {
+			if d.Status == webhooksrepo.DeliveryPending && !d.nextAttemptAt.After(now) {
+				due = append(due, i)
+			}
+		}
+		sort.SliceStable(due, func(i, j int) bool {
+			return s.deliveries[due[i]].nextAttemptAt.Before(s.deliveries[due[j]].nextAttemptAt)
+		})
+		for _, i := range due[:min(limit, len(due))] {
+			d := &s.deliveries[i]
+			d.nextAttemptAt = now.Add(lease)
+			hook := s.webhooks[d.webhookID]
+			val = append(val, webhooksrepo.PendingDelivery{
+				ID:        d.ID,
+				EventType: d.EventType,
+				Payload:   d.Payload,
+				Attempts:  d.Attempts,
+				URL:       hook.URL,
+				Secret:    hook.Secret,
+			})
+		}
+		return nil
+	})
+	return val, err
+}
+
+func (wh *Webhooks) MarkDelivered(ctx context.Context, deliveryID int64, attempts int, responseCode int) error {
+	return wh.updateDelivery(ctx, deliveryID, func(d *delivery) {
+		now := time.Now()
+		d.Status = webhooksrepo.DeliveryDelivered
+		d.Attempts = attempts
+		d.ResponseCode = responseCode
+		d.LastError = ""
+		d.DeliveredAt = &now
+	})
+}
+
+func (wh *Webhooks) RetryDelivery(ctx context.Context, deliveryID int64, attempts int, nextAttemptAt time.Time, responseCode int, lastError string) error {
+	return wh.updateDelivery(ctx, deliveryID, func(d *delivery) {
+		d.Attempts = attempts
+		d.nextAttemptAt = nextAttemptAt
+		d.ResponseCode = responseCode
+		d.LastError = lastError
+	})
+}
+
+func (wh *Webhooks) FailDelivery(ctx context.Context, deliveryID int64, attempts int, responseCode int, lastError string) error {
+	return wh.updateDelivery(ctx, deliveryID, func(d *delivery) {
+		d.Status = webhooksrepo.DeliveryFailed
+		d.Attempts = attempts
+		d.ResponseCode = responseCode
+		d.LastError = lastError
+	})
+}
+
+func (wh *Webhooks) updateDelivery(ctx context.Context, deliveryID int64, fn func(d *delivery)) error {
+	return wh.db.update(ctx, func(s *state) error {
+		for i := range s.deliveries {
+			if s.deliveries[i].ID == deliveryID {
+				fn(&s.deliveries[i])
+				break
+			}
+		}
+		return nil
+	})
+}
+
+// enqueueWebhooks ставит уведомление о событии в очередь доставки на адреса пользователя,
+// подписанные на тип события
+func (s *state) enqueueWebhooks(userID int, event webhooksrepo.Event) {
+	payload, _ := json.Marshal(event) //nolint
+	ids := make([]int64, 0, len(s.webhooks))
+	for id, w := range s.webhooks {
+		if w.userID == userID && slices.Contains(w.Events, event.Type) {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	for _, id := range ids {
+		s.lastDeliveryID++
+		s.deliveries = append(s.deliveries, delivery{
+			webhookID:     id,
+			nextAttemptAt: event.At,
+			Delivery: webhooksrepo.Delivery{
+				ID:        s.lastDeliveryID,
+				EventType: event.Type,
+				Payload:   payload,
+				Status:    webhooksrepo.DeliveryPending,
+				CreatedAt: event.At,
+			},
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/beliaevke/go-musthave-diploma/internal/db/postgres"
+	"github.com/beliaevke/go-musthave-diploma/internal/pagination"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/auditrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/balancerepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/callbacksrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/eventsrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/lockoutrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/ordersrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/outboxrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/usersrepo"
+	"github.com/beliaevke/go-musthave-diploma/internal/repository/webhooksrepo"
+)
+
+// Users — хранилище пользователей
+type Users interface {
+	CreateUser(ctx context.Context, u usersrepo.UserInfo) (int, error)
+	GetUser(ctx context.Context, u usersrepo.UserInfo) (int, error)
+	LoginUser(ctx context.Context, u usersrepo.UserInfo) (int, error)
+	GetAccount(ctx context.Context, userID int) (usersrepo.Account, bool, error)
+	SearchUsers(ctx context.Context, login string, limit int) ([]usersrepo.Account, error)
+	SetRole(ctx context.Context, userID int, role string) (bool, error)
+	SetDisabled(ctx context.Context, userID int, disabledAt *time.Time) (bool, error)
+	Timeout() time.Duration
+}
+
+// Orders — хранилище заказов
+type Orders interface {
+	AddOrder(ctx context.Context, userID int, orderNumber string) error
+	AddOrders(ctx context.Context, userID int, numbers []string) (map[string]string, error)
+	GetOrder(ctx context.Context, orderNumber string) (int, error)
+	ListOrders(ctx context.Context, userID int, f ordersrepo.ListFilter) ([]ordersrepo.Order, *pagination.Cursor, error)
+	GetAwaitOrders(ctx context.Context) ([]ordersrepo.Order, error)
+	UpdateOrder(ctx context.Context, orderUID int, order ordersrepo.Order, source string, response string) error
+	GetOrderDetails(ctx context.Context, orderNumber string) (int, ordersrepo.OrderDetails, error)
+	GetOrderHistory(ctx context.Context, orderNumber string) ([]ordersrepo.HistoryEntry, error)
+	RetryOrder(ctx context.Context, orderNumber string, attempts int, nextAttemptAt time.Time, lastError string) error
+	FailOrder(ctx context.Context, orderNumber string, attempts int, lastError string) error
+	GetFailedOrders(ctx context.Context) ([]ordersrepo.FailedOrder, error)
+	RequeueOrder(ctx context.Context, orderNumber string) (bool, error)
+	ListenNewOrders(ctx context.Context) (<-chan string, error)
+	Timeout() time.Duration
+}
+
+// Balance — хранилище балансов пользователей
+type Balance interface {
+	GetBalance(ctx context.Context, userID int) (balancerepo.Balance, error)
+	BalanceWithdraw(ctx context.Context, userID int, withdraw balancerepo.Withdraw, entry auditrepo.Entry) (balancerepo.Balance, error)
+	RequestAdjustment(ctx context.Context, a balancerepo.Adjustment, entry balancerepo.AuditEntry) (balancerepo.Adjustment, error)
+	GetAdjustment(ctx context.Context, adjustmentID int64) (balancerepo.Adjustment, error)
+	ListAdjustments(ctx context.Context, f balancerepo.AdjustmentFilter) ([]balancerepo.Adjustment, *pagination.Cursor, error)
+	ReviewAdjustment(ctx context.Context, adjustmentID int64, r balancerepo.Review, entry balancerepo.AuditEntry) (balancerepo.Adjustment, balancerepo.Balance, error)
+	ListWithdrawals(ctx context.Context, userID int, p pagination.Params) ([]balancerepo.Withdrawals, *pagination.Cursor, error)
+	Timeout() time.Duration
+}
+
+// Ledger — журнал начислений и списаний баллов
+type Ledger interface {
+	GetOperations(ctx context.Context, userID int) ([]balancerepo.Operation, error)
+	Timeout() time.Duration
+}
+
+// Callbacks — подписи принятых уведомлений системы начислений (защита от повтора)
+type Callbacks interface {
+	RememberCallback(ctx context.Context, signature string, receivedAt time.Time, expireBefore time.Time) (bool, error)
+	ForgetCallback(ctx context.Context, signature string) error
+	Timeout() time.Duration
+}
+
+// Events — события пользователей для потока GET /api/user/events и панели администратора
+type Events interface {
+	ListEvents(ctx context.Context, userID int, afterID int64, limit int) ([]eventsrepo.Event, error)
+	LastEventID(ctx context.Context, userID int) (int64, error)
+	ListenEvents(ctx context.Context) (<-chan int, error)
+	ListenAdminEvents(ctx context.Context) (<-chan eventsrepo.AdminEvent, error)
+	Timeout() time.Duration
+}
+
+// Webhooks — адреса пользователей для уведомлений и очередь их доставки
+type Webhooks interface {
+	CreateWebhook(ctx context.Context, userID int, w webhooksrepo.Webhook) (webhooksrepo.Webhook, error)
+	ListWebhooks(ctx context.Context, userID int) ([]webhooksrepo.Webhook, error)
+	DeleteWebhook(ctx context.Context, userID int, webhookID int64) (bool, error)
+	GetWebhookOwner(ctx context.Context, webhookID int64) (int, error)
+	ListDeliveries(ctx context.Context, webhookID int64, p pagination.Params) ([]webhooksrepo.Delivery, *pagination.Cursor, error)
+	ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]webhooksrepo.PendingDelivery, error)
+	MarkDelivered(ctx context.Context, deliveryID int64, attempts int, responseCode int) error
+	RetryDelivery(ctx context.Context, deliveryID int64, attempts int, nextAttemptAt time.Time, responseCode int, lastError string) error
+	FailDelivery(ctx context.Context, deliveryID int64, attempts int, responseCode int, lastError string) error
+	Timeout() time.Duration
+}
+
+// Outbox — исходящие доменные события
+type Outbox interface {
+	Relay(ctx context.Context, limit int, publish func(ctx context.Context, e outboxrepo.Event) error) (int, error)
+	Purge(ctx context.Context, before time.Time) (int64, error)
+	Timeout() time.Duration
+}
+
+// Audit — журнал аудита
+type Audit interface {
+	Record(ctx context.Context, e auditrepo.Entry) error
+	ListEntries(ctx context.Context, f auditrepo.Filter) ([]auditrepo.Entry, *pagination.Cursor, error)
+	Verify(ctx context.Context) (auditrepo.Verification, error)
+	Timeout() time.Duration
+}
+
+// Lockouts — счётчики неудачных входов и блокировки входа
+type Lockouts interface {
+	Attempt(ctx context.Context, limits []lockoutrepo.Limit, check func(ctx context.Context) (bool, error)) (time.Time, error)
+	ListLockouts(ctx context.Context, now time.Time) ([]lockoutrepo.Attempts, error)
+	ClearLockout(ctx context.Context, key string) (bool, error)
+	Timeout() time.Duration
+}
+
+// Storage объединяет хранилища сервиса.
+// Реализации: Postgres (NewPostgres) и память (пакет memory).
+type Storage struct {
+	Users     Users
+	Orders    Orders
+	Balance   Balance
+	Ledger    Ledger
+	Callbacks Callbacks
+	Events    Events
+	Webhooks  Webhooks
+	Outbox    Outbox
+	Audit     Audit
+	Lockouts  Lockouts
+}
+
+// NewPostgres возвращает хранилища, работающие с базой Postgres
+func NewPostgres(db *postgres.DB) Storage {
+	balance := balancerepo.NewBalance(db)
+	return Storage{
+		Users:     usersrepo.NewUser(db),
+		Orders:    ordersrepo.NewOrder(db),
+		Balance:   balance,
+		Ledger:    balance,
+		Callbacks: callbacksrepo.NewCallback(db),
+		Events:    eventsrepo.NewEvents(db),
+		Webhooks:  webhooksrepo.NewWebhooks(db),
+		Outbox:    outboxrepo.NewOutbox(db),
+		Audit:     auditrepo.NewAudit(db),
+		Lockouts:  lockoutrepo.NewLockouts(db),
+	}
+}